@@ -0,0 +1,154 @@
+// Package auth authenticates gRPC callers and passes their identity
+// via context.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+// Identity of authenticated caller.
+type Identity struct {
+	// Subject identifies caller, e.g. user or service name.
+	Subject string
+	// Admin is true for callers with administrative privileges within
+	// their tenant.
+	Admin bool
+	// Tenant is id of organization to which caller belongs. Caller can
+	// access only users of its tenant. Empty means tenant.Default.
+	Tenant string
+}
+
+// Anonymous is identity of all callers when authentication is disabled.
+// It has no administrative privileges.
+var Anonymous = Identity{Subject: "anonymous", Tenant: tenant.Default}
+
+// AnonymousAdmin is identity of all callers authenticated by
+// AnonymousAdmins.
+var AnonymousAdmin = Identity{Subject: "anonymous", Admin: true, Tenant: tenant.Default}
+
+// AnonymousAdmins is authenticator treating every caller as AnonymousAdmin.
+// It is meant only for development and tests, as any caller can then e.g.
+// erase users.
+var AnonymousAdmins Authenticator = anonymousAdmins{}
+
+type anonymousAdmins struct{}
+
+func (anonymousAdmins) Authenticate(context.Context) (Identity, error) {
+	return AnonymousAdmin, nil
+}
+
+type ctxKey struct{}
+
+// NewContext returns context carrying given identity.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns identity of caller.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(Identity)
+	return id, ok
+}
+
+// Authenticator returns identity of caller of incoming request.
+type Authenticator interface {
+	Authenticate(context.Context) (Identity, error)
+}
+
+// UnaryServerInterceptor authenticates every call and stores identity
+// along with its tenant in its context. Nil authenticator disables
+// authentication, then all callers are Anonymous.
+func UnaryServerInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor(a Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), a)
+		if err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+func authenticate(ctx context.Context, a Authenticator) (context.Context, error) {
+	id := Anonymous
+	if a != nil {
+		var err error
+		if id, err = a.Authenticate(ctx); err != nil {
+			return nil, grpc.Errorf(codes.Unauthenticated, "failed to authenticate: %v", err)
+		}
+	}
+	if id.Tenant == "" {
+		id.Tenant = tenant.Default
+	}
+	return tenant.NewContext(NewContext(ctx, id), id.Tenant), nil
+}
+
+// StaticTokens authenticates callers by bearer token passed in
+// "authorization" metadata.
+type StaticTokens map[string]Identity
+
+// ParseStaticTokens parses comma separated list of "token=subject" entries.
+// Subject can be prefixed with "tenant/" to assign caller to tenant other
+// than default one and suffixed with ":admin" to grant administrative
+// privileges, e.g. "secret=acme/support:admin".
+func ParseStaticTokens(in string) (StaticTokens, error) {
+	out := StaticTokens{}
+	for _, entry := range strings.Split(in, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid token entry, expected token=[tenant/]subject[:admin]")
+		}
+		id := Identity{Subject: parts[1], Tenant: tenant.Default}
+		if strings.HasSuffix(id.Subject, ":admin") {
+			id.Subject = strings.TrimSuffix(id.Subject, ":admin")
+			id.Admin = true
+		}
+		if i := strings.Index(id.Subject, "/"); i >= 0 {
+			id.Tenant, id.Subject = id.Subject[:i], id.Subject[i+1:]
+			if id.Tenant == "" || id.Subject == "" {
+				return nil, fmt.Errorf("invalid token entry, expected token=[tenant/]subject[:admin]")
+			}
+		}
+		out[parts[0]] = id
+	}
+	return out, nil
+}
+
+func (t StaticTokens) Authenticate(ctx context.Context) (Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return Identity{}, fmt.Errorf("missing authorization metadata")
+	}
+	token := strings.TrimPrefix(values[0], "Bearer ")
+	id, ok := t[token]
+	if !ok {
+		return Identity{}, fmt.Errorf("invalid token")
+	}
+	return id, nil
+}
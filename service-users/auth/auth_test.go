@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+func TestStaticTokens(t *testing.T) {
+	tokens, err := ParseStaticTokens("secret-1=support:admin, secret-2=importer, secret-4=acme/support:admin")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testCases := []struct {
+		desc   string
+		md     metadata.MD
+		exp    Identity
+		expErr string
+	}{
+		{
+			desc: "admin token",
+			md:   metadata.Pairs("authorization", "Bearer secret-1"),
+			exp:  Identity{Subject: "support", Admin: true, Tenant: tenant.Default},
+		},
+		{
+			desc: "token of other tenant",
+			md:   metadata.Pairs("authorization", "Bearer secret-4"),
+			exp:  Identity{Subject: "support", Admin: true, Tenant: "acme"},
+		},
+		{
+			desc: "regular token",
+			md:   metadata.Pairs("authorization", "Bearer secret-2"),
+			exp:  Identity{Subject: "importer", Tenant: tenant.Default},
+		},
+		{
+			desc:   "unknown token",
+			md:     metadata.Pairs("authorization", "Bearer secret-3"),
+			expErr: "invalid token",
+		},
+		{
+			desc:   "missing metadata",
+			expErr: "missing authorization metadata",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := tokens.Authenticate(metadata.NewIncomingContext(context.Background(), tC.md))
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tC.expErr, gotErr); diff != "" {
+				t.Errorf("Error mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.exp, got); diff != "" {
+				t.Errorf("Identity mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestParseStaticTokensInvalid(t *testing.T) {
+	for _, in := range []string{"secret-1", "secret-1=/support", "secret-1=acme/"} {
+		if _, err := ParseStaticTokens(in); err == nil {
+			t.Errorf("Expected error for entry %q", in)
+		}
+	}
+}
+
+func TestUnaryServerInterceptorSetsTenant(t *testing.T) {
+	tokens := StaticTokens{"secret": {Subject: "importer"}}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	var got string
+	_, err := UnaryServerInterceptor(tokens)(ctx, nil, nil, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		got, _ = tenant.FromContext(ctx)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != tenant.Default {
+		t.Errorf("Expected identity without tenant to get default tenant, got: %q", got)
+	}
+}
+
+func TestUnaryServerInterceptorAnonymous(t *testing.T) {
+	testCases := []struct {
+		desc string
+		a    Authenticator
+		exp  Identity
+	}{
+		{
+			desc: "authentication disabled",
+			exp:  Identity{Subject: "anonymous", Tenant: tenant.Default},
+		},
+		{
+			desc: "anonymous admins",
+			a:    AnonymousAdmins,
+			exp:  Identity{Subject: "anonymous", Admin: true, Tenant: tenant.Default},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var got Identity
+			_, err := UnaryServerInterceptor(tC.a)(context.Background(), nil, nil, func(ctx context.Context, _ interface{}) (interface{}, error) {
+				got, _ = FromContext(ctx)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tC.exp, got); diff != "" {
+				t.Errorf("Identity mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Package cache provides read-through cache of users.
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+var requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "users_cache_requests_total",
+	Help: "Total number of GetUser cache lookups by result (hit or miss).",
+}, []string{"result"})
+
+type storer interface {
+	CreateUser(context.Context, *store.User) (*store.User, error)
+	UpdateUser(context.Context, *store.User) (*store.User, error)
+	GetUser(context.Context, string, ...store.ReadOption) (*store.User, error)
+	ListUsers(ctx context.Context, filter store.ListUsersFilter, afterID string, limit int, opts ...store.ReadOption) ([]*store.User, error)
+	DeleteUser(context.Context, string) (*store.User, error)
+	UndeleteUser(context.Context, string) (*store.User, error)
+	ListUserHistory(ctx context.Context, userID string, beforeID int64, limit int) ([]*store.AuditEntry, error)
+	BatchGetUsers(context.Context, []string) ([]*store.User, error)
+	BatchCreateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchUpdateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchGetUsersByEmail(context.Context, []string) ([]*store.User, error)
+	GetUserData(context.Context, string) (*store.UserData, error)
+	EraseUser(ctx context.Context, id, reason string) (*store.Erasure, error)
+}
+
+// Config configures cache of users.
+type Config struct {
+	// Size is max number of cached users.
+	Size int
+	// TTL is how long found user is cached.
+	TTL time.Duration
+	// NegativeTTL is how long missing user is cached.
+	NegativeTTL time.Duration
+}
+
+// Storer caches results of GetUser of next storer, all other methods are
+// passed through. Cached user is invalidated when it is changed through
+// Storer or when event about its change is received by HandleEvent.
+type Storer struct {
+	storer
+	cfg Config
+	lru *lru
+	now func() time.Time
+}
+
+func NewStorer(next storer, cfg Config) *Storer {
+	return &Storer{
+		storer: next,
+		cfg:    cfg,
+		lru:    newLRU(cfg.Size),
+		now:    time.Now,
+	}
+}
+
+// GetUser returns cached user. Users are cached per tenant. Reads with
+// options, e.g. including deleted users, or without tenant always go to next
+// storer.
+func (s *Storer) GetUser(ctx context.Context, id string, opts ...store.ReadOption) (*store.User, error) {
+	tenantID, ok := tenant.FromContext(ctx)
+	if len(opts) > 0 || !ok {
+		return s.storer.GetUser(ctx, id, opts...)
+	}
+	key := cacheKey(tenantID, id)
+	if e, ok := s.lru.get(key, s.now()); ok {
+		requestsTotal.WithLabelValues("hit").Inc()
+		if e.user == nil {
+			return nil, store.ErrUserNotFound
+		}
+		u := *e.user
+		return &u, nil
+	}
+	requestsTotal.WithLabelValues("miss").Inc()
+	generation := s.lru.currentGeneration()
+	// Replica could still return version of user older than invalidation,
+	// which would be cached for whole TTL.
+	user, err := s.storer.GetUser(ctx, id, store.FromPrimary())
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		s.lru.add(&entry{id: key, expires: s.now().Add(s.cfg.NegativeTTL)}, generation)
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+	u := *user
+	s.lru.add(&entry{id: key, user: &u, expires: s.now().Add(s.cfg.TTL)}, generation)
+	return user, nil
+}
+
+func (s *Storer) CreateUser(ctx context.Context, in *store.User) (*store.User, error) {
+	user, err := s.storer.CreateUser(ctx, in)
+	if err == nil {
+		s.invalidate(ctx, user.ID)
+	}
+	return user, err
+}
+
+func (s *Storer) UpdateUser(ctx context.Context, in *store.User) (*store.User, error) {
+	defer s.invalidate(ctx, in.ID)
+	return s.storer.UpdateUser(ctx, in)
+}
+
+func (s *Storer) DeleteUser(ctx context.Context, id string) (*store.User, error) {
+	defer s.invalidate(ctx, id)
+	return s.storer.DeleteUser(ctx, id)
+}
+
+func (s *Storer) UndeleteUser(ctx context.Context, id string) (*store.User, error) {
+	defer s.invalidate(ctx, id)
+	return s.storer.UndeleteUser(ctx, id)
+}
+
+func (s *Storer) BatchCreateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error) {
+	results, err := s.storer.BatchCreateUsers(ctx, in, atomic)
+	for _, r := range results {
+		if r.User != nil {
+			s.invalidate(ctx, r.User.ID)
+		}
+	}
+	return results, err
+}
+
+func (s *Storer) BatchUpdateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error) {
+	defer func() {
+		for _, u := range in {
+			s.invalidate(ctx, u.ID)
+		}
+	}()
+	return s.storer.BatchUpdateUsers(ctx, in, atomic)
+}
+
+func (s *Storer) EraseUser(ctx context.Context, id, reason string) (*store.Erasure, error) {
+	defer s.invalidate(ctx, id)
+	return s.storer.EraseUser(ctx, id, reason)
+}
+
+// HandleEvent invalidates user changed by event. It keeps caches of all
+// service replicas coherent, when it receives events published by them.
+func (s *Storer) HandleEvent(_ context.Context, in proto.Message) {
+	switch ev := in.(type) {
+	case *pb.UserCreated:
+		s.lru.invalidate(cacheKey(ev.GetUser().GetTenantId(), ev.GetUser().GetId()))
+	case *pb.UserUpdated:
+		s.lru.invalidate(cacheKey(ev.GetUser().GetTenantId(), ev.GetUser().GetId()))
+	case *pb.UserDeleted:
+		s.lru.invalidate(cacheKey(ev.GetUser().GetTenantId(), ev.GetUser().GetId()))
+	case *pb.UserRestored:
+		s.lru.invalidate(cacheKey(ev.GetUser().GetTenantId(), ev.GetUser().GetId()))
+	case *pb.UserPurged:
+		s.lru.invalidate(cacheKey(ev.GetTenantId(), ev.GetId()))
+	case *pb.UserErased:
+		s.lru.invalidate(cacheKey(ev.GetTenantId(), ev.GetId()))
+	}
+}
+
+// invalidate invalidates user of tenant from context.
+func (s *Storer) invalidate(ctx context.Context, id string) {
+	if tenantID, ok := tenant.FromContext(ctx); ok {
+		s.lru.invalidate(cacheKey(tenantID, id))
+	}
+}
+
+func cacheKey(tenantID, id string) string {
+	return tenantID + "/" + id
+}
@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/migrate"
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store/migrations/sqlite"
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+var testCtx = tenant.NewContext(context.Background(), "acme")
+
+func TestStorerGetUser(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc string
+		// between is called between two GetUser calls of the same user.
+		between  func(s *Storer)
+		opts     []store.ReadOption
+		expCalls int
+	}{
+		{
+			desc:     "second read is cached",
+			between:  func(*Storer) {},
+			expCalls: 1,
+		},
+		{
+			desc:     "reads with options are not cached",
+			between:  func(*Storer) {},
+			opts:     []store.ReadOption{store.WithDeleted()},
+			expCalls: 2,
+		},
+		{
+			desc: "expired after ttl",
+			between: func(s *Storer) {
+				s.now = func() time.Time { return now.Add(time.Minute) }
+			},
+			expCalls: 2,
+		},
+		{
+			desc: "invalidated by update",
+			between: func(s *Storer) {
+				s.UpdateUser(testCtx, &store.User{ID: "id-1"})
+			},
+			expCalls: 2,
+		},
+		{
+			desc: "invalidated by delete",
+			between: func(s *Storer) {
+				s.DeleteUser(testCtx, "id-1")
+			},
+			expCalls: 2,
+		},
+		{
+			desc: "invalidated by event",
+			between: func(s *Storer) {
+				s.HandleEvent(testCtx, &pb.UserUpdated{User: &pb.User{Id: "id-1", TenantId: "acme"}})
+			},
+			expCalls: 2,
+		},
+		{
+			desc: "invalidated by purge event",
+			between: func(s *Storer) {
+				s.HandleEvent(testCtx, &pb.UserPurged{Id: "id-1", TenantId: "acme"})
+			},
+			expCalls: 2,
+		},
+		{
+			desc: "not invalidated by other user event",
+			between: func(s *Storer) {
+				s.HandleEvent(testCtx, &pb.UserUpdated{User: &pb.User{Id: "id-2", TenantId: "acme"}})
+			},
+			expCalls: 1,
+		},
+		{
+			desc: "not invalidated by event of other tenant",
+			between: func(s *Storer) {
+				s.HandleEvent(testCtx, &pb.UserUpdated{User: &pb.User{Id: "id-1", TenantId: "other"}})
+			},
+			expCalls: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := &mockStorer{users: map[string]*store.User{"id-1": {ID: "id-1", Email: "johnny@test.com"}}}
+			s := NewStorer(m, Config{Size: 10, TTL: time.Minute, NegativeTTL: time.Second})
+			s.now = func() time.Time { return now }
+			for i := 0; i < 2; i++ {
+				got, err := s.GetUser(testCtx, "id-1", tc.opts...)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if got.Email != "johnny@test.com" {
+					t.Errorf("Unexpected user: %v", got)
+				}
+				if i == 0 {
+					tc.between(s)
+				}
+			}
+			if m.getCalls != tc.expCalls {
+				t.Errorf("Expected %d calls of next storer, got: %d", tc.expCalls, m.getCalls)
+			}
+		})
+	}
+}
+
+func TestStorerTenants(t *testing.T) {
+	m := &mockStorer{users: map[string]*store.User{"id-1": {ID: "id-1", Email: "johnny@test.com"}}}
+	s := NewStorer(m, Config{Size: 10, TTL: time.Minute, NegativeTTL: time.Second})
+	s.GetUser(testCtx, "id-1")
+	s.GetUser(tenant.NewContext(context.Background(), "other"), "id-1")
+	s.GetUser(context.Background(), "id-1")
+	s.GetUser(context.Background(), "id-1")
+	if m.getCalls != 4 {
+		t.Errorf("Expected reads of other tenant and without tenant not to be cached, got %d calls of next storer", m.getCalls)
+	}
+}
+
+func TestStorerNegativeCaching(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	m := &mockStorer{users: map[string]*store.User{}}
+	s := NewStorer(m, Config{Size: 10, TTL: time.Minute, NegativeTTL: time.Second})
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetUser(testCtx, "id-1"); !errors.Is(err, store.ErrUserNotFound) {
+			t.Fatalf("Expected not found, got: %v", err)
+		}
+	}
+	if m.getCalls != 1 {
+		t.Errorf("Expected not found to be cached, got %d calls", m.getCalls)
+	}
+
+	// User created by other replica becomes visible after negative ttl.
+	m.users["id-1"] = &store.User{ID: "id-1"}
+	s.now = func() time.Time { return now.Add(time.Second) }
+	if _, err := s.GetUser(testCtx, "id-1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestStorerStaleFill(t *testing.T) {
+	m := &mockStorer{users: map[string]*store.User{"id-1": {ID: "id-1", Email: "old@test.com"}}}
+	s := NewStorer(m, Config{Size: 10, TTL: time.Minute, NegativeTTL: time.Second})
+	// User is updated while its old version is being read.
+	m.onGet = func() {
+		m.onGet = nil
+		m.users["id-1"] = &store.User{ID: "id-1", Email: "new@test.com"}
+		s.HandleEvent(testCtx, &pb.UserUpdated{User: &pb.User{Id: "id-1", TenantId: "acme"}})
+	}
+	s.GetUser(testCtx, "id-1")
+	got, _ := s.GetUser(testCtx, "id-1")
+	if got.Email != "new@test.com" {
+		t.Errorf("Expected stale user not to be cached, got: %v", got)
+	}
+}
+
+func TestStorerStaleReplica(t *testing.T) {
+	primary := openSQLite(t, ":memory:")
+	migrator, err := migrate.NewSQLite(primary, sqlite.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.NewSQLite(primary).CreateUser(testCtx, &store.User{Email: "old@test.com", Country: "PL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replica is snapshot of primary, which doesn't get later writes.
+	path := filepath.Join(t.TempDir(), "replica.db")
+	if _, err := primary.Exec("VACUUM INTO ?", path); err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewSQLite(primary, openSQLite(t, path))
+	s := NewStorer(st, Config{Size: 10, TTL: time.Minute, NegativeTTL: time.Second})
+	user.Email = "new@test.com"
+	if _, err := s.UpdateUser(store.NewSessionContext(testCtx), user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Next request has no session which would route its reads to primary.
+	if got, _ := st.GetUser(testCtx, user.ID); got == nil || got.Email != "old@test.com" {
+		t.Fatalf("Expected replica to be stale, got: %v", got)
+	}
+	got, err := s.GetUser(testCtx, user.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.Email != "new@test.com" {
+		t.Errorf("Expected cache to be filled from primary, got: %v", got)
+	}
+}
+
+// openSQLite opens SQLite database with single connection, so in-memory
+// database is not lost.
+func openSQLite(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestLRUEviction(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	c := newLRU(2)
+	add := func(id string) {
+		c.add(&entry{id: id, expires: now.Add(time.Minute)}, c.currentGeneration())
+	}
+	add("a")
+	add("b")
+	// Make "a" recently used, so "b" is evicted.
+	c.get("a", now)
+	add("c")
+	if c.len() != 2 {
+		t.Errorf("Expected 2 entries, got: %d", c.len())
+	}
+	for id, exp := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(id, now); ok != exp {
+			t.Errorf("Expected %q cached: %t, got: %t", id, exp, ok)
+		}
+	}
+}
+
+type mockStorer struct {
+	storer
+	users    map[string]*store.User
+	getCalls int
+	onGet    func()
+}
+
+func (m *mockStorer) GetUser(_ context.Context, id string, _ ...store.ReadOption) (*store.User, error) {
+	m.getCalls++
+	u, ok := m.users[id]
+	if m.onGet != nil {
+		m.onGet()
+	}
+	if !ok {
+		return nil, store.ErrUserNotFound
+	}
+	out := *u
+	return &out, nil
+}
+
+func (m *mockStorer) UpdateUser(_ context.Context, in *store.User) (*store.User, error) {
+	return in, nil
+}
+
+func (m *mockStorer) DeleteUser(_ context.Context, id string) (*store.User, error) {
+	return &store.User{ID: id}, nil
+}
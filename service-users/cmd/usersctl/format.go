@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+var marshalOpts = protojson.MarshalOptions{UseProtoNames: true}
+
+// userReader reads users one by one, it returns io.EOF after the last one.
+type userReader interface {
+	Read() (*pb.User, error)
+}
+
+// userWriter writes users one by one, Flush must be called after the last one.
+type userWriter interface {
+	Write(*pb.User) error
+	Flush() error
+}
+
+// detectFormat returns format set explicitly or detected by file extension.
+func detectFormat(format, path string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = formatCSV
+		default:
+			format = formatNDJSON
+		}
+	}
+	if format != formatCSV && format != formatNDJSON {
+		return "", fmt.Errorf("unknown format %q, must be %s or %s", format, formatCSV, formatNDJSON)
+	}
+	return format, nil
+}
+
+func newUserReader(format string, r io.Reader) (userReader, error) {
+	if format == formatCSV {
+		return newCSVReader(r)
+	}
+	return &ndjsonReader{scanner: newScanner(r)}, nil
+}
+
+func newUserWriter(format string, w io.Writer) userWriter {
+	if format == formatCSV {
+		return &csvWriter{w: csv.NewWriter(w)}
+	}
+	return &ndjsonWriter{w: bufio.NewWriter(w)}
+}
+
+// userColumns returns names of all User fields in order of declaration.
+// They are used as CSV columns.
+func userColumns() []string {
+	fields := (&pb.User{}).ProtoReflect().Descriptor().Fields()
+	out := make([]string, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		out = append(out, string(fields.Get(i).Name()))
+	}
+	return out
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return s
+}
+
+// ndjsonReader reads users from lines of JSON objects, empty lines are skipped.
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func (r *ndjsonReader) Read() (*pb.User, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r.row++
+		u := &pb.User{}
+		if err := protojson.Unmarshal(line, u); err != nil {
+			return nil, fmt.Errorf("row %d: %w", r.row, err)
+		}
+		return u, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type ndjsonWriter struct {
+	w *bufio.Writer
+}
+
+func (w *ndjsonWriter) Write(u *pb.User) error {
+	b, err := marshalOpts.Marshal(u)
+	if err != nil {
+		return err
+	}
+	w.w.Write(b)
+	return w.w.WriteByte('\n')
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// csvReader reads users from CSV with header of User field names.
+// Empty cells are treated as not set fields.
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+	row    int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	fields := (&pb.User{}).ProtoReflect().Descriptor().Fields()
+	for _, col := range header {
+		if fields.ByName(protoreflect.Name(col)) == nil {
+			return nil, fmt.Errorf("unknown column %q", col)
+		}
+	}
+	return &csvReader{r: cr, header: header}, nil
+}
+
+func (r *csvReader) Read() (*pb.User, error) {
+	record, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.row++
+	// Record is converted into JSON, so all field types, e.g. timestamps,
+	// are parsed the same way as in NDJSON.
+	obj := map[string]string{}
+	for i, col := range r.header {
+		if record[i] != "" {
+			obj[col] = record[i]
+		}
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &pb.User{}
+	if err := protojson.Unmarshal(b, u); err != nil {
+		return nil, fmt.Errorf("row %d: %w", r.row, err)
+	}
+	return u, nil
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (w *csvWriter) Write(u *pb.User) error {
+	columns := userColumns()
+	if !w.wroteHeader {
+		if err := w.w.Write(columns); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	b, err := marshalOpts.Marshal(u)
+	if err != nil {
+		return err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for i, col := range columns {
+		if v, ok := obj[col]; ok {
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return w.w.Write(record)
+}
+
+func (w *csvWriter) Flush() error {
+	if !w.wroteHeader {
+		if err := w.w.Write(userColumns()); err != nil {
+			return err
+		}
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
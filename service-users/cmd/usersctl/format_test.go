@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+)
+
+func TestRoundTrip(t *testing.T) {
+	users := []*pb.User{
+		{
+			Id:        "id-1",
+			FirstName: "Johnny",
+			LastName:  "Cash, Jr.",
+			Email:     "johnny@test.com",
+			Country:   "US",
+			CreatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+		},
+		{Email: "june@test.com"},
+	}
+	for _, format := range []string{formatCSV, formatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w := newUserWriter(format, buf)
+			for _, u := range users {
+				if err := w.Write(u); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			r, err := newUserReader(format, buf)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := readUsers(r)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(users, got, protocmp.Transform()); diff != "" {
+				t.Errorf("Users mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		in     string
+		exp    []*pb.User
+		expErr string
+	}{
+		{
+			desc: "subset of columns in any order",
+			in:   "email,first_name\njohnny@test.com,Johnny\n,June\n",
+			exp: []*pb.User{
+				{Email: "johnny@test.com", FirstName: "Johnny"},
+				{FirstName: "June"},
+			},
+		},
+		{
+			desc:   "unknown column",
+			in:     "email,age\njohnny@test.com,30\n",
+			expErr: `unknown column "age"`,
+		},
+		{
+			desc:   "invalid value",
+			in:     "email,created_at\njohnny@test.com,yesterday\n",
+			expErr: "row 1: ",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r, err := newUserReader(formatCSV, strings.NewReader(tc.in))
+			var got []*pb.User
+			if err == nil {
+				got, err = readUsers(r)
+			}
+			if tc.expErr == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.expErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.expErr)) {
+				t.Fatalf("Expected error %q, got: %v", tc.expErr, err)
+			}
+			if diff := cmp.Diff(tc.exp, got, protocmp.Transform()); tc.expErr == "" && diff != "" {
+				t.Errorf("Users mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	for path, exp := range map[string]string{
+		"users.csv":    formatCSV,
+		"USERS.CSV":    formatCSV,
+		"users.ndjson": formatNDJSON,
+		"-":            formatNDJSON,
+	} {
+		if got, _ := detectFormat("", path); got != exp {
+			t.Errorf("Expected format %q of %q, got: %q", exp, path, got)
+		}
+	}
+	if _, err := detectFormat("xml", "users.xml"); err == nil {
+		t.Errorf("Expected error for unknown format")
+	}
+}
+
+func readUsers(r userReader) ([]*pb.User, error) {
+	var out []*pb.User
+	for {
+		u, err := r.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, u)
+	}
+}
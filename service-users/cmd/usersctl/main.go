@@ -0,0 +1,268 @@
+// Command usersctl imports and exports users in CSV or NDJSON format.
+//
+//	usersctl import [flags] FILE
+//	usersctl export [flags] [FILE]
+//
+// CSV files have header with User field names, e.g. "first_name,email".
+// NDJSON files have single User encoded with protojson per line.
+// FILE "-" means stdin or stdout.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+)
+
+// progressEvery is number of users after which progress is logged.
+const progressEvery = 1000
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("usage: usersctl import|export [flags] FILE")
+	}
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		err = fmt.Errorf("unknown command %q", os.Args[1])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type clientFlags struct {
+	addr  string
+	token string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.addr, "addr", ":18082", "grpc server addr")
+	fs.StringVar(&f.token, "token", os.Getenv("USERS_TOKEN"), "auth token, defaults to USERS_TOKEN env")
+}
+
+func (f *clientFlags) dial() (pb.UsersClient, *grpc.ClientConn, error) {
+	conn, err := grpc.Dial(f.addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, nil, fmt.Errorf("did not connect: %w", err)
+	}
+	return pb.NewUsersClient(conn), conn, nil
+}
+
+func (f *clientFlags) context() context.Context {
+	ctx := context.Background()
+	if f.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+f.token)
+	}
+	return ctx
+}
+
+func runImport(args []string) error {
+	var (
+		cf        clientFlags
+		format    string
+		dryRun    bool
+		upsert    bool
+		chunkSize int
+		report    string
+	)
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	cf.register(fs)
+	fs.StringVar(&format, "format", "", "csv or ndjson, detected by file extension if not set")
+	fs.BoolVar(&dryRun, "dry-run", false, "only validate users")
+	fs.BoolVar(&upsert, "upsert", false, "update existing users with the same email")
+	fs.IntVar(&chunkSize, "chunk", 100, "number of users sent in single message")
+	fs.StringVar(&report, "report", "", "path of CSV report of failed rows, stderr if not set")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: usersctl import [flags] FILE")
+	}
+	path := fs.Arg(0)
+	format, err := detectFormat(format, path)
+	if err != nil {
+		return err
+	}
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, err := newUserReader(format, in)
+	if err != nil {
+		return err
+	}
+
+	cli, conn, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stream, err := cli.ImportUsers(cf.context())
+	if err != nil {
+		return fmt.Errorf("failed to start import: %w", err)
+	}
+	req := &pb.ImportUsersRequest{Options: &pb.ImportUsersRequest_Options{
+		DryRun:        dryRun,
+		UpsertByEmail: upsert,
+	}}
+	sent := 0
+	for {
+		u, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stream.CloseSend()
+			return fmt.Errorf("failed to read users: %w", err)
+		}
+		req.Users = append(req.Users, u)
+		if len(req.Users) < chunkSize {
+			continue
+		}
+		if err := stream.Send(req); err != nil {
+			return fmt.Errorf("failed to send users: %w", err)
+		}
+		sent += len(req.Users)
+		if sent%progressEvery < chunkSize {
+			log.Printf("Sent %d users", sent)
+		}
+		req = &pb.ImportUsersRequest{}
+	}
+	if len(req.Users) > 0 || sent == 0 {
+		if err := stream.Send(req); err != nil {
+			return fmt.Errorf("failed to send users: %w", err)
+		}
+		sent += len(req.Users)
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("import failed after %d sent users: %w", sent, err)
+	}
+	log.Printf("Imported %d users: %d created, %d updated, %d failed (dry run: %t)",
+		sent, resp.GetCreated(), resp.GetUpdated(), resp.GetFailed(), dryRun)
+	if len(resp.GetErrors()) == 0 {
+		return nil
+	}
+	return writeReport(report, resp.GetErrors())
+}
+
+// writeReport writes row errors as CSV into file or stderr.
+func writeReport(path string, errs []*pb.ImportUsersResponse_RowError) error {
+	out := io.Writer(os.Stderr)
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := csv.NewWriter(out)
+	w.Write([]string{"row", "email", "code", "message"})
+	for _, e := range errs {
+		w.Write([]string{
+			strconv.FormatInt(e.GetRow(), 10),
+			e.GetEmail(),
+			strconv.Itoa(int(e.GetCode())),
+			e.GetMessage(),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func runExport(args []string) error {
+	var (
+		cf          clientFlags
+		format      string
+		countries   string
+		filter      string
+		showDeleted bool
+	)
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	cf.register(fs)
+	fs.StringVar(&format, "format", "", "csv or ndjson, detected by file extension if not set")
+	fs.StringVar(&countries, "countries", "", "comma separated list of countries to export")
+	fs.StringVar(&filter, "filter", "", `AIP-160 filter, e.g. 'updated_at > "2026-01-01T00:00:00Z"'`)
+	fs.BoolVar(&showDeleted, "show-deleted", false, "export also soft deleted users")
+	fs.Parse(args)
+	path := "-"
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	format, err := detectFormat(format, path)
+	if err != nil {
+		return err
+	}
+
+	cli, conn, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	req := &pb.ExportUsersRequest{ShowDeleted: showDeleted, Filter: filter}
+	if countries != "" {
+		req.Filtering = &pb.ListUsersRequest_Filtering{Countries: strings.Split(countries, ",")}
+	}
+	stream, err := cli.ExportUsers(cf.context(), req)
+	if err != nil {
+		return fmt.Errorf("failed to start export: %w", err)
+	}
+	out, err := openOutput(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := newUserWriter(format, out)
+	n := 0
+	for {
+		u, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("export failed after %d users: %w", n, err)
+		}
+		if err := w.Write(u); err != nil {
+			return fmt.Errorf("failed to write user: %w", err)
+		}
+		n++
+		if n%progressEvery == 0 {
+			log.Printf("Exported %d users", n)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	log.Printf("Exported %d users", n)
+	return nil
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
@@ -0,0 +1,293 @@
+// Package config loads configuration of users service from optional YAML
+// or JSON file layered with environment variables, which take precedence,
+// and defaults. Every setting has both environment variable, named by its
+// envconfig tag, and file key, named by its yaml tag.
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/deadline"
+	"github.com/tobiaszheller/example-go-microservice/service-users/ratelimit"
+)
+
+// redacted replaces values of secret settings in Redacted config.
+const redacted = "REDACTED"
+
+// Config of users service. Settings tagged secret are redacted when config
+// is shown, ones tagged reload are applied on reload without restart.
+type Config struct {
+	GRPCAddr      string `envconfig:"GRPC_ADDR" yaml:"grpc_addr" default:":18082"`
+	TelemetryAddr string `envconfig:"TELEMETRY_ADDR" yaml:"telemetry_addr" default:":18083"`
+	// LogLevel is one of logrus levels, e.g. debug, info or warning.
+	LogLevel string `envconfig:"LOG_LEVEL" yaml:"log_level" default:"info" reload:"true"`
+	// ReloadInterval is how often config file is checked for changes.
+	ReloadInterval time.Duration `envconfig:"CONFIG_RELOAD_INTERVAL" yaml:"config_reload_interval" default:"10s"`
+	// TLSCertFile and TLSKeyFile are PEM files of certificate served by
+	// gRPC server. If empty, gRPC traffic is not encrypted.
+	TLSCertFile string `envconfig:"TLS_CERT_FILE" yaml:"tls_cert_file"`
+	TLSKeyFile  string `envconfig:"TLS_KEY_FILE" yaml:"tls_key_file"`
+	// DebugEndpoints enables pprof and runtime stats under /debug/ of
+	// telemetry server. If DebugToken is set, they require it as bearer
+	// token. Effective config is served at /config only if DebugToken is
+	// set, with the same token.
+	DebugEndpoints bool   `envconfig:"DEBUG_ENDPOINTS" yaml:"debug_endpoints" default:"false"`
+	DebugToken     string `envconfig:"DEBUG_TOKEN" yaml:"debug_token" secret:"true"`
+	// ShutdownTimeout is how long calls in progress are waited for on
+	// SIGTERM or SIGINT.
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout" default:"10s"`
+	// DBDSN selects database by its scheme: DSN starting with postgres://
+	// or postgresql:// connects to Postgres, sqlite:// to SQLite, any other,
+	// optionally prefixed with mysql://, to MySQL.
+	DBDSN string `envconfig:"DB_DSN" yaml:"db_dsn" secret:"true" default:"user:password@tcp(127.0.0.1:23306)/test"`
+	// DBSchemaCheck makes service refuse to start when database schema
+	// is dirty or behind version expected by code.
+	DBSchemaCheck bool `envconfig:"DB_SCHEMA_CHECK" yaml:"db_schema_check" default:"false"`
+	// Pool settings apply to primary and every replica.
+	DBMaxOpenConns    int           `envconfig:"DB_MAX_OPEN_CONNS" yaml:"db_max_open_conns" default:"25"`
+	DBMaxIdleConns    int           `envconfig:"DB_MAX_IDLE_CONNS" yaml:"db_max_idle_conns" default:"25"`
+	DBConnMaxLifetime time.Duration `envconfig:"DB_CONN_MAX_LIFETIME" yaml:"db_conn_max_lifetime" default:"5m"`
+	DBConnMaxIdleTime time.Duration `envconfig:"DB_CONN_MAX_IDLE_TIME" yaml:"db_conn_max_idle_time" default:"1m"`
+	// DBConnectTimeout is how long connecting to primary DB is retried at
+	// startup.
+	DBConnectTimeout time.Duration `envconfig:"DB_CONNECT_TIMEOUT" yaml:"db_connect_timeout" default:"1m"`
+	// Transactions failed due to deadlocks, lock wait timeouts or lost
+	// connections are retried up to DBTxMaxAttempts times.
+	DBTxMaxAttempts    int           `envconfig:"DB_TX_MAX_ATTEMPTS" yaml:"db_tx_max_attempts" default:"3"`
+	DBTxInitialBackoff time.Duration `envconfig:"DB_TX_INITIAL_BACKOFF" yaml:"db_tx_initial_backoff" default:"20ms"`
+	DBTxMaxBackoff     time.Duration `envconfig:"DB_TX_MAX_BACKOFF" yaml:"db_tx_max_backoff" default:"500ms"`
+	// DBReplicaDSNs is comma separated list of read replicas. Reads of users
+	// are balanced between them, writes always go to DBDSN. Replicas must
+	// be of the same database as DBDSN.
+	DBReplicaDSNs           []string      `envconfig:"DB_REPLICA_DSNS" yaml:"db_replica_dsns" secret:"true"`
+	DBReplicaHealthInterval time.Duration `envconfig:"DB_REPLICA_HEALTH_INTERVAL" yaml:"db_replica_health_interval" default:"5s"`
+	// MaxBatchSize is max number of items in batch requests.
+	MaxBatchSize int `envconfig:"MAX_BATCH_SIZE" yaml:"max_batch_size" default:"100"`
+	// AuthTokens is comma separated list of "token=[tenant/]subject[:admin]"
+	// entries. If empty, authentication is disabled.
+	AuthTokens string `envconfig:"AUTH_TOKENS" yaml:"auth_tokens" secret:"true"`
+	// AnonymousAdmin grants administrative privileges to all callers when
+	// authentication is disabled. It is meant only for development.
+	AnonymousAdmin bool `envconfig:"ANONYMOUS_ADMIN" yaml:"anonymous_admin" default:"false"`
+	// MetricsTenants are tenants which get own label in per tenant metrics,
+	// requests of other tenants are counted as "other".
+	MetricsTenants []string `envconfig:"METRICS_TENANTS" yaml:"metrics_tenants"`
+	// RateLimits is comma separated list of "method=rate:burst" entries,
+	// method "*" applies to all other methods. If empty, rate limiting is
+	// disabled.
+	RateLimits string `envconfig:"RATE_LIMITS" yaml:"rate_limits" reload:"true"`
+	// Deadlines is comma separated list of "method=default:max" entries,
+	// default is used if client sent no deadline and longer ones are
+	// shortened to max.
+	Deadlines string `envconfig:"DEADLINES" yaml:"deadlines" default:"*=10s:30s,/users.Users/ImportUsers=0s:30m,/users.Users/ExportUsers=0s:30m"`
+
+	// LoadShedding enables rejecting calls when number of concurrent calls
+	// exceeds adaptive limit or DB pool wait exceeds LoadSheddingMaxPoolWait.
+	LoadShedding                 bool          `envconfig:"LOAD_SHEDDING" yaml:"load_shedding" default:"false"`
+	LoadSheddingInitialLimit     int           `envconfig:"LOAD_SHEDDING_INITIAL_LIMIT" yaml:"load_shedding_initial_limit" default:"100"`
+	LoadSheddingMinLimit         int           `envconfig:"LOAD_SHEDDING_MIN_LIMIT" yaml:"load_shedding_min_limit" default:"10"`
+	LoadSheddingMaxLimit         int           `envconfig:"LOAD_SHEDDING_MAX_LIMIT" yaml:"load_shedding_max_limit" default:"1000"`
+	LoadSheddingLatencyThreshold time.Duration `envconfig:"LOAD_SHEDDING_LATENCY_THRESHOLD" yaml:"load_shedding_latency_threshold" default:"500ms"`
+	LoadSheddingBackoff          float64       `envconfig:"LOAD_SHEDDING_BACKOFF" yaml:"load_shedding_backoff" default:"0.9"`
+	LoadSheddingMaxPoolWait      time.Duration `envconfig:"LOAD_SHEDDING_MAX_POOL_WAIT" yaml:"load_shedding_max_pool_wait" default:"100ms"`
+
+	WebhooksPollInterval   time.Duration `envconfig:"WEBHOOKS_POLL_INTERVAL" yaml:"webhooks_poll_interval" default:"1s"`
+	WebhooksBatchSize      int           `envconfig:"WEBHOOKS_BATCH_SIZE" yaml:"webhooks_batch_size" default:"20"`
+	WebhooksTimeout        time.Duration `envconfig:"WEBHOOKS_TIMEOUT" yaml:"webhooks_timeout" default:"10s"`
+	WebhooksMaxAttempts    int           `envconfig:"WEBHOOKS_MAX_ATTEMPTS" yaml:"webhooks_max_attempts" default:"10"`
+	WebhooksInitialBackoff time.Duration `envconfig:"WEBHOOKS_INITIAL_BACKOFF" yaml:"webhooks_initial_backoff" default:"10s"`
+	WebhooksMaxBackoff     time.Duration `envconfig:"WEBHOOKS_MAX_BACKOFF" yaml:"webhooks_max_backoff" default:"1h"`
+
+	// CacheSize is max number of users cached by GetUser, 0 disables cache.
+	CacheSize        int           `envconfig:"CACHE_SIZE" yaml:"cache_size" default:"10000"`
+	CacheTTL         time.Duration `envconfig:"CACHE_TTL" yaml:"cache_ttl" default:"1m"`
+	CacheNegativeTTL time.Duration `envconfig:"CACHE_NEGATIVE_TTL" yaml:"cache_negative_ttl" default:"5s"`
+
+	PurgeInterval  time.Duration `envconfig:"PURGE_INTERVAL" yaml:"purge_interval" default:"1h"`
+	PurgeRetention time.Duration `envconfig:"PURGE_RETENTION" yaml:"purge_retention" default:"720h"`
+	PurgeBatchSize int           `envconfig:"PURGE_BATCH_SIZE" yaml:"purge_batch_size" default:"100"`
+
+	// PIIKeyfile is path of envelope.Keyfile, if set names and emails of
+	// users are encrypted at rest.
+	PIIKeyfile            string `envconfig:"PII_KEYFILE" yaml:"pii_keyfile"`
+	PIIReencryptBatchSize int    `envconfig:"PII_REENCRYPT_BATCH_SIZE" yaml:"pii_reencrypt_batch_size" default:"100"`
+}
+
+// Load returns config read from file at path, if path is not empty,
+// overridden by environment variables. Settings set in neither of them get
+// defaults. Config is validated, error lists every invalid setting.
+func Load(path string) (*Config, error) {
+	var data []byte
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+	return parse(path, data)
+}
+
+// parse returns config read from data of file at path layered with
+// environment variables and defaults.
+func parse(path string, data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := envconfig.Process("", cfg); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		// Unmarshal to separate config, to tell keys set to zero value
+		// from keys not set, and to map, to learn which keys are set.
+		var file Config
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		var keys map[string]interface{}
+		if err := yaml.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		dst, src := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(file)
+		for i := 0; i < dst.NumField(); i++ {
+			field := dst.Type().Field(i)
+			if _, ok := keys[field.Tag.Get("yaml")]; !ok {
+				continue
+			}
+			if _, ok := os.LookupEnv(field.Tag.Get("envconfig")); ok {
+				continue
+			}
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate returns error describing every invalid setting, or nil.
+func (c *Config) Validate() error {
+	var errs []string
+	invalid := func(key, format string, args ...interface{}) {
+		errs = append(errs, key+": "+fmt.Sprintf(format, args...))
+	}
+	positive := func(key string, v int) {
+		if v <= 0 {
+			invalid(key, "must be positive, got %d", v)
+		}
+	}
+	positiveDuration := func(key string, d time.Duration) {
+		if d <= 0 {
+			invalid(key, "must be positive, got %v", d)
+		}
+	}
+
+	if c.GRPCAddr == "" {
+		invalid("grpc_addr", "must not be empty")
+	}
+	if c.TelemetryAddr == "" {
+		invalid("telemetry_addr", "must not be empty")
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		invalid("log_level", "%v", err)
+	}
+	positiveDuration("config_reload_interval", c.ReloadInterval)
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		invalid("tls_cert_file", "must be set along with tls_key_file")
+	}
+	positiveDuration("shutdown_timeout", c.ShutdownTimeout)
+
+	if c.DBDSN == "" {
+		invalid("db_dsn", "must not be empty")
+	}
+	if c.DBMaxOpenConns < 0 {
+		invalid("db_max_open_conns", "must not be negative, got %d", c.DBMaxOpenConns)
+	}
+	if c.DBMaxIdleConns < 0 {
+		invalid("db_max_idle_conns", "must not be negative, got %d", c.DBMaxIdleConns)
+	}
+	positiveDuration("db_connect_timeout", c.DBConnectTimeout)
+	positive("db_tx_max_attempts", c.DBTxMaxAttempts)
+	if c.DBTxInitialBackoff > c.DBTxMaxBackoff {
+		invalid("db_tx_initial_backoff", "must not exceed db_tx_max_backoff %v, got %v", c.DBTxMaxBackoff, c.DBTxInitialBackoff)
+	}
+	positiveDuration("db_replica_health_interval", c.DBReplicaHealthInterval)
+	positive("max_batch_size", c.MaxBatchSize)
+
+	if _, err := auth.ParseStaticTokens(c.AuthTokens); err != nil {
+		invalid("auth_tokens", "%v", err)
+	}
+	if c.AnonymousAdmin && c.AuthTokens != "" {
+		invalid("anonymous_admin", "must not be set along with auth_tokens")
+	}
+	if _, err := ratelimit.ParseLimits(c.RateLimits); err != nil {
+		invalid("rate_limits", "%v", err)
+	}
+	if _, err := deadline.ParseTimeouts(c.Deadlines); err != nil {
+		invalid("deadlines", "%v", err)
+	}
+
+	if c.LoadShedding {
+		positive("load_shedding_min_limit", c.LoadSheddingMinLimit)
+		if c.LoadSheddingInitialLimit < c.LoadSheddingMinLimit || c.LoadSheddingInitialLimit > c.LoadSheddingMaxLimit {
+			invalid("load_shedding_initial_limit", "must be between load_shedding_min_limit %d and load_shedding_max_limit %d, got %d",
+				c.LoadSheddingMinLimit, c.LoadSheddingMaxLimit, c.LoadSheddingInitialLimit)
+		}
+		positiveDuration("load_shedding_latency_threshold", c.LoadSheddingLatencyThreshold)
+		if c.LoadSheddingBackoff <= 0 || c.LoadSheddingBackoff >= 1 {
+			invalid("load_shedding_backoff", "must be between 0 and 1 exclusive, got %v", c.LoadSheddingBackoff)
+		}
+	}
+
+	positiveDuration("webhooks_poll_interval", c.WebhooksPollInterval)
+	positive("webhooks_batch_size", c.WebhooksBatchSize)
+	positiveDuration("webhooks_timeout", c.WebhooksTimeout)
+	positive("webhooks_max_attempts", c.WebhooksMaxAttempts)
+	if c.WebhooksInitialBackoff > c.WebhooksMaxBackoff {
+		invalid("webhooks_initial_backoff", "must not exceed webhooks_max_backoff %v, got %v", c.WebhooksMaxBackoff, c.WebhooksInitialBackoff)
+	}
+
+	if c.CacheSize < 0 {
+		invalid("cache_size", "must not be negative, got %d", c.CacheSize)
+	}
+	positiveDuration("purge_interval", c.PurgeInterval)
+	positive("purge_batch_size", c.PurgeBatchSize)
+	positive("pii_reencrypt_batch_size", c.PIIReencryptBatchSize)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Redacted returns copy of config with values of secret settings replaced,
+// so it can be shown.
+func (c *Config) Redacted() *Config {
+	out := *c
+	v := reflect.ValueOf(&out).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("secret") != "true" {
+			continue
+		}
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			if field.String() != "" {
+				field.SetString(redacted)
+			}
+		case reflect.Slice:
+			values := make([]string, field.Len())
+			for j := range values {
+				values[j] = redacted
+			}
+			field.Set(reflect.ValueOf(values))
+		}
+	}
+	return &out
+}
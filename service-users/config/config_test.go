@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeFile writes config file to temporary dir and returns its path.
+func writeFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setenv sets environment variable until t completes.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	prev, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	path := writeFile(t, `
+grpc_addr: ":9000"
+max_batch_size: 50
+cache_ttl: 2m
+db_replica_dsns: ["replica-1", "replica-2"]
+`)
+	setenv(t, "MAX_BATCH_SIZE", "20")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// File overrides defaults, env overrides file.
+	if cfg.GRPCAddr != ":9000" {
+		t.Errorf("Expected grpc_addr from file, got: %q", cfg.GRPCAddr)
+	}
+	if cfg.MaxBatchSize != 20 {
+		t.Errorf("Expected max_batch_size from env, got: %d", cfg.MaxBatchSize)
+	}
+	if cfg.CacheTTL != 2*time.Minute {
+		t.Errorf("Expected cache_ttl from file, got: %v", cfg.CacheTTL)
+	}
+	if diff := cmp.Diff([]string{"replica-1", "replica-2"}, cfg.DBReplicaDSNs); diff != "" {
+		t.Errorf("DB replica DSNs mismatch, diff: %s", diff)
+	}
+	if cfg.TelemetryAddr != ":18083" {
+		t.Errorf("Expected default telemetry_addr, got: %q", cfg.TelemetryAddr)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	cfg, err := Load(writeFile(t, `{"log_level": "debug", "cache_size": 0}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.LogLevel != "debug" || cfg.CacheSize != 0 {
+		t.Errorf("Expected settings from file, got log_level %q and cache_size %d", cfg.LogLevel, cfg.CacheSize)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		data   string
+		expErr string
+	}{
+		{
+			desc:   "unknown key",
+			data:   "grpc_addr: \":9000\"\nmax_batch: 10\n",
+			expErr: "line 2: field max_batch not found",
+		},
+		{
+			desc:   "invalid type",
+			data:   "max_batch_size: many\n",
+			expErr: "line 1: cannot unmarshal !!str `many` into int",
+		},
+		{
+			desc:   "invalid values",
+			data:   "max_batch_size: 0\nlog_level: loud\n",
+			expErr: `invalid config: log_level: not a valid logrus Level: "loud"; max_batch_size: must be positive, got 0`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := Load(writeFile(t, tC.data))
+			if err == nil || !strings.Contains(err.Error(), tC.expErr) {
+				t.Errorf("Expected error containing %q, got: %v", tC.expErr, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid, err := Load("")
+	if err != nil {
+		t.Fatalf("Expected defaults to be valid, got: %v", err)
+	}
+	testCases := []struct {
+		desc   string
+		modify func(*Config)
+		expErr string
+	}{
+		{
+			desc:   "TLS key without certificate",
+			modify: func(c *Config) { c.TLSKeyFile = "key.pem" },
+			expErr: "invalid config: tls_cert_file: must be set along with tls_key_file",
+		},
+		{
+			desc:   "invalid rate limits",
+			modify: func(c *Config) { c.RateLimits = "*=0:10" },
+			expErr: "invalid config: rate_limits: invalid rate of *, expected positive number",
+		},
+		{
+			desc: "load shedding limits out of order",
+			modify: func(c *Config) {
+				c.LoadShedding = true
+				c.LoadSheddingInitialLimit = 5
+				c.LoadSheddingBackoff = 1
+			},
+			expErr: "invalid config: load_shedding_initial_limit: must be between load_shedding_min_limit 10 and load_shedding_max_limit 1000, got 5; " +
+				"load_shedding_backoff: must be between 0 and 1 exclusive, got 1",
+		},
+		{
+			desc: "anonymous admin along with auth tokens",
+			modify: func(c *Config) {
+				c.AuthTokens = "secret=svc"
+				c.AnonymousAdmin = true
+			},
+			expErr: "invalid config: anonymous_admin: must not be set along with auth_tokens",
+		},
+		{
+			desc: "multiple errors",
+			modify: func(c *Config) {
+				c.DBDSN = ""
+				c.DBTxMaxAttempts = 0
+				c.WebhooksInitialBackoff = 2 * time.Hour
+			},
+			expErr: "invalid config: db_dsn: must not be empty; db_tx_max_attempts: must be positive, got 0; " +
+				"webhooks_initial_backoff: must not exceed webhooks_max_backoff 1h0m0s, got 2h0m0s",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			cfg := *valid
+			tC.modify(&cfg)
+			err := cfg.Validate()
+			if err == nil || err.Error() != tC.expErr {
+				t.Errorf("Expected error %q, got: %v", tC.expErr, err)
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := &Config{
+		GRPCAddr:      ":9000",
+		DBDSN:         "user:password@tcp(db:3306)/users",
+		DBReplicaDSNs: []string{"user:password@tcp(replica:3306)/users"},
+	}
+	got := cfg.Redacted()
+	exp := &Config{
+		GRPCAddr:      ":9000",
+		DBDSN:         "REDACTED",
+		DBReplicaDSNs: []string{"REDACTED"},
+	}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("Redacted config mismatch, diff: %s", diff)
+	}
+	if cfg.DBReplicaDSNs[0] == "REDACTED" {
+		t.Error("Expected original config to be left intact")
+	}
+}
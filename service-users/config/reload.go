@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Reloader keeps effective config and reloads it when config file changes
+// or on SIGHUP. Only settings tagged reload are applied, changes of other
+// ones are logged as requiring restart.
+type Reloader struct {
+	path string
+
+	mu       sync.RWMutex
+	current  *Config
+	data     []byte
+	onReload []func(*Config)
+}
+
+// NewReloader returns reloader of config loaded from file at path.
+func NewReloader(path string, cfg *Config) *Reloader {
+	r := &Reloader{path: path, current: cfg}
+	if path != "" {
+		r.data, _ = os.ReadFile(path)
+	}
+	return r
+}
+
+// OnReload registers fn called with effective config after settings tagged
+// reload changed. It must be called before Run.
+func (r *Reloader) OnReload(fn func(*Config)) {
+	r.onReload = append(r.onReload, fn)
+}
+
+// Current returns effective config.
+func (r *Reloader) Current() *Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Run checks config file for changes every ReloadInterval and reloads it on
+// change or SIGHUP, until ctx is done. Invalid config is logged and
+// previous one is kept.
+func (r *Reloader) Run(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(r.Current().ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			log.Info("Reloading config on SIGHUP")
+			if err := r.Reload(); err != nil {
+				log.WithError(err).Error("Failed to reload config, keeping previous one")
+			}
+		case <-ticker.C:
+			data, err := os.ReadFile(r.path)
+			if err != nil {
+				log.WithError(err).Warn("Failed to check config file for changes")
+				continue
+			}
+			r.mu.RLock()
+			changed := !bytes.Equal(data, r.data)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			log.Info("Reloading changed config file")
+			if err := r.reload(data); err != nil {
+				log.WithError(err).Error("Failed to reload config, keeping previous one")
+			}
+		}
+	}
+}
+
+// Reload reads config file and applies changed settings tagged reload.
+func (r *Reloader) Reload() error {
+	var data []byte
+	if r.path != "" {
+		var err error
+		if data, err = os.ReadFile(r.path); err != nil {
+			return err
+		}
+	}
+	return r.reload(data)
+}
+
+func (r *Reloader) reload(data []byte) error {
+	r.mu.Lock()
+	// Invalid file is not retried until it changes again.
+	r.data = data
+	r.mu.Unlock()
+	next, err := parse(r.path, data)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	applied := *r.current
+	var reloaded, restart []string
+	dst, src := reflect.ValueOf(&applied).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if reflect.DeepEqual(dst.Field(i).Interface(), src.Field(i).Interface()) {
+			continue
+		}
+		field := dst.Type().Field(i)
+		if field.Tag.Get("reload") != "true" {
+			restart = append(restart, field.Tag.Get("yaml"))
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+		reloaded = append(reloaded, field.Tag.Get("yaml"))
+	}
+	r.current = &applied
+	r.mu.Unlock()
+
+	if len(restart) > 0 {
+		log.Warnf("Changes of %s take effect after restart", strings.Join(restart, ", "))
+	}
+	if len(reloaded) == 0 {
+		return nil
+	}
+	log.Infof("Reloaded %s", strings.Join(reloaded, ", "))
+	for _, fn := range r.onReload {
+		fn(&applied)
+	}
+	return nil
+}
+
+// ServeHTTP shows effective config as YAML, with secrets redacted.
+func (r *Reloader) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	out, err := yaml.Marshal(r.Current().Redacted())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/yaml")
+	rw.Write(out)
+}
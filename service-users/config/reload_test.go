@@ -0,0 +1,66 @@
+package config
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReloaderReload(t *testing.T) {
+	path := writeFile(t, "log_level: info\ngrpc_addr: \":9000\"\n")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReloader(path, cfg)
+	var reloaded *Config
+	r.OnReload(func(c *Config) { reloaded = c })
+
+	if err := os.WriteFile(path, []byte("log_level: debug\nrate_limits: \"*=5:10\"\ngrpc_addr: \":9001\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reloaded == nil {
+		t.Fatal("Expected reload callback to be called")
+	}
+	got := r.Current()
+	if got.LogLevel != "debug" || got.RateLimits != "*=5:10" {
+		t.Errorf("Expected reloadable settings to be applied, got log_level %q and rate_limits %q", got.LogLevel, got.RateLimits)
+	}
+	if got.GRPCAddr != ":9000" {
+		t.Errorf("Expected grpc_addr to be kept until restart, got: %q", got.GRPCAddr)
+	}
+
+	// Invalid config is rejected and previous one is kept.
+	reloaded = nil
+	if err := os.WriteFile(path, []byte("log_level: loud\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("Expected error of invalid config")
+	}
+	if reloaded != nil || r.Current().LogLevel != "debug" {
+		t.Errorf("Expected previous config to be kept, got log_level %q", r.Current().LogLevel)
+	}
+}
+
+func TestReloaderServeHTTP(t *testing.T) {
+	cfg, err := Load(writeFile(t, "auth_tokens: secret=svc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	NewReloader("", cfg).ServeHTTP(rec, httptest.NewRequest("GET", "/config", nil))
+	body := rec.Body.String()
+	if strings.Contains(body, "secret=svc") || strings.Contains(body, "password") {
+		t.Errorf("Expected secrets to be redacted, got:\n%s", body)
+	}
+	for _, exp := range []string{"auth_tokens: REDACTED", "db_dsn: REDACTED", "grpc_addr: :18082", "cache_ttl: 1m0s"} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("Expected %q in config, got:\n%s", exp, body)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package deadline bounds time of handling gRPC calls regardless of
+// deadline set by client.
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+)
+
+// DefaultMethod is key of Timeouts applied to methods without their own
+// timeout.
+const DefaultMethod = "*"
+
+// Timeout of single method. Default is used if client sent no deadline,
+// deadlines further than Max are shortened to it. Zero value of either is
+// not enforced.
+type Timeout struct {
+	Default time.Duration
+	Max     time.Duration
+}
+
+// Timeouts are keyed by full gRPC method name, e.g.
+// "/users.Users/ExportUsers", or DefaultMethod.
+type Timeouts map[string]Timeout
+
+// ParseTimeouts parses comma separated list of "method=default:max"
+// entries, e.g. "*=5s:30s,/users.Users/ExportUsers=5m:30m".
+func ParseTimeouts(in string) (Timeouts, error) {
+	out := Timeouts{}
+	for _, entry := range strings.Split(in, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid deadline entry %q, expected method=default:max", entry)
+		}
+		values := strings.SplitN(parts[1], ":", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid deadline entry %q, expected method=default:max", entry)
+		}
+		var t Timeout
+		var err error
+		if t.Default, err = time.ParseDuration(values[0]); err != nil {
+			return nil, fmt.Errorf("invalid default deadline of %s: %w", parts[0], err)
+		}
+		if t.Max, err = time.ParseDuration(values[1]); err != nil {
+			return nil, fmt.Errorf("invalid max deadline of %s: %w", parts[0], err)
+		}
+		if t.Max > 0 && t.Default > t.Max {
+			return nil, fmt.Errorf("default deadline of %s exceeds max one", parts[0])
+		}
+		out[parts[0]] = t
+	}
+	return out, nil
+}
+
+// timeout returns timeout of method.
+func (t Timeouts) timeout(method string) Timeout {
+	if out, ok := t[method]; ok {
+		return out
+	}
+	return t[DefaultMethod]
+}
+
+// apply returns context with deadline of method. Returned cancel func must
+// be called once call is handled.
+func (t Timeouts) apply(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	timeout := t.timeout(method)
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok && timeout.Default > 0:
+		return context.WithTimeout(ctx, timeout.Default)
+	case timeout.Max > 0 && (!ok || time.Until(deadline) > timeout.Max):
+		return context.WithTimeout(ctx, timeout.Max)
+	}
+	return ctx, func() {}
+}
+
+// UnaryServerInterceptor sets deadline of calls according to timeouts.
+func UnaryServerInterceptor(t Timeouts) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := t.apply(ctx, info.FullMethod)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor(t Timeouts) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := t.apply(stream.Context(), info.FullMethod)
+		defer cancel()
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,122 @@
+package deadline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		in     string
+		exp    Timeouts
+		expErr string
+	}{
+		{
+			desc: "default and method timeouts",
+			in:   "*=5s:30s, /users.Users/ExportUsers=0s:30m",
+			exp: Timeouts{
+				DefaultMethod:              {Default: 5 * time.Second, Max: 30 * time.Second},
+				"/users.Users/ExportUsers": {Max: 30 * time.Minute},
+			},
+		},
+		{
+			desc:   "missing max",
+			in:     "*=5s",
+			expErr: `invalid deadline entry "*=5s", expected method=default:max`,
+		},
+		{
+			desc:   "default exceeds max",
+			in:     "*=1m:30s",
+			expErr: "default deadline of * exceeds max one",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := ParseTimeouts(tC.in)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tC.expErr, gotErr); diff != "" {
+				t.Errorf("Error mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.exp, got); diff != "" {
+				t.Errorf("Timeouts mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	timeouts := Timeouts{
+		DefaultMethod: {Default: time.Second, Max: time.Minute},
+		"/m/Export":   {},
+	}
+	testCases := []struct {
+		desc        string
+		method      string
+		timeout     time.Duration
+		expDeadline bool
+		expMin      time.Duration
+		expMax      time.Duration
+	}{
+		{
+			desc:        "no deadline gets default",
+			method:      "/m/Get",
+			expDeadline: true,
+			expMax:      time.Second,
+		},
+		{
+			desc:        "far deadline is shortened",
+			method:      "/m/Get",
+			timeout:     time.Hour,
+			expDeadline: true,
+			expMin:      time.Second,
+			expMax:      time.Minute,
+		},
+		{
+			desc:        "near deadline is kept",
+			method:      "/m/Get",
+			timeout:     10 * time.Second,
+			expDeadline: true,
+			expMin:      time.Second,
+			expMax:      10 * time.Second,
+		},
+		{
+			desc:   "method without timeouts",
+			method: "/m/Export",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ctx := context.Background()
+			if tC.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tC.timeout)
+				defer cancel()
+			}
+			interceptor := UnaryServerInterceptor(timeouts)
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tC.method}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+				deadline, ok := ctx.Deadline()
+				if ok != tC.expDeadline {
+					t.Fatalf("Expected deadline %v, got: %v", tC.expDeadline, ok)
+				}
+				if !ok {
+					return nil, nil
+				}
+				if left := time.Until(deadline); left <= tC.expMin || left > tC.expMax {
+					t.Errorf("Expected deadline in (%v, %v], got: %v", tC.expMin, tC.expMax, left)
+				}
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
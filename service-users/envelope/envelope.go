@@ -0,0 +1,97 @@
+// Package envelope implements envelope encryption. Values are encrypted with
+// AES-256-GCM data keys, which are in turn encrypted (wrapped) with key
+// encryption keys of KeyProvider. Rotation of key encryption key requires
+// only rewrapping of data keys, values do not have to be re-encrypted.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// DataKeySize is size of data keys and key encryption keys, it selects
+// AES-256.
+const DataKeySize = 32
+
+// ErrDecrypt is returned when value cannot be decrypted, because key is
+// wrong or value was modified.
+var ErrDecrypt = errors.New("cannot decrypt value")
+
+// KeyProvider wraps data keys with key encryption keys. Keyfile keeps them
+// locally, KMS can be plugged in by implementing KeyProvider with its
+// encrypt and decrypt calls.
+type KeyProvider interface {
+	// CurrentKeyID returns id of key encryption key used by Wrap.
+	CurrentKeyID() string
+	// Wrap encrypts data key with current key encryption key.
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts data key wrapped with key encryption key of given id.
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// NewDataKey returns random data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// Seal encrypts and authenticates plaintext along with additional data,
+// e.g. id of record, so sealed value cannot be moved to other record.
+// Random nonce is prepended to returned ciphertext.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts value sealed with the same key and additional data.
+func Open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	out, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("key must have %d bytes, got %d", DataKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// BlindIndex returns hex encoded HMAC-SHA256 of value. It can be stored
+// along with encrypted value to look it up by equality without decryption.
+func BlindIndex(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
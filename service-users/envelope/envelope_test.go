@@ -0,0 +1,146 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sealed, err := Seal(key, []byte("john@test.com"), []byte("id-1/email"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got, err := Open(key, sealed, []byte("id-1/email"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(got) != "john@test.com" {
+		t.Errorf("Expected opened value to equal sealed one, got: %q", got)
+	}
+
+	otherKey, _ := NewDataKey()
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	for desc, open := range map[string]func() ([]byte, error){
+		"other additional data": func() ([]byte, error) { return Open(key, sealed, []byte("id-2/email")) },
+		"other key":             func() ([]byte, error) { return Open(otherKey, sealed, []byte("id-1/email")) },
+		"tampered value":        func() ([]byte, error) { return Open(key, tampered, []byte("id-1/email")) },
+		"truncated value":       func() ([]byte, error) { return Open(key, sealed[:4], []byte("id-1/email")) },
+	} {
+		t.Run(desc, func(t *testing.T) {
+			if _, err := open(); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, DataKeySize)
+	a := BlindIndex(key, "john@test.com")
+	if a != BlindIndex(key, "john@test.com") {
+		t.Errorf("Expected blind index to be deterministic")
+	}
+	if a == BlindIndex(key, "june@test.com") {
+		t.Errorf("Expected blind index of other value to differ")
+	}
+	if a == BlindIndex(bytes.Repeat([]byte{2}, DataKeySize), "john@test.com") {
+		t.Errorf("Expected blind index with other key to differ")
+	}
+}
+
+func TestKeyfileRotation(t *testing.T) {
+	oldKeys, err := ParseKeyfile(keyfile("k1", "k1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dataKey, _ := NewDataKey()
+	keyID, wrapped, err := oldKeys.Wrap(context.Background(), dataKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if keyID != "k1" {
+		t.Errorf("Expected data key to be wrapped with k1, got: %q", keyID)
+	}
+
+	rotated, err := ParseKeyfile(keyfile("k2", "k1", "k2"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rotated.CurrentKeyID() != "k2" {
+		t.Errorf("Expected current key k2, got: %q", rotated.CurrentKeyID())
+	}
+	got, err := rotated.Unwrap(context.Background(), keyID, wrapped)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Errorf("Expected unwrapped key to equal data key")
+	}
+	if _, err := rotated.Unwrap(context.Background(), "k2", wrapped); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt when unwrapping with other key, got: %v", err)
+	}
+}
+
+func TestParseKeyfile(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	testCases := []struct {
+		desc   string
+		in     string
+		expErr string
+	}{
+		{
+			desc:   "malformed json",
+			in:     "{",
+			expErr: "invalid keyfile: unexpected end of JSON input",
+		},
+		{
+			desc:   "missing current key",
+			in:     string(keyfile("k2", "k1")),
+			expErr: `current key "k2" not found in keys`,
+		},
+		{
+			desc:   "short key",
+			in:     `{"current_key": "k1", "keys": {"k1": "` + short + `"}}`,
+			expErr: `invalid key "k1": must have 32 bytes, got 5`,
+		},
+		{
+			desc:   "missing blind index key",
+			in:     strings.Replace(string(keyfile("k1", "k1")), "blind_index_key", "other", 1),
+			expErr: "invalid blind index key: must have 32 bytes, got 0",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := ParseKeyfile([]byte(tC.in))
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tC.expErr {
+				t.Errorf("Expected error %q, got: %q", tC.expErr, got)
+			}
+		})
+	}
+}
+
+// keyfile returns content of keyfile with given key ids, each key is
+// filled with byte of second character of its id.
+func keyfile(current string, ids ...string) []byte {
+	var keys []string
+	for _, id := range ids {
+		key := bytes.Repeat([]byte{id[1]}, DataKeySize)
+		keys = append(keys, fmt.Sprintf("%q: %q", id, base64.StdEncoding.EncodeToString(key)))
+	}
+	index := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0}, DataKeySize))
+	return []byte(fmt.Sprintf(`{"current_key": %q, "keys": {%s}, "blind_index_key": %q}`, current, strings.Join(keys, ", "), index))
+}
@@ -0,0 +1,100 @@
+package envelope
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Keyfile is KeyProvider with key encryption keys read from local JSON file:
+//
+//	{
+//	  "current_key": "2026-10",
+//	  "keys": {
+//	    "2026-10": "<base64 of 32 random bytes>",
+//	    "2026-01": "<base64 of 32 random bytes>"
+//	  },
+//	  "blind_index_key": "<base64 of 32 random bytes>"
+//	}
+//
+// Keys are rotated by adding new key and making it current. Old keys have
+// to be kept until all data keys are rewrapped with the current one.
+type Keyfile struct {
+	current string
+	keys    map[string][]byte
+	// BlindIndexKey is key of blind indexes. It cannot be rotated without
+	// recomputing all indexes.
+	BlindIndexKey []byte
+}
+
+// ReadKeyfile reads Keyfile from path.
+func ReadKeyfile(path string) (*Keyfile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	return ParseKeyfile(b)
+}
+
+// ParseKeyfile parses JSON content of Keyfile.
+func ParseKeyfile(b []byte) (*Keyfile, error) {
+	var raw struct {
+		CurrentKey    string            `json:"current_key"`
+		Keys          map[string]string `json:"keys"`
+		BlindIndexKey string            `json:"blind_index_key"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid keyfile: %w", err)
+	}
+	out := &Keyfile{current: raw.CurrentKey, keys: make(map[string][]byte, len(raw.Keys))}
+	for id, k := range raw.Keys {
+		key, err := decodeKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		out.keys[id] = key
+	}
+	if _, ok := out.keys[out.current]; !ok {
+		return nil, fmt.Errorf("current key %q not found in keys", out.current)
+	}
+	key, err := decodeKey(raw.BlindIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blind index key: %w", err)
+	}
+	out.BlindIndexKey = key
+	return out, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("must be base64 encoded")
+	}
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("must have %d bytes, got %d", DataKeySize, len(key))
+	}
+	return key, nil
+}
+
+func (k *Keyfile) CurrentKeyID() string {
+	return k.current
+}
+
+// Wrap seals data key with current key, bound to its id.
+func (k *Keyfile) Wrap(_ context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := Seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return "", nil, err
+	}
+	return k.current, wrapped, nil
+}
+
+func (k *Keyfile) Unwrap(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return Open(kek, wrapped, []byte(keyID))
+}
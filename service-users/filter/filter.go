@@ -0,0 +1,330 @@
+// Package filter parses AIP-160 filter and AIP-132 order by expressions.
+//
+// Supported filter grammar is subset of AIP-160 without functions, has
+// operator and field traversal:
+//
+//	country = "US" AND (first_name = "John*" OR -nickname = "")
+//	updated_at > "2026-01-01T00:00:00Z"
+//
+// Like in AIP-160, OR binds tighter than AND and adjacent terms are joined
+// with AND.
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits of filters, parser is recursive, so deeply nested filter would
+// exhaust stack.
+const (
+	// MaxLength is max length of filter in bytes.
+	MaxLength = 4096
+	// MaxDepth is max number of nested parentheses and negations.
+	MaxDepth = 32
+)
+
+// Error is syntax or validation error at position of expression.
+type Error struct {
+	// Pos is byte offset in expression, starting from 0.
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Msg)
+}
+
+// Type is type of field values.
+type Type int
+
+const (
+	String Type = iota
+	// Timestamp values must be RFC 3339 strings.
+	Timestamp
+)
+
+// Schema is allowlist of fields which can be used in expressions.
+type Schema map[string]Type
+
+// Expr is node of parsed filter: And, Or, Not or Comparison.
+type Expr interface {
+	isExpr()
+}
+
+// And matches if both sides match.
+type And struct {
+	Left, Right Expr
+}
+
+// Or matches if any side matches.
+type Or struct {
+	Left, Right Expr
+}
+
+// Not matches if Expr does not match.
+type Not struct {
+	Expr Expr
+}
+
+// Comparison compares field with value.
+type Comparison struct {
+	Field string
+	// Op is one of =, !=, <, <=, > and >=.
+	Op string
+	// Value is string for String fields and time.Time for Timestamp fields.
+	Value interface{}
+}
+
+func (And) isExpr()        {}
+func (Or) isExpr()         {}
+func (Not) isExpr()        {}
+func (Comparison) isExpr() {}
+
+// Parse parses filter and validates it against schema. Empty filter
+// returns nil Expr.
+func Parse(s string, schema Schema) (Expr, error) {
+	if len(s) > MaxLength {
+		return nil, &Error{Pos: MaxLength, Msg: fmt.Sprintf("filter too long, max %d bytes", MaxLength)}
+	}
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, schema: schema}
+	if p.peek().kind == tokenEOF {
+		return nil, nil
+	}
+	e, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", describe(t))}
+	}
+	return e, nil
+}
+
+type parser struct {
+	tokens []token
+	schema Schema
+	// depth is number of parentheses and negations around current term.
+	depth int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[0]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[0]
+	if t.kind != tokenEOF {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+// expression = sequence {AND sequence}
+func (p *parser) expression() (Expr, error) {
+	left, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// sequence = factor {factor}
+func (p *parser) sequence() (Expr, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for startsTermToken(p.peek().kind) {
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// factor = term {OR term}
+func (p *parser) factor() (Expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// term = [NOT | "-"] simple
+// simple = restriction | "(" expression ")"
+func (p *parser) term() (Expr, error) {
+	if k := p.peek().kind; k == tokenNot || k == tokenMinus || k == tokenLParen {
+		if p.depth >= MaxDepth {
+			return nil, &Error{Pos: p.peek().pos, Msg: fmt.Sprintf("nesting too deep, max %d levels", MaxDepth)}
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+	}
+	if k := p.peek().kind; k == tokenNot || k == tokenMinus {
+		p.next()
+		e, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: e}, nil
+	}
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if end := p.next(); end.kind != tokenRParen {
+			return nil, &Error{Pos: end.pos, Msg: fmt.Sprintf(`expected ")", got %s`, describe(end))}
+		}
+		return e, nil
+	case tokenText:
+		return p.restriction(t)
+	}
+	return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected field, got %s", describe(t))}
+}
+
+// restriction = field comparator value
+func (p *parser) restriction(field token) (Expr, error) {
+	typ, ok := p.schema[field.text]
+	if !ok {
+		return nil, &Error{Pos: field.pos, Msg: fmt.Sprintf("unknown field %q", field.text)}
+	}
+	op := p.next()
+	switch op.kind {
+	case tokenComparator:
+	case tokenHas:
+		return nil, &Error{Pos: op.pos, Msg: `operator ":" is not supported`}
+	default:
+		return nil, &Error{Pos: op.pos, Msg: fmt.Sprintf("expected comparator after %q, got %s", field.text, describe(op))}
+	}
+	value := p.next()
+	switch value.kind {
+	case tokenString, tokenText:
+	case tokenMinus:
+		// Negative value, e.g. -1, is lexed as negation of text.
+		t := p.next()
+		if t.kind != tokenText {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected value, got %s", describe(t))}
+		}
+		value.text += t.text
+	default:
+		return nil, &Error{Pos: value.pos, Msg: fmt.Sprintf("expected value, got %s", describe(value))}
+	}
+	out := Comparison{Field: field.text, Op: op.text, Value: value.text}
+	if typ == Timestamp {
+		ts, err := time.Parse(time.RFC3339, value.text)
+		if err != nil {
+			return nil, &Error{Pos: value.pos, Msg: fmt.Sprintf("invalid timestamp %q, must be RFC 3339", value.text)}
+		}
+		out.Value = ts
+	}
+	return out, nil
+}
+
+func startsTermToken(k tokenKind) bool {
+	return k == tokenText || k == tokenNot || k == tokenMinus || k == tokenLParen
+}
+
+func describe(t token) string {
+	if t.kind == tokenText || t.kind == tokenComparator {
+		return fmt.Sprintf("%q", t.text)
+	}
+	return t.kind.String()
+}
+
+// OrderField is single field of order by.
+type OrderField struct {
+	Field string
+	Desc  bool
+}
+
+// ParseOrderBy parses comma separated list of fields, each optionally
+// followed by "asc" or "desc", e.g. "country, updated_at desc". Fields are
+// validated against schema.
+func ParseOrderBy(s string, schema Schema) ([]OrderField, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var out []OrderField
+	seen := map[string]bool{}
+	pos := 0
+	for _, part := range strings.Split(s, ",") {
+		words, positions := fields(part)
+		switch {
+		case len(words) == 0:
+			return nil, &Error{Pos: pos, Msg: "expected field"}
+		case len(words) > 2:
+			return nil, &Error{Pos: pos + positions[2], Msg: fmt.Sprintf("unexpected %q", words[2])}
+		}
+		f := OrderField{Field: words[0]}
+		if _, ok := schema[f.Field]; !ok {
+			return nil, &Error{Pos: pos + positions[0], Msg: fmt.Sprintf("unknown field %q", f.Field)}
+		}
+		if seen[f.Field] {
+			return nil, &Error{Pos: pos + positions[0], Msg: fmt.Sprintf("duplicated field %q", f.Field)}
+		}
+		seen[f.Field] = true
+		if len(words) == 2 {
+			switch words[1] {
+			case "asc":
+			case "desc":
+				f.Desc = true
+			default:
+				return nil, &Error{Pos: pos + positions[1], Msg: fmt.Sprintf(`expected "asc" or "desc", got %q`, words[1])}
+			}
+		}
+		out = append(out, f)
+		pos += len(part) + 1
+	}
+	return out, nil
+}
+
+// fields splits s around spaces and returns words with their positions.
+func fields(s string) ([]string, []int) {
+	var (
+		words     []string
+		positions []int
+	)
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && s[i] != ' ' && s[i] != '\t' {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			words = append(words, s[start:i])
+			positions = append(positions, start)
+			start = -1
+		}
+	}
+	return words, positions
+}
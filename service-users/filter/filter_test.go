@@ -0,0 +1,196 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testSchema = Schema{
+	"country":    String,
+	"first_name": String,
+	"updated_at": Timestamp,
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		in     string
+		exp    Expr
+		expErr string
+	}{
+		{
+			desc: "empty",
+			in:   "  ",
+		},
+		{
+			desc: "comparison of text and string",
+			in:   `country = US AND first_name != "John \"J\" Cash"`,
+			exp: And{
+				Left:  Comparison{Field: "country", Op: "=", Value: "US"},
+				Right: Comparison{Field: "first_name", Op: "!=", Value: `John "J" Cash`},
+			},
+		},
+		{
+			desc: "timestamp",
+			in:   `updated_at>="2026-01-01T00:00:00Z"`,
+			exp:  Comparison{Field: "updated_at", Op: ">=", Value: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			desc: "OR binds tighter than AND and implicit AND",
+			in:   `country = "US" OR country = "PL" first_name = "John" AND NOT first_name = "June"`,
+			exp: And{
+				Left: And{
+					Left: Or{
+						Left:  Comparison{Field: "country", Op: "=", Value: "US"},
+						Right: Comparison{Field: "country", Op: "=", Value: "PL"},
+					},
+					Right: Comparison{Field: "first_name", Op: "=", Value: "John"},
+				},
+				Right: Not{Expr: Comparison{Field: "first_name", Op: "=", Value: "June"}},
+			},
+		},
+		{
+			desc: "parentheses and minus negation",
+			in:   `-(country = "US" AND first_name = "John-Paul")`,
+			exp: Not{Expr: And{
+				Left:  Comparison{Field: "country", Op: "=", Value: "US"},
+				Right: Comparison{Field: "first_name", Op: "=", Value: "John-Paul"},
+			}},
+		},
+		{
+			desc:   "unknown field",
+			in:     `country = "US" AND age > 30`,
+			expErr: `column 20: unknown field "age"`,
+		},
+		{
+			desc:   "invalid timestamp",
+			in:     `updated_at > "yesterday"`,
+			expErr: `column 14: invalid timestamp "yesterday", must be RFC 3339`,
+		},
+		{
+			desc:   "missing value",
+			in:     `country = `,
+			expErr: `column 11: expected value, got end of filter`,
+		},
+		{
+			desc:   "missing comparator",
+			in:     `country "US"`,
+			expErr: `column 9: expected comparator after "country", got string`,
+		},
+		{
+			desc:   "has operator",
+			in:     `country:US`,
+			expErr: `column 8: operator ":" is not supported`,
+		},
+		{
+			desc:   "unclosed parenthesis",
+			in:     `(country = "US"`,
+			expErr: `column 16: expected ")", got end of filter`,
+		},
+		{
+			desc:   "unexpected closing parenthesis",
+			in:     `country = "US")`,
+			expErr: `column 15: unexpected ")"`,
+		},
+		{
+			desc:   "unterminated string",
+			in:     `country = "US`,
+			expErr: `column 11: unterminated string`,
+		},
+		{
+			desc:   "dangling AND",
+			in:     `country = "US" AND`,
+			expErr: `column 19: expected field, got end of filter`,
+		},
+		{
+			desc:   "single exclamation mark",
+			in:     `country ! "US"`,
+			expErr: `column 9: unexpected "!", did you mean "!="?`,
+		},
+		{
+			desc:   "nested too deep",
+			in:     strings.Repeat("(", 20) + strings.Repeat("NOT ", 20) + `country = "US"`,
+			expErr: `column 69: nesting too deep, max 32 levels`,
+		},
+		{
+			desc:   "too long",
+			in:     strings.Repeat("(", 3<<20) + `country = "US"`,
+			expErr: `column 4097: filter too long, max 4096 bytes`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := Parse(tC.in, testSchema)
+			assertErrString(t, tC.expErr, err)
+			if diff := cmp.Diff(tC.exp, got); diff != "" {
+				t.Errorf("Expression mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		in     string
+		exp    []OrderField
+		expErr string
+	}{
+		{
+			desc: "empty",
+			in:   "",
+		},
+		{
+			desc: "multiple fields",
+			in:   "country, updated_at desc,first_name asc",
+			exp: []OrderField{
+				{Field: "country"},
+				{Field: "updated_at", Desc: true},
+				{Field: "first_name"},
+			},
+		},
+		{
+			desc:   "unknown field",
+			in:     "country, age",
+			expErr: `column 10: unknown field "age"`,
+		},
+		{
+			desc:   "invalid direction",
+			in:     "country descending",
+			expErr: `column 9: expected "asc" or "desc", got "descending"`,
+		},
+		{
+			desc:   "empty field",
+			in:     "country,",
+			expErr: `column 9: expected field`,
+		},
+		{
+			desc:   "duplicated field",
+			in:     "country, country desc",
+			expErr: `column 10: duplicated field "country"`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := ParseOrderBy(tC.in, testSchema)
+			assertErrString(t, tC.expErr, err)
+			if diff := cmp.Diff(tC.exp, got); diff != "" {
+				t.Errorf("Order mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func assertErrString(t *testing.T, exp string, err error) {
+	t.Helper()
+	got := ""
+	if err != nil {
+		got = err.Error()
+	}
+	if got != exp {
+		t.Errorf("Expected error %q, got: %q", exp, got)
+	}
+}
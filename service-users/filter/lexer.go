@@ -0,0 +1,161 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenText
+	tokenString
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenMinus
+	tokenLParen
+	tokenRParen
+	tokenComparator
+	tokenHas
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of filter"
+	case tokenText:
+		return "text"
+	case tokenString:
+		return "string"
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	case tokenMinus:
+		return `"-"`
+	case tokenLParen:
+		return `"("`
+	case tokenRParen:
+		return `")"`
+	case tokenComparator:
+		return "comparator"
+	case tokenHas:
+		return `":"`
+	}
+	return "unknown token"
+}
+
+type token struct {
+	kind tokenKind
+	// text is raw token, except strings which are unquoted.
+	text string
+	pos  int
+}
+
+// lex splits filter into tokens, the last one is always tokenEOF.
+func lex(s string) ([]token, error) {
+	var out []token
+	i := 0
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			out = append(out, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			out = append(out, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case r == ':':
+			out = append(out, token{kind: tokenHas, text: ":", pos: i})
+			i++
+		case r == '=' || r == '<' || r == '>' || r == '!':
+			op := s[i : i+1]
+			if i+1 < len(s) && s[i+1] == '=' {
+				op = s[i : i+2]
+			}
+			if op == "!" {
+				return nil, &Error{Pos: i, Msg: `unexpected "!", did you mean "!="?`}
+			}
+			out = append(out, token{kind: tokenComparator, text: op, pos: i})
+			i += len(op)
+		case r == '"' || r == '\'':
+			text, n, err := unquote(s[i:])
+			if err != nil {
+				return nil, &Error{Pos: i, Msg: err.Error()}
+			}
+			out = append(out, token{kind: tokenString, text: text, pos: i})
+			i += n
+		case r == '-' && startsTerm(s, i):
+			out = append(out, token{kind: tokenMinus, text: "-", pos: i})
+			i++
+		default:
+			start := i
+			for i < len(s) {
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if unicode.IsSpace(r) || strings.ContainsRune(`()":'=<>!`, r) {
+					break
+				}
+				i += size
+			}
+			text := s[start:i]
+			kind := tokenText
+			switch text {
+			case "AND":
+				kind = tokenAnd
+			case "OR":
+				kind = tokenOr
+			case "NOT":
+				kind = tokenNot
+			}
+			out = append(out, token{kind: kind, text: text, pos: start})
+		}
+	}
+	return append(out, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+// startsTerm reports whether "-" at position i negates following term,
+// i.e. it is at the beginning of word and is not followed by space.
+func startsTerm(s string, i int) bool {
+	if i+1 >= len(s) {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(s[i+1:])
+	if unicode.IsSpace(next) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(s[:i])
+	return unicode.IsSpace(prev) || prev == '('
+}
+
+// unquote returns content of string literal at the beginning of s and its
+// length. Backslash escapes quote and backslash.
+func unquote(s string) (string, int, error) {
+	quote := s[0]
+	b := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,74 @@
+package harness
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/webhooks"
+)
+
+// Events captures published events in place of pubsub. Assertions compare
+// events published since previous assertion or Reset.
+type Events struct {
+	mu     sync.Mutex
+	events []proto.Message
+}
+
+func (e *Events) Publish(_ context.Context, in proto.Message) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, in)
+	return nil
+}
+
+// All returns events published since previous assertion or Reset.
+func (e *Events) All() []proto.Message {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]proto.Message(nil), e.events...)
+}
+
+// Reset forgets published events.
+func (e *Events) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = nil
+}
+
+// take returns published events and forgets them.
+func (e *Events) take() []proto.Message {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := e.events
+	e.events = nil
+	return out
+}
+
+// AssertTypes fails t unless events of given types, e.g. "UserCreated",
+// were published in that order.
+func (e *Events) AssertTypes(t testing.TB, types ...string) {
+	t.Helper()
+	var got []string
+	for _, ev := range e.take() {
+		got = append(got, webhooks.EventType(ev))
+	}
+	if diff := cmp.Diff(types, got); diff != "" {
+		t.Errorf("Published events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// AssertPublished fails t unless exp events were published in that order.
+// Events are compared with protocmp, opts can e.g. ignore fields set by
+// service.
+func (e *Events) AssertPublished(t testing.TB, exp []proto.Message, opts ...cmp.Option) {
+	t.Helper()
+	opts = append([]cmp.Option{protocmp.Transform()}, opts...)
+	if diff := cmp.Diff(exp, e.take(), opts...); diff != "" {
+		t.Errorf("Published events mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,164 @@
+// Package harness runs users service in-process for integration tests. It is
+// built by server package, the same as in main, and served over bufconn, by
+// default backed by in-memory SQLite store, and events it publishes are
+// captured, so tests need neither running service nor database.
+package harness
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	_ "modernc.org/sqlite"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/migrate"
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/server"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store/migrations/sqlite"
+	"github.com/tobiaszheller/example-go-microservice/service-users/webhooks"
+)
+
+const bufSize = 1 << 20
+
+// storer is everything service needs from store.
+type storer interface {
+	CreateUser(context.Context, *store.User) (*store.User, error)
+	UpdateUser(context.Context, *store.User) (*store.User, error)
+	GetUser(context.Context, string, ...store.ReadOption) (*store.User, error)
+	ListUsers(ctx context.Context, filter store.ListUsersFilter, afterID string, limit int, opts ...store.ReadOption) ([]*store.User, error)
+	DeleteUser(context.Context, string) (*store.User, error)
+	UndeleteUser(context.Context, string) (*store.User, error)
+	ListUserHistory(ctx context.Context, userID string, beforeID int64, limit int) ([]*store.AuditEntry, error)
+	BatchGetUsers(context.Context, []string) ([]*store.User, error)
+	BatchCreateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchUpdateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchGetUsersByEmail(context.Context, []string) ([]*store.User, error)
+	GetUserData(context.Context, string) (*store.UserData, error)
+	EraseUser(ctx context.Context, id, reason string) (*store.Erasure, error)
+	SearchUsers(ctx context.Context, q store.SearchQuery, offset, limit int, opts ...store.ReadOption) ([]*store.SearchResult, error)
+
+	CreateWebhookSubscription(context.Context, *store.WebhookSubscription) (*store.WebhookSubscription, error)
+	GetWebhookSubscription(context.Context, string) (*store.WebhookSubscription, error)
+	DeleteWebhookSubscription(context.Context, string) error
+	ListWebhookSubscriptions(ctx context.Context, afterID string, limit int) ([]*store.WebhookSubscription, error)
+	ListWebhookSubscriptionsByEventType(context.Context, string) ([]*store.WebhookSubscription, error)
+	CreateWebhookDeliveries(context.Context, []*store.WebhookDelivery) error
+	GetWebhookDelivery(context.Context, string) (*store.WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, subscriptionID, status, afterID string, limit int) ([]*store.WebhookDelivery, error)
+	ListWebhookDeliveryAttempts(context.Context, string) ([]*store.WebhookDeliveryAttempt, error)
+	CreateUserEvent(context.Context, *store.UserEvent) error
+}
+
+// Harness is users service running in-process.
+type Harness struct {
+	// Users and Webhooks are clients connected to service.
+	Users    pb.UsersClient
+	Webhooks pb.WebhooksClient
+	// Events captures events published by service.
+	Events *Events
+}
+
+type options struct {
+	storer     storer
+	serverOpts []server.Option
+}
+
+// Option configures Harness.
+type Option func(*options)
+
+// WithStore sets store of service, instead of in-memory SQLite store.
+func WithStore(s storer) Option {
+	return func(o *options) {
+		o.storer = s
+	}
+}
+
+// WithServerOptions configures server, e.g. its authentication or limits.
+// By default all callers are auth.AnonymousAdmin, and rate limiting and
+// deadlines are disabled.
+func WithServerOptions(opts ...server.Option) Option {
+	return func(o *options) {
+		o.serverOpts = append(o.serverOpts, opts...)
+	}
+}
+
+// New starts service and returns harness connected to it. Service is
+// stopped when t and its subtests complete.
+func New(t testing.TB, opts ...Option) *Harness {
+	t.Helper()
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.storer == nil {
+		o.storer = store.NewSQLite(newSQLiteDB(t))
+	}
+
+	events := &Events{}
+	lis := bufconn.Listen(bufSize)
+	srv, err := server.New(append([]server.Option{
+		server.WithListener(lis),
+		server.WithStore(o.storer),
+		server.WithPublisher(webhooks.NewPublisher(events, o.storer)),
+		server.WithAuthenticator(auth.AnonymousAdmins),
+	}, o.serverOpts...)...)
+	if err != nil {
+		t.Fatalf("Failed to setup server: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Run(ctx); err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer dialCancel()
+	conn, err := grpc.DialContext(dialCtx, "bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &Harness{
+		Users:    pb.NewUsersClient(conn),
+		Webhooks: pb.NewWebhooksClient(conn),
+		Events:   events,
+	}
+}
+
+// newSQLiteDB returns migrated in-memory SQLite database, closed when t
+// completes.
+func newSQLiteDB(t testing.TB) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Every connection opens its own in-memory database.
+	db.SetMaxOpenConns(1)
+	migrator, err := migrate.NewSQLite(db, sqlite.FS)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if err := migrator.Up(context.Background(), 0); err != nil {
+		t.Fatalf("Failed to migrate SQLite: %v", err)
+	}
+	return db
+}
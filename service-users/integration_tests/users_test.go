@@ -1,26 +1,29 @@
-// +build integration
-
 package integration_tests
 
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/uuid"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/harness"
 	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/server"
 )
 
 func TestUsers(t *testing.T) {
-	cli, conn := mustSetupClient(t)
-	defer conn.Close()
+	h := harness.New(t)
+	cli := h.Users
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
@@ -37,17 +40,23 @@ func TestUsers(t *testing.T) {
 	t.Run("must create new user", func(t *testing.T) {
 		got, err := cli.CreateUser(ctx, &pb.CreateUserRequest{User: firstUser})
 		assertNoErr(t, err)
-		assertUserEqual(t, firstUser, got, cmpopts.IgnoreFields(pb.User{}, "Id", "UpdatedAt"))
+		assertUserEqual(t, firstUser, got, ignoreOutputFields)
 		firstUserId = got.Id
+		if got.GetCreatedAt() == nil || got.GetCreatedBy() == "" || got.GetUpdatedBy() != got.GetCreatedBy() {
+			t.Errorf("Expected created_at, created_by and updated_by to be set, got: %v", got)
+		}
+
+		h.Events.AssertPublished(t, []proto.Message{&pb.UserCreated{User: got}})
 
 		// Make sure you cannot create user with the same email twice.
 		got, err = cli.CreateUser(ctx, &pb.CreateUserRequest{User: firstUser})
 		assertErr(t, err, codes.AlreadyExists)
+		h.Events.AssertTypes(t)
 	})
 	t.Run("must get user", func(t *testing.T) {
 		got, err := cli.GetUser(ctx, &pb.GetUserRequest{Id: firstUserId})
 		assertNoErr(t, err)
-		assertUserEqual(t, firstUser, got, cmpopts.IgnoreFields(pb.User{}, "Id", "UpdatedAt"))
+		assertUserEqual(t, firstUser, got, ignoreOutputFields)
 
 		// Make sure you cannot get user with invalid id.
 		got, err = cli.GetUser(ctx, &pb.GetUserRequest{Id: "invalid-id"})
@@ -64,12 +73,13 @@ func TestUsers(t *testing.T) {
 		}
 		got, err := cli.UpdateUser(ctx, &pb.UpdateUserRequest{User: updateReq})
 		assertNoErr(t, err)
-		assertUserEqual(t, updateReq, got, cmpopts.IgnoreFields(pb.User{}, "Id", "UpdatedAt"))
+		assertUserEqual(t, updateReq, got, ignoreOutputFields)
+		h.Events.AssertPublished(t, []proto.Message{&pb.UserUpdated{User: got}})
 
 		// Make sure that also after get we receive updated user.
 		got, err = cli.GetUser(ctx, &pb.GetUserRequest{Id: firstUserId})
 		assertNoErr(t, err)
-		assertUserEqual(t, updateReq, got, cmpopts.IgnoreFields(pb.User{}, "Id", "UpdatedAt"))
+		assertUserEqual(t, updateReq, got, ignoreOutputFields)
 
 		// Make sure you cannot update user with invalid id.
 		got, err = cli.UpdateUser(ctx, &pb.UpdateUserRequest{User: &pb.User{
@@ -78,17 +88,161 @@ func TestUsers(t *testing.T) {
 		}})
 		assertErr(t, err, codes.NotFound)
 	})
+	t.Run("must soft delete and restore user", func(t *testing.T) {
+		_, err := cli.DeleteUser(ctx, &pb.DeleteUserRequest{Id: firstUserId})
+		assertNoErr(t, err)
+
+		// Deleted user is hidden unless explicitly requested.
+		_, err = cli.GetUser(ctx, &pb.GetUserRequest{Id: firstUserId})
+		assertErr(t, err, codes.NotFound)
+		got, err := cli.GetUser(ctx, &pb.GetUserRequest{Id: firstUserId, ShowDeleted: true})
+		assertNoErr(t, err)
+		if got.GetDeletedAt() == nil {
+			t.Errorf("Expected deleted_at to be set")
+		}
+
+		got, err = cli.UndeleteUser(ctx, &pb.UndeleteUserRequest{Id: firstUserId})
+		assertNoErr(t, err)
+		if got.GetDeletedAt() != nil {
+			t.Errorf("Expected deleted_at to be cleared")
+		}
+
+		// Make sure you cannot restore user which is not deleted.
+		_, err = cli.UndeleteUser(ctx, &pb.UndeleteUserRequest{Id: firstUserId})
+		assertErr(t, err, codes.FailedPrecondition)
+		h.Events.AssertTypes(t, "UserDeleted", "UserRestored")
+	})
+	t.Run("must list user history", func(t *testing.T) {
+		got, err := cli.ListUserHistory(ctx, &pb.ListUserHistoryRequest{UserId: firstUserId})
+		assertNoErr(t, err)
+		var actions []pb.UserHistoryEntry_Action
+		for _, e := range got.GetEntries() {
+			actions = append(actions, e.GetAction())
+		}
+		exp := []pb.UserHistoryEntry_Action{
+			pb.UserHistoryEntry_UNDELETE,
+			pb.UserHistoryEntry_DELETE,
+			pb.UserHistoryEntry_UPDATE,
+			pb.UserHistoryEntry_CREATE,
+		}
+		if diff := cmp.Diff(exp, actions); diff != "" {
+			t.Errorf("History actions mismatch, diff: %s", diff)
+		}
+	})
+	t.Run("must create, update and get users in batch", func(t *testing.T) {
+		email := fmt.Sprintf("%s@test.com", uuid.New().String())
+		created, err := cli.BatchCreateUsers(ctx, &pb.BatchCreateUsersRequest{Requests: []*pb.CreateUserRequest{
+			{User: &pb.User{FirstName: "Johnny", Email: email}},
+			{User: &pb.User{FirstName: "June", Email: email}},
+		}})
+		assertNoErr(t, err)
+		if created.Results[0].GetUser() == nil || created.Results[1].GetError().GetCode() != int32(codes.AlreadyExists) {
+			t.Fatalf("Expected first user to be created and second to fail, got: %v", created)
+		}
+		id := created.Results[0].GetUser().GetId()
+
+		// Atomic batch must not update anything if single item fails.
+		_, err = cli.BatchUpdateUsers(ctx, &pb.BatchUpdateUsersRequest{Atomic: true, Requests: []*pb.UpdateUserRequest{
+			{User: &pb.User{Id: id, FirstName: "John", Email: email}},
+			{User: &pb.User{Id: "invalid-id", Email: email}},
+		}})
+		assertNoErr(t, err)
+		got, err := cli.BatchGetUsers(ctx, &pb.BatchGetUsersRequest{Ids: []string{id, "invalid-id"}})
+		assertNoErr(t, err)
+		if len(got.GetUsers()) != 1 || got.GetUsers()[0].GetFirstName() != "Johnny" {
+			t.Errorf("Expected user not to be updated, got: %v", got.GetUsers())
+		}
+		if diff := cmp.Diff([]string{"invalid-id"}, got.GetNotFoundIds()); diff != "" {
+			t.Errorf("Not found ids mismatch, diff: %s", diff)
+		}
+	})
+	t.Run("must filter and order users", func(t *testing.T) {
+		lastName := "Filter" + strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
+		for _, firstName := range []string{"Ann", "Bob", "Cid"} {
+			_, err := cli.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{
+				FirstName: firstName,
+				LastName:  lastName,
+				Email:     fmt.Sprintf("%s@test.com", uuid.New().String()),
+			}})
+			assertNoErr(t, err)
+		}
+		var names []string
+		req := &pb.ListUsersRequest{
+			Filter:   fmt.Sprintf(`last_name = %q AND first_name != "B*"`, lastName),
+			OrderBy:  "first_name desc",
+			PageSize: 1,
+		}
+		for {
+			got, err := cli.ListUsers(ctx, req)
+			assertNoErr(t, err)
+			for _, u := range got.GetUsers() {
+				names = append(names, u.GetFirstName())
+			}
+			if got.GetNextPageToken() == "" {
+				break
+			}
+			req.PageToken = got.GetNextPageToken()
+		}
+		if diff := cmp.Diff([]string{"Cid", "Ann"}, names); diff != "" {
+			t.Errorf("Listed users mismatch, diff: %s", diff)
+		}
+
+		_, err := cli.ListUsers(ctx, &pb.ListUsersRequest{Filter: "age > 30"})
+		assertErr(t, err, codes.InvalidArgument)
+	})
+	t.Run("must search users", func(t *testing.T) {
+		// Unique last name keeps results independent of previous runs.
+		lastName := "Cash" + strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
+		for _, firstName := range []string{"Johnny", "June"} {
+			_, err := cli.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{
+				FirstName: firstName,
+				LastName:  lastName,
+				Email:     fmt.Sprintf("%s@test.com", uuid.New().String()),
+			}})
+			assertNoErr(t, err)
+		}
+		got, err := cli.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "joh " + lastName})
+		assertNoErr(t, err)
+		if len(got.GetResults()) != 1 || got.GetResults()[0].GetUser().GetFirstName() != "Johnny" {
+			t.Errorf("Expected only Johnny to be found by prefix, got: %v", got.GetResults())
+		}
+		got, err = cli.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "jonny " + lastName, Fuzzy: true})
+		assertNoErr(t, err)
+		if len(got.GetResults()) < 2 || got.GetResults()[0].GetUser().GetFirstName() != "Johnny" {
+			t.Errorf("Expected Johnny to be ranked first by fuzzy search, got: %v", got.GetResults())
+		}
+	})
 }
 
-func mustSetupClient(t *testing.T) (pb.UsersClient, *grpc.ClientConn) {
-	// FIXME: pass host addr to test via env.
-	conn, err := grpc.Dial(":18082", grpc.WithInsecure())
-	if err != nil {
-		t.Fatalf("Did not connect: %v", err)
+func TestTenantIsolation(t *testing.T) {
+	tokens, err := auth.ParseStaticTokens("acme-token=acme/alice,globex-token=globex/bob")
+	assertNoErr(t, err)
+	h := harness.New(t, harness.WithServerOptions(server.WithAuthenticator(tokens)))
+	cli := h.Users
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	acme := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer acme-token")
+	globex := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer globex-token")
+
+	_, err = cli.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{Email: "john@test.com"}})
+	assertErr(t, err, codes.Unauthenticated)
+
+	created, err := cli.CreateUser(acme, &pb.CreateUserRequest{User: &pb.User{FirstName: "John", Email: "john@test.com"}})
+	assertNoErr(t, err)
+	if created.GetTenantId() != "acme" || created.GetCreatedBy() != "alice" {
+		t.Errorf("Expected user created by alice in acme tenant, got: %v", created)
 	}
-	return pb.NewUsersClient(conn), conn
+	_, err = cli.GetUser(globex, &pb.GetUserRequest{Id: created.GetId()})
+	assertErr(t, err, codes.NotFound)
+	// The same email can be used in other tenant.
+	_, err = cli.CreateUser(globex, &pb.CreateUserRequest{User: &pb.User{FirstName: "John", Email: "john@test.com"}})
+	assertNoErr(t, err)
+	h.Events.AssertTypes(t, "UserCreated", "UserCreated")
 }
 
+// ignoreOutputFields ignores fields set by service.
+var ignoreOutputFields = cmpopts.IgnoreFields(pb.User{}, "Id", "UpdatedAt", "CreatedAt", "CreatedBy", "UpdatedBy", "TenantId")
+
 func assertNoErr(t *testing.T, err error) {
 	t.Helper()
 	if err != nil {
@@ -0,0 +1,181 @@
+// Package loadshed rejects gRPC calls when service is overloaded, instead
+// of letting them queue for DB connections.
+package loadshed
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config of Limiter.
+type Config struct {
+	// InitialLimit, MinLimit and MaxLimit bound number of calls handled
+	// concurrently.
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// LatencyThreshold is latency above which call is treated as sign of
+	// overload and limit is decreased.
+	LatencyThreshold time.Duration
+	// Backoff is factor by which limit is multiplied on overload.
+	Backoff float64
+	// MaxPoolWait is average time of waiting for DB connection above which
+	// all calls are rejected, until it drops. Zero disables the check.
+	MaxPoolWait time.Duration
+	// PoolSampleInterval is how often DB pool stats are sampled.
+	PoolSampleInterval time.Duration
+}
+
+var (
+	limitGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "users_concurrency_limit",
+		Help: "Current limit of concurrently handled gRPC requests.",
+	})
+	inFlightGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "users_in_flight_requests",
+		Help: "Number of concurrently handled gRPC requests.",
+	})
+	poolWaitGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "users_db_pool_wait_seconds",
+		Help: "Average time of waiting for DB connection in last sample.",
+	})
+	shedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "users_shed_requests_total",
+		Help: "Total number of gRPC requests rejected due to overload, by reason.",
+	}, []string{"reason"})
+)
+
+// Limiter limits number of concurrent calls with AIMD: limit grows by one
+// per limit of calls faster than threshold and is cut by backoff factor on
+// every slower or timed out call.
+type Limiter struct {
+	cfg       Config
+	poolStats func() sql.DBStats
+	now       func() time.Time
+
+	mu         sync.Mutex
+	limit      float64
+	inFlight   int
+	lastSample time.Time
+	lastStats  sql.DBStats
+	poolWait   time.Duration
+}
+
+// New returns limiter. If poolStats is not nil, wait time of DB pool is
+// checked as well.
+func New(cfg Config, poolStats func() sql.DBStats) *Limiter {
+	l := &Limiter{
+		cfg:       cfg,
+		poolStats: poolStats,
+		now:       time.Now,
+		limit:     float64(cfg.InitialLimit),
+	}
+	limitGauge.Set(l.limit)
+	return l
+}
+
+// acquire reserves slot for call. If service is overloaded, it returns
+// false along with reason.
+func (l *Limiter) acquire() (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samplePool()
+	if l.cfg.MaxPoolWait > 0 && l.poolWait > l.cfg.MaxPoolWait {
+		return false, "db_pool_wait"
+	}
+	if l.inFlight >= int(l.limit) {
+		return false, "concurrency"
+	}
+	l.inFlight++
+	inFlightGauge.Set(float64(l.inFlight))
+	return true, ""
+}
+
+// release frees slot of call.
+func (l *Limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	inFlightGauge.Set(float64(l.inFlight))
+}
+
+// adjust changes limit by outcome of call.
+func (l *Limiter) adjust(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if latency > l.cfg.LatencyThreshold || status.Code(err) == codes.DeadlineExceeded {
+		l.limit *= l.cfg.Backoff
+		if l.limit < float64(l.cfg.MinLimit) {
+			l.limit = float64(l.cfg.MinLimit)
+		}
+	} else {
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.cfg.MaxLimit) {
+			l.limit = float64(l.cfg.MaxLimit)
+		}
+	}
+	limitGauge.Set(l.limit)
+}
+
+// samplePool updates average wait time of DB pool since previous sample.
+// It must be called with mu held.
+func (l *Limiter) samplePool() {
+	if l.poolStats == nil {
+		return
+	}
+	now := l.now()
+	if now.Sub(l.lastSample) < l.cfg.PoolSampleInterval {
+		return
+	}
+	stats := l.poolStats()
+	l.poolWait = 0
+	if waits := stats.WaitCount - l.lastStats.WaitCount; waits > 0 && !l.lastSample.IsZero() {
+		l.poolWait = (stats.WaitDuration - l.lastStats.WaitDuration) / time.Duration(waits)
+	}
+	l.lastSample, l.lastStats = now, stats
+	poolWaitGauge.Set(l.poolWait.Seconds())
+}
+
+func (l *Limiter) check() error {
+	if ok, reason := l.acquire(); !ok {
+		shedTotal.WithLabelValues(reason).Inc()
+		return grpc.Errorf(codes.Unavailable, "service is overloaded, retry later")
+	}
+	return nil
+}
+
+// UnaryServerInterceptor rejects calls with Unavailable when service is
+// overloaded.
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := l.check(); err != nil {
+			return nil, err
+		}
+		start := l.now()
+		resp, err := handler(ctx, req)
+		l.release()
+		l.adjust(l.now().Sub(start), err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is streaming counterpart of UnaryServerInterceptor.
+// Streams take slot while open, but their duration depends on client, so it
+// does not affect limit.
+func StreamServerInterceptor(l *Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.check(); err != nil {
+			return err
+		}
+		defer l.release()
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,98 @@
+package loadshed
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimiterAdjustsLimit(t *testing.T) {
+	l := New(Config{
+		InitialLimit:     2,
+		MinLimit:         1,
+		MaxLimit:         3,
+		LatencyThreshold: 100 * time.Millisecond,
+		Backoff:          0.5,
+	}, nil)
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	interceptor := UnaryServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: "/m/Get"}
+
+	// Handler blocked on nested calls keeps slots taken.
+	var nested func(depth int) error
+	nested = func(depth int) error {
+		_, err := interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			if depth == 0 {
+				return nil, nil
+			}
+			return nil, nested(depth - 1)
+		})
+		return err
+	}
+	if err := nested(1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err := nested(2)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable over limit, got: %v", err)
+	}
+
+	// Fast calls increase limit up to max.
+	for i := 0; i < 10; i++ {
+		interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	}
+	if l.limit != 3 {
+		t.Errorf("Expected limit 3, got: %v", l.limit)
+	}
+
+	// Slow call halves limit.
+	interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		now = now.Add(time.Second)
+		return nil, nil
+	})
+	if l.limit != 1.5 {
+		t.Errorf("Expected limit 1.5, got: %v", l.limit)
+	}
+	if l.inFlight != 0 {
+		t.Errorf("Expected no calls in flight, got: %d", l.inFlight)
+	}
+}
+
+func TestLimiterPoolWait(t *testing.T) {
+	stats := sql.DBStats{}
+	l := New(Config{
+		InitialLimit:       10,
+		MinLimit:           1,
+		MaxLimit:           10,
+		LatencyThreshold:   time.Second,
+		Backoff:            0.5,
+		MaxPoolWait:        50 * time.Millisecond,
+		PoolSampleInterval: time.Second,
+	}, func() sql.DBStats { return stats })
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.acquire(); !ok {
+		t.Fatalf("Expected call to be allowed")
+	}
+	stats = sql.DBStats{WaitCount: 10, WaitDuration: time.Second}
+	if ok, _ := l.acquire(); !ok {
+		t.Fatalf("Expected call to be allowed before next sample")
+	}
+	now = now.Add(time.Second)
+	if ok, reason := l.acquire(); ok || reason != "db_pool_wait" {
+		t.Fatalf("Expected call to be shed due to pool wait, got: %v %s", ok, reason)
+	}
+	now = now.Add(time.Second)
+	if ok, _ := l.acquire(); !ok {
+		t.Fatalf("Expected call to be allowed once waits stopped")
+	}
+}
@@ -1,89 +1,421 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"net"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
-	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
-	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
-	"github.com/kelseyhightower/envconfig"
+	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
+	_ "modernc.org/sqlite"
 
-	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/cache"
+	"github.com/tobiaszheller/example-go-microservice/service-users/config"
+	"github.com/tobiaszheller/example-go-microservice/service-users/deadline"
+	"github.com/tobiaszheller/example-go-microservice/service-users/envelope"
+	"github.com/tobiaszheller/example-go-microservice/service-users/loadshed"
+	"github.com/tobiaszheller/example-go-microservice/service-users/migrate"
 	"github.com/tobiaszheller/example-go-microservice/service-users/pubsubmock"
-	"github.com/tobiaszheller/example-go-microservice/service-users/rpc"
+	"github.com/tobiaszheller/example-go-microservice/service-users/purger"
+	"github.com/tobiaszheller/example-go-microservice/service-users/ratelimit"
+	"github.com/tobiaszheller/example-go-microservice/service-users/server"
 	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store/migrations"
+	pgmigrations "github.com/tobiaszheller/example-go-microservice/service-users/store/migrations/postgres"
+	sqlitemigrations "github.com/tobiaszheller/example-go-microservice/service-users/store/migrations/sqlite"
 	"github.com/tobiaszheller/example-go-microservice/service-users/telemetry"
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+	"github.com/tobiaszheller/example-go-microservice/service-users/webhooks"
 )
 
-type config struct {
-	GRPCAddr      string `envconfig:"GRPC_ADDR" default:":18082"`
-	TelemetryAddr string `envconfig:"TELEMETRY_ADDR" default:":18083"`
-	DBDSN         string `envconfig:"DB_DSN" default:"user:password@tcp(127.0.0.1:23306)/test"`
-}
-
 func main() {
-	var cfg config
-	if err := envconfig.Process("", &cfg); err != nil {
+	configFile := os.Getenv("CONFIG_FILE")
+	cfg, err := config.Load(configFile)
+	if err != nil {
 		log.Fatal(err)
 	}
+	// Loaded config is validated, so parsing it can't fail.
+	level, _ := log.ParseLevel(cfg.LogLevel)
+	log.SetLevel(level)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		if err := runReencrypt(cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	tenant.SetLabels(cfg.MetricsTenants)
 
-	store := store.New(mustConnectDB(cfg))
-	service := rpc.New(store, pubsubmock.New())
+	db := mustConnectDB(cfg)
+	driver, _ := parseDSN(cfg.DBDSN)
+	if driver == driverSQLite {
+		// SQLite database is often in memory, so it has to be migrated on
+		// every start.
+		mustMigrateUp(cfg, db)
+	} else if cfg.DBSchemaCheck {
+		mustCheckSchema(cfg, db)
+	}
+	mustRegisterDBStats("primary", db)
+	var replicas []*sql.DB
+	for i, dsn := range cfg.DBReplicaDSNs {
+		replica := mustConnectReplicaDB(cfg, dsn)
+		mustRegisterDBStats(fmt.Sprintf("replica-%d", i), replica)
+		replicas = append(replicas, replica)
+	}
+	store := store.NewForDriver(driver, db, replicas...)
+	store.SetRetryPolicy(storeRetryPolicy(cfg))
+	if cfg.PIIKeyfile != "" {
+		keys := mustReadKeyfile(cfg.PIIKeyfile)
+		store.EnableEncryption(keys, keys.BlindIndexKey)
+	} else {
+		log.Warn("Encryption of PII is disabled")
+	}
+	pubsub := pubsubmock.New()
+	publisher := webhooks.NewPublisher(pubsub, store)
+	reloader := config.NewReloader(configFile, cfg)
+	opts := []server.Option{
+		server.WithAddr(cfg.GRPCAddr),
+		server.WithTelemetry(cfg.TelemetryAddr),
+		server.WithShutdownTimeout(cfg.ShutdownTimeout),
+		server.WithStore(store),
+		server.WithPublisher(publisher),
+		server.WithMaxBatchSize(cfg.MaxBatchSize),
+	}
+	if cfg.CacheSize > 0 {
+		cached := cache.NewStorer(store, cache.Config{
+			Size:        cfg.CacheSize,
+			TTL:         cfg.CacheTTL,
+			NegativeTTL: cfg.CacheNegativeTTL,
+		})
+		pubsub.Subscribe(cached.HandleEvent)
+		opts = append(opts, server.WithCache(cached))
+	}
+	interceptorOpts, err := interceptorOptions(cfg, db.Stats)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, interceptorOpts...)
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		opts = append(opts, server.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	}
+	if len(replicas) > 0 {
+		opts = append(opts, server.WithWorker(func(ctx context.Context) error {
+			return store.RunReplicaHealthChecks(ctx, cfg.DBReplicaHealthInterval)
+		}))
+	}
+	dispatcher := webhooks.NewDispatcher(webhooks.DispatcherConfig{
+		PollInterval:   cfg.WebhooksPollInterval,
+		BatchSize:      cfg.WebhooksBatchSize,
+		Timeout:        cfg.WebhooksTimeout,
+		MaxAttempts:    cfg.WebhooksMaxAttempts,
+		InitialBackoff: cfg.WebhooksInitialBackoff,
+		MaxBackoff:     cfg.WebhooksMaxBackoff,
+	}, store)
+	purger := purger.New(purger.Config{
+		Interval:  cfg.PurgeInterval,
+		Retention: cfg.PurgeRetention,
+		BatchSize: cfg.PurgeBatchSize,
+	}, store, publisher)
+	opts = append(opts, server.WithWorker(dispatcher.Run), server.WithWorker(purger.Run))
 
-	grpcServer, lis := mustSetupGRPC(cfg, func(s *grpc.Server) {
-		pb.RegisterUsersServer(s, service)
-		grpc_prometheus.Register(s)
-	})
-	go func() {
-		if err := telemetry.Serve(cfg.TelemetryAddr); err != nil {
-			log.Fatal(err)
+	if configFile != "" {
+		opts = append(opts, server.WithWorker(reloader.Run))
+	}
+	// Config shows settings of service, e.g. paths and tenants, so it is
+	// served only to callers with debug token.
+	if cfg.DebugToken != "" {
+		opts = append(opts, server.WithTelemetryHandler("/config", telemetry.RequireToken(cfg.DebugToken, reloader)))
+	} else {
+		log.Info("Config endpoint is disabled, DEBUG_TOKEN is not set")
+	}
+	if cfg.DebugEndpoints {
+		if cfg.DebugToken == "" {
+			log.Warn("Debug endpoints are enabled without token")
 		}
-	}()
-	if err := runGRPC(grpcServer, lis); err != nil {
+		opts = append(opts, server.WithTelemetryHandler("/debug/", telemetry.DebugHandler(cfg.DebugToken)))
+	}
+
+	srv, err := server.New(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reloader.OnReload(func(cfg *config.Config) {
+		level, _ := log.ParseLevel(cfg.LogLevel)
+		log.SetLevel(level)
+		limits, _ := ratelimit.ParseLimits(cfg.RateLimits)
+		srv.SetRateLimits(limits)
+	})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Run(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// TODO: grpc helpers should be moved into some helper lib.
-func mustSetupGRPC(cfg config, registerFn func(*grpc.Server)) (*grpc.Server, net.Listener) {
-	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+// interceptorOptions returns options of server interceptors configured by
+// cfg. Load shedding watches pool described by poolStats.
+func interceptorOptions(cfg *config.Config, poolStats func() sql.DBStats) ([]server.Option, error) {
+	var opts []server.Option
+	if cfg.AuthTokens != "" {
+		tokens, err := auth.ParseStaticTokens(cfg.AuthTokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse auth tokens: %w", err)
+		}
+		opts = append(opts, server.WithAuthenticator(tokens))
+	} else if cfg.AnonymousAdmin {
+		log.Warn("Authentication is disabled and all callers are treated as admins")
+		opts = append(opts, server.WithAuthenticator(auth.AnonymousAdmins))
+	}
+	limits, err := ratelimit.ParseLimits(cfg.RateLimits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rate limits: %w", err)
+	}
+	timeouts, err := deadline.ParseTimeouts(cfg.Deadlines)
 	if err != nil {
-		log.Fatalf("Failed to start listener %v", err)
-	}
-	log.Infof("Will setup gRPC server at: %s", lis.Addr().String())
-	// TODO: in real life implementation following options shoud be passed:
-	// - TLS credentails
-	// - greaceful shutdown
-	// - interceptor for passing trace_id from incomming request
-	// - interceptor for panic recovey
-	// - interceptor for authorization
-	grpcServer := grpc.NewServer(
-		grpc_middleware.WithUnaryServerChain(
-			grpc_logrus.UnaryServerInterceptor(log.NewEntry(log.New())),
-		),
-	)
-
-	registerFn(grpcServer)
-	return grpcServer, lis
+		return nil, fmt.Errorf("failed to parse deadlines: %w", err)
+	}
+	opts = append(opts, server.WithRateLimits(limits), server.WithDeadlines(timeouts))
+	if cfg.LoadShedding {
+		opts = append(opts, server.WithLoadShedding(loadshed.Config{
+			InitialLimit:       cfg.LoadSheddingInitialLimit,
+			MinLimit:           cfg.LoadSheddingMinLimit,
+			MaxLimit:           cfg.LoadSheddingMaxLimit,
+			LatencyThreshold:   cfg.LoadSheddingLatencyThreshold,
+			Backoff:            cfg.LoadSheddingBackoff,
+			MaxPoolWait:        cfg.LoadSheddingMaxPoolWait,
+			PoolSampleInterval: time.Second,
+		}, poolStats))
+	}
+	return opts, nil
 }
 
-func runGRPC(srv *grpc.Server, lis net.Listener) error {
-	log.Info("Starting gRPC server")
-	defer lis.Close()
-	return srv.Serve(lis)
+const (
+	dbConnectInitialBackoff = 500 * time.Millisecond
+	dbConnectMaxBackoff     = 10 * time.Second
+)
+
+// Drivers of databases supported by store.
+const (
+	driverMySQL    = "mysql"
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+// parseDSN returns name of driver selected by scheme of dsn, along with DSN
+// accepted by that driver. SQLite DSN is path of database file or :memory:,
+// foreign keys are enabled in it, as store relies on cascading deletes.
+func parseDSN(dsn string) (string, string) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return driverPostgres, dsn
+	}
+	if path := strings.TrimPrefix(dsn, "sqlite://"); path != dsn {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		return driverSQLite, path + sep + "_pragma=foreign_keys(1)"
+	}
+	return driverMySQL, strings.TrimPrefix(dsn, "mysql://")
 }
 
-func mustConnectDB(cfg config) *sql.DB {
-	db, err := sql.Open("mysql", cfg.DBDSN)
+// mustConnectDB opens primary DB and pings it until it succeeds or
+// DBConnectTimeout passes, so service can start along with DB.
+func mustConnectDB(cfg *config.Config) *sql.DB {
+	driver, dsn := parseDSN(cfg.DBDSN)
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		log.Fatalf("Failed to connect to DB: %v", err)
 	}
-	if err := db.Ping(); err != nil {
+	configureDBPool(cfg, driver, db)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DBConnectTimeout)
+	defer cancel()
+	if err := pingWithBackoff(ctx, db); err != nil {
 		log.Fatalf("Failed to ping to DB: %v", err)
 	}
 	return db
 }
+
+// pingWithBackoff pings db with exponential backoff until it succeeds or
+// ctx is done.
+func pingWithBackoff(ctx context.Context, db *sql.DB) error {
+	backoff := dbConnectInitialBackoff
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("Failed to ping to DB, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > dbConnectMaxBackoff {
+			backoff = dbConnectMaxBackoff
+		}
+	}
+}
+
+// mustConnectReplicaDB opens replica without pinging it, unavailable replica
+// is skipped by health checks and must not prevent service from starting.
+func mustConnectReplicaDB(cfg *config.Config, dsn string) *sql.DB {
+	driver, dsn := parseDSN(dsn)
+	if primary, _ := parseDSN(cfg.DBDSN); driver != primary {
+		log.Fatalf("DB replica must be %s database, got %s", primary, driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		log.Fatalf("Failed to connect to DB replica: %v", err)
+	}
+	configureDBPool(cfg, driver, db)
+	return db
+}
+
+func configureDBPool(cfg *config.Config, driver string, db *sql.DB) {
+	if driver == driverSQLite {
+		// SQLite locks whole database on write anyway, and in-memory
+		// database lives only as long as its single connection.
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		return
+	}
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+}
+
+func storeRetryPolicy(cfg *config.Config) store.RetryPolicy {
+	return store.RetryPolicy{
+		MaxAttempts:    cfg.DBTxMaxAttempts,
+		InitialBackoff: cfg.DBTxInitialBackoff,
+		MaxBackoff:     cfg.DBTxMaxBackoff,
+	}
+}
+
+func mustRegisterDBStats(name string, db *sql.DB) {
+	if err := telemetry.RegisterDBStats(name, db); err != nil {
+		log.Fatalf("Failed to register metrics of DB %s: %v", name, err)
+	}
+}
+
+// newMigrator returns migrator of database selected by DBDSN.
+func newMigrator(cfg *config.Config, db *sql.DB) (*migrate.Migrator, error) {
+	switch driver, _ := parseDSN(cfg.DBDSN); driver {
+	case driverPostgres:
+		return migrate.NewPostgres(db, pgmigrations.FS)
+	case driverSQLite:
+		return migrate.NewSQLite(db, sqlitemigrations.FS)
+	}
+	return migrate.New(db, migrations.FS)
+}
+
+func mustMigrateUp(cfg *config.Config, db *sql.DB) {
+	migrator, err := newMigrator(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	if err := migrator.Up(context.Background(), 0); err != nil {
+		log.Fatalf("Failed to migrate DB: %v", err)
+	}
+}
+
+func mustCheckSchema(cfg *config.Config, db *sql.DB) {
+	migrator, err := newMigrator(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	if err := migrator.CheckSchema(context.Background()); err != nil {
+		log.Fatalf("Invalid DB schema: %v", err)
+	}
+}
+
+// runMigrate handles "migrate up [N] | down [N] | status | force VERSION" command.
+// Down without N rolls back single migration.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up [N] | down [N] | status | force VERSION")
+	}
+	var n uint64
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			return fmt.Errorf("invalid argument %q: %w", args[1], err)
+		}
+	}
+	db := mustConnectDB(cfg)
+	defer db.Close()
+	migrator, err := newMigrator(cfg, db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx, int(n))
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		return migrator.Down(ctx, int(n))
+	case "force":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: migrate force VERSION")
+		}
+		return migrator.Force(ctx, n)
+	case "status":
+		st, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version: %d\ndirty: %t\nlatest: %d\n", st.Current, st.Dirty, st.Latest)
+		for _, mig := range st.Pending {
+			fmt.Printf("pending: %d_%s\n", mig.Version, mig.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
+
+// runReencrypt encrypts users which are not encrypted yet and rewraps data
+// keys with current key of keyfile, it has to be run after key rotation.
+func runReencrypt(cfg *config.Config) error {
+	if cfg.PIIKeyfile == "" {
+		return fmt.Errorf("PII_KEYFILE must be set")
+	}
+	db := mustConnectDB(cfg)
+	defer db.Close()
+	keys := mustReadKeyfile(cfg.PIIKeyfile)
+	driver, _ := parseDSN(cfg.DBDSN)
+	s := store.NewForDriver(driver, db)
+	s.EnableEncryption(keys, keys.BlindIndexKey)
+	n, err := s.ReencryptUsers(context.Background(), cfg.PIIReencryptBatchSize)
+	log.Infof("Reencrypted %d users with key %q", n, keys.CurrentKeyID())
+	return err
+}
+
+func mustReadKeyfile(path string) *envelope.Keyfile {
+	keys, err := envelope.ReadKeyfile(path)
+	if err != nil {
+		log.Fatalf("Failed to read PII keyfile: %v", err)
+	}
+	return keys
+}
@@ -0,0 +1,382 @@
+// Package migrate applies versioned SQL migrations to MySQL, Postgres or
+// SQLite database.
+//
+// Applied version is kept in schema_migrations table compatible with
+// golang-migrate, so database migrated by one tool can be handled by the other.
+// Concurrent runs, e.g. several replicas starting at once, are serialized
+// with MySQL named lock or Postgres advisory lock. SQLite database is meant
+// to be used by single process, so its migrations are not locked.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// nilVersion is stored in dirty state when migration down to empty schema fails.
+const nilVersion = -1
+
+const (
+	createVersionTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint not null primary key, dirty boolean not null)`
+	getVersionQuery         = `SELECT version, dirty FROM schema_migrations LIMIT 1`
+)
+
+// dialect holds statements which differ between databases.
+type dialect struct {
+	truncateVersionQuery string
+	insertVersionQuery   string
+	// lock takes migration lock of conn, waiting for it up to timeout.
+	lock func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error
+	// releaseLockQuery releases lock taken by lock, it is empty if lock is
+	// released with connection.
+	releaseLockQuery string
+}
+
+var mysqlDialect = &dialect{
+	truncateVersionQuery: `TRUNCATE schema_migrations`,
+	insertVersionQuery:   `INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`,
+	lock:                 mysqlLock,
+	releaseLockQuery:     `SELECT RELEASE_LOCK(CONCAT('schema_migrations:', DATABASE()))`,
+}
+
+var postgresDialect = &dialect{
+	truncateVersionQuery: `TRUNCATE schema_migrations`,
+	insertVersionQuery:   `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`,
+	lock:                 postgresLock,
+	releaseLockQuery:     `SELECT pg_advisory_unlock(hashtext('schema_migrations:' || current_database()))`,
+}
+
+var sqliteDialect = &dialect{
+	truncateVersionQuery: `DELETE FROM schema_migrations`,
+	insertVersionQuery:   `INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`,
+	lock:                 noLock,
+}
+
+const (
+	mysqlGetLockQuery       = `SELECT GET_LOCK(CONCAT('schema_migrations:', DATABASE()), ?)`
+	postgresTryLockQuery    = `SELECT pg_try_advisory_lock(hashtext('schema_migrations:' || current_database()))`
+	postgresLockRetryPeriod = 500 * time.Millisecond
+)
+
+var (
+	ErrDirty        = errors.New("database is dirty, fix it manually and force version")
+	ErrSchemaBehind = errors.New("database schema is behind")
+	ErrLockTimeout  = errors.New("timeout waiting for migration lock")
+)
+
+// Status describes state of database schema.
+type Status struct {
+	// Current is applied version, 0 if no migration was applied.
+	Current uint64
+	// Dirty is true if applying of Current version failed.
+	Dirty bool
+	// Latest is version of the newest known migration.
+	Latest uint64
+	// Pending are known migrations newer than Current.
+	Pending []Migration
+}
+
+// Migrator applies migrations to database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    *dialect
+	migrations []Migration
+	// LockTimeout is how long migrator waits for other migration to finish.
+	LockTimeout time.Duration
+}
+
+// New returns migrator applying migrations loaded from fsys to MySQL db.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	return newMigrator(mysqlDialect, db, fsys)
+}
+
+// NewPostgres returns migrator applying migrations loaded from fsys to
+// Postgres db.
+func NewPostgres(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	return newMigrator(postgresDialect, db, fsys)
+}
+
+// NewSQLite returns migrator applying migrations loaded from fsys to SQLite
+// db.
+func NewSQLite(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	return newMigrator(sqliteDialect, db, fsys)
+}
+
+func newMigrator(d *dialect, db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:          db,
+		dialect:     d,
+		migrations:  migrations,
+		LockTimeout: time.Minute,
+	}, nil
+}
+
+// Latest returns version of the newest known migration.
+func (m *Migrator) Latest() uint64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies n pending migrations, all of them if n <= 0.
+func (m *Migrator) Up(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		pending := m.pending(current)
+		if n > 0 && n < len(pending) {
+			pending = pending[:n]
+		}
+		for _, mig := range pending {
+			log.WithField("version", mig.Version).Infof("Applying migration %s", mig.Name)
+			if err := m.run(ctx, conn, mig.Version, mig.Up); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back n applied migrations, all of them if n <= 0.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		applied := m.applied(current)
+		for i := len(applied) - 1; i >= 0; i-- {
+			if n > 0 && len(applied)-i > n {
+				break
+			}
+			mig := applied[i]
+			var target uint64
+			if i > 0 {
+				target = applied[i-1].Version
+			}
+			log.WithField("version", mig.Version).Infof("Rolling back migration %s", mig.Name)
+			if err := m.run(ctx, conn, target, mig.Down); err != nil {
+				return fmt.Errorf("failed to roll back migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Force sets version without running any migration and clears dirty flag.
+// It is used to recover after failed migration was fixed manually.
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return m.setVersion(ctx, conn, version, false)
+	})
+}
+
+// Status returns current state of database schema.
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	if _, err := m.db.ExecContext(ctx, createVersionTableQuery); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	current, dirty, err := getVersion(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{
+		Current: current,
+		Dirty:   dirty,
+		Latest:  m.Latest(),
+		Pending: m.pending(current),
+	}, nil
+}
+
+// CheckSchema returns error if database is dirty or has pending migrations.
+// Newer schema is accepted, so previous version of service can still run
+// while next one is rolled out.
+func (m *Migrator) CheckSchema(ctx context.Context) error {
+	st, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if st.Dirty {
+		return fmt.Errorf("version %d: %w", st.Current, ErrDirty)
+	}
+	if st.Current < st.Latest {
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaBehind, st.Current, st.Latest)
+	}
+	return nil
+}
+
+func (m *Migrator) pending(current uint64) []Migration {
+	for i, mig := range m.migrations {
+		if mig.Version > current {
+			return m.migrations[i:]
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applied(current uint64) []Migration {
+	for i, mig := range m.migrations {
+		if mig.Version > current {
+			return m.migrations[:i]
+		}
+	}
+	return m.migrations
+}
+
+func (m *Migrator) find(version uint64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// cleanVersion returns current version or ErrDirty.
+func (m *Migrator) cleanVersion(ctx context.Context, conn *sql.Conn) (uint64, error) {
+	current, dirty, err := getVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("version %d: %w", current, ErrDirty)
+	}
+	if current != 0 && m.find(current) == nil {
+		return 0, fmt.Errorf("applied version %d is unknown", current)
+	}
+	return current, nil
+}
+
+// run executes script and moves schema to target version. Schema stays dirty
+// if any of statements fails, as MySQL cannot roll back DDL statements.
+// Statements are not run in transaction in Postgres either, so both
+// databases behave the same.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, target uint64, script string) error {
+	if err := m.setVersion(ctx, conn, target, true); err != nil {
+		return err
+	}
+	for _, stmt := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return m.setVersion(ctx, conn, target, false)
+}
+
+// withLock runs fn on single connection holding migration lock.
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+	if err := m.dialect.lock(ctx, conn, m.LockTimeout); err != nil {
+		return err
+	}
+	if m.dialect.releaseLockQuery != "" {
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), m.dialect.releaseLockQuery); err != nil {
+				log.WithError(err).Error("Failed to release migration lock")
+			}
+		}()
+	}
+	if _, err := conn.ExecContext(ctx, createVersionTableQuery); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return fn(conn)
+}
+
+func mysqlLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	var ok sql.NullInt64
+	if err := conn.QueryRowContext(ctx, mysqlGetLockQuery, int(timeout.Seconds())).Scan(&ok); err != nil {
+		return fmt.Errorf("failed to get migration lock: %w", err)
+	}
+	if ok.Int64 != 1 {
+		return ErrLockTimeout
+	}
+	return nil
+}
+
+// postgresLock polls for advisory lock, as pg_advisory_lock would wait for
+// it without timeout.
+func postgresLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var ok bool
+		if err := conn.QueryRowContext(ctx, postgresTryLockQuery).Scan(&ok); err != nil {
+			return fmt.Errorf("failed to get migration lock: %w", err)
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().Add(postgresLockRetryPeriod).After(deadline) {
+			return ErrLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(postgresLockRetryPeriod):
+		}
+	}
+}
+
+// noLock does not lock migrations, for databases used by single process.
+func noLock(context.Context, *sql.Conn, time.Duration) error {
+	return nil
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getVersion(ctx context.Context, q queryer) (uint64, bool, error) {
+	var (
+		version int64
+		dirty   bool
+	)
+	err := q.QueryRowContext(ctx, getVersionQuery).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	if version == nilVersion {
+		return 0, dirty, nil
+	}
+	return uint64(version), dirty, nil
+}
+
+func (m *Migrator) setVersion(ctx context.Context, conn *sql.Conn, version uint64, dirty bool) error {
+	if _, err := conn.ExecContext(ctx, m.dialect.truncateVersionQuery); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	// Same as golang-migrate, empty schema has no row unless it is dirty.
+	if version == 0 && !dirty {
+		return nil
+	}
+	v := int64(version)
+	if version == 0 {
+		v = nilVersion
+	}
+	if _, err := conn.ExecContext(ctx, m.dialect.insertVersionQuery, v, dirty); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	return nil
+}
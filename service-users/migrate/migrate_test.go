@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/store/migrations/sqlite"
+)
+
+func TestMigratorSQLite(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	// Every connection opens its own in-memory database.
+	db.SetMaxOpenConns(1)
+	m, err := NewSQLite(db, sqlite.FS)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := m.CheckSchema(ctx); !errors.Is(err, ErrSchemaBehind) {
+		t.Errorf("Expected ErrSchemaBehind, got %v", err)
+	}
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if err := m.CheckSchema(ctx); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `SELECT id FROM users`); err != nil {
+		t.Errorf("Expected users table: %v", err)
+	}
+	if err := m.Down(ctx, 0); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	st, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if st.Current != 0 || st.Dirty {
+		t.Errorf("Expected clean empty schema, got version %d, dirty %v", st.Current, st.Dirty)
+	}
+	if _, err := db.ExecContext(ctx, `SELECT id FROM users`); err == nil {
+		t.Error("Expected users table to be dropped")
+	}
+}
@@ -0,0 +1,131 @@
+package migrate
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/store/migrations"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store/migrations/postgres"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store/migrations/sqlite"
+)
+
+func TestLoad(t *testing.T) {
+	tcs := []struct {
+		name   string
+		files  fstest.MapFS
+		exp    []Migration
+		expErr string
+	}{
+		{
+			name: "sorted by version, other files ignored",
+			files: fstest.MapFS{
+				"2_second.up.sql":   {Data: []byte("up 2")},
+				"2_second.down.sql": {Data: []byte("down 2")},
+				"1_first.up.sql":    {Data: []byte("up 1")},
+				"1_first.down.sql":  {Data: []byte("down 1")},
+				"migrations.go":     {Data: []byte("package migrations")},
+			},
+			exp: []Migration{
+				{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+				{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+			},
+		},
+		{
+			name: "missing down file",
+			files: fstest.MapFS{
+				"1_first.up.sql": {Data: []byte("up 1")},
+			},
+			expErr: "migration 1_first must have both up and down file",
+		},
+		{
+			name: "version used twice",
+			files: fstest.MapFS{
+				"1_first.up.sql":   {Data: []byte("up 1")},
+				"1_other.down.sql": {Data: []byte("down 1")},
+			},
+			expErr: "version 1 used by first and other",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Load(tc.files)
+			assertErrString(t, tc.expErr, err)
+			if diff := cmp.Diff(tc.exp, got); diff != "" {
+				t.Errorf("Load() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	for name, fsys := range map[string]fs.FS{"mysql": migrations.FS, "postgres": postgres.FS, "sqlite": sqlite.FS} {
+		t.Run(name, func(t *testing.T) {
+			got, err := Load(fsys)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(got) == 0 {
+				t.Fatal("Expected embedded migrations")
+			}
+			for _, mig := range got {
+				if len(splitStatements(mig.Up)) == 0 || len(splitStatements(mig.Down)) == 0 {
+					t.Errorf("Migration %d_%s has no statements", mig.Version, mig.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tcs := []struct {
+		name   string
+		script string
+		exp    []string
+	}{
+		{
+			name:   "multiple statements",
+			script: "CREATE TABLE a (id int);\nALTER TABLE a ADD b int;\n",
+			exp:    []string{"CREATE TABLE a (id int)", "ALTER TABLE a ADD b int"},
+		},
+		{
+			name:   "last statement without semicolon",
+			script: "DROP TABLE a;DROP TABLE b",
+			exp:    []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "semicolons in quotes",
+			script: "INSERT INTO a VALUES ('x;y', \"z;\");UPDATE `a;b` SET c = 1;",
+			exp:    []string{"INSERT INTO a VALUES ('x;y', \"z;\")", "UPDATE `a;b` SET c = 1"},
+		},
+		{
+			name:   "comments",
+			script: "-- drop it; now\nDROP TABLE a; -- trailing;\n-- only comment\n",
+			exp:    []string{"DROP TABLE a"},
+		},
+		{
+			name:   "empty",
+			script: " ;\n",
+			exp:    nil,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.exp, splitStatements(tc.script)); diff != "" {
+				t.Errorf("splitStatements() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func assertErrString(t *testing.T, exp string, err error) {
+	t.Helper()
+	if exp == "" && err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp != "" && (err == nil || err.Error() != exp) {
+		t.Fatalf("Expected error %q, got: %v", exp, err)
+	}
+}
@@ -0,0 +1,47 @@
+package users_test
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	_ "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/protocompat"
+)
+
+const baselinePath = "testdata/baseline.pb"
+
+var update = flag.Bool("update", false, "overwrite baseline descriptor set with current API")
+
+// TestCompatibility makes sure that API defined in this package is backward
+// compatible with committed baseline.
+// After intentional change baseline can be updated with:
+// go test ./proto -run TestCompatibility -update
+func TestCompatibility(t *testing.T) {
+	var current []protoreflect.FileDescriptor
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		if strings.HasPrefix(fd.Path(), "proto/") {
+			current = append(current, fd)
+		}
+		return true
+	})
+	sort.Slice(current, func(i, j int) bool { return current[i].Path() < current[j].Path() })
+
+	if *update {
+		if err := protocompat.WriteDescriptorSet(baselinePath, current); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	baseline, err := protocompat.ReadDescriptorSet(baselinePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range protocompat.Check(baseline, current) {
+		t.Error(v)
+	}
+}
@@ -25,9 +25,41 @@ type UsersClient interface {
 	// Get user returns user by id.
 	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
 	// Deletes an user.
+	// User is soft deleted, it can be restored with UndeleteUser until
+	// it is purged after retention period.
 	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Restores soft deleted user.
+	UndeleteUser(ctx context.Context, in *UndeleteUserRequest, opts ...grpc.CallOption) (*User, error)
 	// List users.
 	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	// List history of changes of user, newest first.
+	// Allowed only for admins.
+	ListUserHistory(ctx context.Context, in *ListUserHistoryRequest, opts ...grpc.CallOption) (*ListUserHistoryResponse, error)
+	// Get multiple users by ids.
+	BatchGetUsers(ctx context.Context, in *BatchGetUsersRequest, opts ...grpc.CallOption) (*BatchGetUsersResponse, error)
+	// Create multiple users.
+	// Every item has its own result, unless whole request is invalid.
+	BatchCreateUsers(ctx context.Context, in *BatchCreateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error)
+	// Update multiple users.
+	// Every item has its own result, unless whole request is invalid.
+	BatchUpdateUsers(ctx context.Context, in *BatchUpdateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error)
+	// Import users streamed by client.
+	// Users are written in batches, so rows imported before stream fails
+	// are kept.
+	ImportUsers(ctx context.Context, opts ...grpc.CallOption) (Users_ImportUsersClient, error)
+	// Export users matching filtering.
+	ExportUsers(ctx context.Context, in *ExportUsersRequest, opts ...grpc.CallOption) (Users_ExportUsersClient, error)
+	// Search users by first name, last name, nickname and email.
+	// Results are ordered by relevance.
+	SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error)
+	// Export all data stored about user, including its history and events,
+	// as JSON bundle, e.g. to fulfill data subject access request.
+	// Allowed only for admins.
+	ExportUserData(ctx context.Context, in *ExportUserDataRequest, opts ...grpc.CallOption) (*ExportUserDataResponse, error)
+	// Irreversibly anonymize user along with its history and publish
+	// UserErased event. Erased user is deleted and cannot be restored.
+	// Allowed only for admins.
+	EraseUser(ctx context.Context, in *EraseUserRequest, opts ...grpc.CallOption) (*ErasureReceipt, error)
 }
 
 type usersClient struct {
@@ -74,6 +106,15 @@ func (c *usersClient) DeleteUser(ctx context.Context, in *DeleteUserRequest, opt
 	return out, nil
 }
 
+func (c *usersClient) UndeleteUser(ctx context.Context, in *UndeleteUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/Users/UndeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *usersClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
 	out := new(ListUsersResponse)
 	err := c.cc.Invoke(ctx, "/Users/ListUsers", in, out, opts...)
@@ -83,6 +124,135 @@ func (c *usersClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts
 	return out, nil
 }
 
+func (c *usersClient) ListUserHistory(ctx context.Context, in *ListUserHistoryRequest, opts ...grpc.CallOption) (*ListUserHistoryResponse, error) {
+	out := new(ListUserHistoryResponse)
+	err := c.cc.Invoke(ctx, "/Users/ListUserHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) BatchGetUsers(ctx context.Context, in *BatchGetUsersRequest, opts ...grpc.CallOption) (*BatchGetUsersResponse, error) {
+	out := new(BatchGetUsersResponse)
+	err := c.cc.Invoke(ctx, "/Users/BatchGetUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) BatchCreateUsers(ctx context.Context, in *BatchCreateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error) {
+	out := new(BatchUsersResponse)
+	err := c.cc.Invoke(ctx, "/Users/BatchCreateUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) BatchUpdateUsers(ctx context.Context, in *BatchUpdateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error) {
+	out := new(BatchUsersResponse)
+	err := c.cc.Invoke(ctx, "/Users/BatchUpdateUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) ImportUsers(ctx context.Context, opts ...grpc.CallOption) (Users_ImportUsersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Users_serviceDesc.Streams[0], "/Users/ImportUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &usersImportUsersClient{stream}
+	return x, nil
+}
+
+type Users_ImportUsersClient interface {
+	Send(*ImportUsersRequest) error
+	CloseAndRecv() (*ImportUsersResponse, error)
+	grpc.ClientStream
+}
+
+type usersImportUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *usersImportUsersClient) Send(m *ImportUsersRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *usersImportUsersClient) CloseAndRecv() (*ImportUsersResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportUsersResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *usersClient) ExportUsers(ctx context.Context, in *ExportUsersRequest, opts ...grpc.CallOption) (Users_ExportUsersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Users_serviceDesc.Streams[1], "/Users/ExportUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &usersExportUsersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Users_ExportUsersClient interface {
+	Recv() (*User, error)
+	grpc.ClientStream
+}
+
+type usersExportUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *usersExportUsersClient) Recv() (*User, error) {
+	m := new(User)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *usersClient) SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error) {
+	out := new(SearchUsersResponse)
+	err := c.cc.Invoke(ctx, "/Users/SearchUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) ExportUserData(ctx context.Context, in *ExportUserDataRequest, opts ...grpc.CallOption) (*ExportUserDataResponse, error) {
+	out := new(ExportUserDataResponse)
+	err := c.cc.Invoke(ctx, "/Users/ExportUserData", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) EraseUser(ctx context.Context, in *EraseUserRequest, opts ...grpc.CallOption) (*ErasureReceipt, error) {
+	out := new(ErasureReceipt)
+	err := c.cc.Invoke(ctx, "/Users/EraseUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UsersServer is the server API for Users service.
 // All implementations must embed UnimplementedUsersServer
 // for forward compatibility
@@ -94,9 +264,41 @@ type UsersServer interface {
 	// Get user returns user by id.
 	GetUser(context.Context, *GetUserRequest) (*User, error)
 	// Deletes an user.
+	// User is soft deleted, it can be restored with UndeleteUser until
+	// it is purged after retention period.
 	DeleteUser(context.Context, *DeleteUserRequest) (*empty.Empty, error)
+	// Restores soft deleted user.
+	UndeleteUser(context.Context, *UndeleteUserRequest) (*User, error)
 	// List users.
 	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	// List history of changes of user, newest first.
+	// Allowed only for admins.
+	ListUserHistory(context.Context, *ListUserHistoryRequest) (*ListUserHistoryResponse, error)
+	// Get multiple users by ids.
+	BatchGetUsers(context.Context, *BatchGetUsersRequest) (*BatchGetUsersResponse, error)
+	// Create multiple users.
+	// Every item has its own result, unless whole request is invalid.
+	BatchCreateUsers(context.Context, *BatchCreateUsersRequest) (*BatchUsersResponse, error)
+	// Update multiple users.
+	// Every item has its own result, unless whole request is invalid.
+	BatchUpdateUsers(context.Context, *BatchUpdateUsersRequest) (*BatchUsersResponse, error)
+	// Import users streamed by client.
+	// Users are written in batches, so rows imported before stream fails
+	// are kept.
+	ImportUsers(Users_ImportUsersServer) error
+	// Export users matching filtering.
+	ExportUsers(*ExportUsersRequest, Users_ExportUsersServer) error
+	// Search users by first name, last name, nickname and email.
+	// Results are ordered by relevance.
+	SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error)
+	// Export all data stored about user, including its history and events,
+	// as JSON bundle, e.g. to fulfill data subject access request.
+	// Allowed only for admins.
+	ExportUserData(context.Context, *ExportUserDataRequest) (*ExportUserDataResponse, error)
+	// Irreversibly anonymize user along with its history and publish
+	// UserErased event. Erased user is deleted and cannot be restored.
+	// Allowed only for admins.
+	EraseUser(context.Context, *EraseUserRequest) (*ErasureReceipt, error)
 	mustEmbedUnimplementedUsersServer()
 }
 
@@ -116,9 +318,39 @@ func (UnimplementedUsersServer) GetUser(context.Context, *GetUserRequest) (*User
 func (UnimplementedUsersServer) DeleteUser(context.Context, *DeleteUserRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
 }
+func (UnimplementedUsersServer) UndeleteUser(context.Context, *UndeleteUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UndeleteUser not implemented")
+}
 func (UnimplementedUsersServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
 }
+func (UnimplementedUsersServer) ListUserHistory(context.Context, *ListUserHistoryRequest) (*ListUserHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserHistory not implemented")
+}
+func (UnimplementedUsersServer) BatchGetUsers(context.Context, *BatchGetUsersRequest) (*BatchGetUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetUsers not implemented")
+}
+func (UnimplementedUsersServer) BatchCreateUsers(context.Context, *BatchCreateUsersRequest) (*BatchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCreateUsers not implemented")
+}
+func (UnimplementedUsersServer) BatchUpdateUsers(context.Context, *BatchUpdateUsersRequest) (*BatchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchUpdateUsers not implemented")
+}
+func (UnimplementedUsersServer) ImportUsers(Users_ImportUsersServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportUsers not implemented")
+}
+func (UnimplementedUsersServer) ExportUsers(*ExportUsersRequest, Users_ExportUsersServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportUsers not implemented")
+}
+func (UnimplementedUsersServer) SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchUsers not implemented")
+}
+func (UnimplementedUsersServer) ExportUserData(context.Context, *ExportUserDataRequest) (*ExportUserDataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportUserData not implemented")
+}
+func (UnimplementedUsersServer) EraseUser(context.Context, *EraseUserRequest) (*ErasureReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EraseUser not implemented")
+}
 func (UnimplementedUsersServer) mustEmbedUnimplementedUsersServer() {}
 
 // UnsafeUsersServer may be embedded to opt out of forward compatibility for this service.
@@ -204,6 +436,24 @@ func _Users_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Users_UndeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UndeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).UndeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/UndeleteUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).UndeleteUser(ctx, req.(*UndeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Users_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ListUsersRequest)
 	if err := dec(in); err != nil {
@@ -222,6 +472,179 @@ func _Users_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Users_ListUserHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUserHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ListUserHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/ListUserHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ListUserHistory(ctx, req.(*ListUserHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_BatchGetUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).BatchGetUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/BatchGetUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).BatchGetUsers(ctx, req.(*BatchGetUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_BatchCreateUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCreateUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).BatchCreateUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/BatchCreateUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).BatchCreateUsers(ctx, req.(*BatchCreateUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_BatchUpdateUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchUpdateUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).BatchUpdateUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/BatchUpdateUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).BatchUpdateUsers(ctx, req.(*BatchUpdateUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_ImportUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(UsersServer).ImportUsers(&usersImportUsersServer{stream})
+}
+
+type Users_ImportUsersServer interface {
+	SendAndClose(*ImportUsersResponse) error
+	Recv() (*ImportUsersRequest, error)
+	grpc.ServerStream
+}
+
+type usersImportUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *usersImportUsersServer) SendAndClose(m *ImportUsersResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *usersImportUsersServer) Recv() (*ImportUsersRequest, error) {
+	m := new(ImportUsersRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Users_ExportUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportUsersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UsersServer).ExportUsers(m, &usersExportUsersServer{stream})
+}
+
+type Users_ExportUsersServer interface {
+	Send(*User) error
+	grpc.ServerStream
+}
+
+type usersExportUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *usersExportUsersServer) Send(m *User) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Users_SearchUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).SearchUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/SearchUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).SearchUsers(ctx, req.(*SearchUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_ExportUserData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportUserDataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ExportUserData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/ExportUserData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ExportUserData(ctx, req.(*ExportUserDataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_EraseUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EraseUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).EraseUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Users/EraseUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).EraseUser(ctx, req.(*EraseUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Users_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Users",
 	HandlerType: (*UsersServer)(nil),
@@ -242,11 +665,54 @@ var _Users_serviceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteUser",
 			Handler:    _Users_DeleteUser_Handler,
 		},
+		{
+			MethodName: "UndeleteUser",
+			Handler:    _Users_UndeleteUser_Handler,
+		},
 		{
 			MethodName: "ListUsers",
 			Handler:    _Users_ListUsers_Handler,
 		},
+		{
+			MethodName: "ListUserHistory",
+			Handler:    _Users_ListUserHistory_Handler,
+		},
+		{
+			MethodName: "BatchGetUsers",
+			Handler:    _Users_BatchGetUsers_Handler,
+		},
+		{
+			MethodName: "BatchCreateUsers",
+			Handler:    _Users_BatchCreateUsers_Handler,
+		},
+		{
+			MethodName: "BatchUpdateUsers",
+			Handler:    _Users_BatchUpdateUsers_Handler,
+		},
+		{
+			MethodName: "SearchUsers",
+			Handler:    _Users_SearchUsers_Handler,
+		},
+		{
+			MethodName: "ExportUserData",
+			Handler:    _Users_ExportUserData_Handler,
+		},
+		{
+			MethodName: "EraseUser",
+			Handler:    _Users_EraseUser_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ImportUsers",
+			Handler:       _Users_ImportUsers_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ExportUsers",
+			Handler:       _Users_ExportUsers_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/users.proto",
 }
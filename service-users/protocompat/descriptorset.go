@@ -0,0 +1,73 @@
+package protocompat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const wellKnownPrefix = "google/protobuf/"
+
+// ReadDescriptorSet reads files from binary encoded FileDescriptorSet.
+// Well known types are used only to resolve dependencies and are not returned.
+func ReadDescriptorSet(path string) ([]protoreflect.FileDescriptor, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve descriptor set: %w", err)
+	}
+	var out []protoreflect.FileDescriptor
+	for _, fdp := range set.GetFile() {
+		if strings.HasPrefix(fdp.GetName(), wellKnownPrefix) {
+			continue
+		}
+		fd, err := files.FindFileByPath(fdp.GetName())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, fd)
+	}
+	return out, nil
+}
+
+// WriteDescriptorSet writes files along with all their dependencies
+// as binary encoded FileDescriptorSet. Source info is stripped, so comment
+// changes do not change the output.
+func WriteDescriptorSet(path string, files []protoreflect.FileDescriptor) error {
+	var set descriptorpb.FileDescriptorSet
+	seen := map[string]bool{}
+	var add func(protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		fdp := protodesc.ToFileDescriptorProto(fd)
+		fdp.SourceCodeInfo = nil
+		set.File = append(set.File, fdp)
+	}
+	for _, fd := range files {
+		add(fd)
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(&set)
+	if err != nil {
+		return fmt.Errorf("failed to marshal descriptor set: %w", err)
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
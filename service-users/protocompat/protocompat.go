@@ -0,0 +1,270 @@
+// Package protocompat detects breaking changes between two versions of
+// protobuf API.
+//
+// Change is wire-breaking when data encoded in binary format by one version
+// cannot be correctly decoded by the other one, e.g. field number was changed.
+// Change is JSON-breaking when the same applies to protojson encoding,
+// which identifies fields and enum values by names, e.g. field was renamed.
+package protocompat
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Kind of breaking change.
+type Kind string
+
+const (
+	Wire Kind = "wire"
+	JSON Kind = "json"
+)
+
+// Violation describes single breaking change.
+type Violation struct {
+	Kind    Kind
+	Element protoreflect.FullName
+	Reason  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s-breaking change in %s: %s", v.Kind, v.Element, v.Reason)
+}
+
+// Check compares current files against baseline and returns all breaking
+// changes, sorted by element name.
+// Elements added in current version are always compatible.
+func Check(baseline, current []protoreflect.FileDescriptor) []Violation {
+	c := checker{current: index(current)}
+	for _, fd := range baseline {
+		c.checkMessages(fd.Messages())
+		c.checkEnums(fd.Enums())
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			c.checkService(services.Get(i))
+		}
+	}
+	sort.SliceStable(c.violations, func(i, j int) bool {
+		return c.violations[i].Element < c.violations[j].Element
+	})
+	return c.violations
+}
+
+type checker struct {
+	current    map[protoreflect.FullName]protoreflect.Descriptor
+	violations []Violation
+}
+
+func (c *checker) add(kind Kind, el protoreflect.FullName, format string, args ...interface{}) {
+	c.violations = append(c.violations, Violation{
+		Kind:    kind,
+		Element: el,
+		Reason:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) checkMessages(msgs protoreflect.MessageDescriptors) {
+	for i := 0; i < msgs.Len(); i++ {
+		old := msgs.Get(i)
+		cur, ok := c.current[old.FullName()].(protoreflect.MessageDescriptor)
+		if !ok {
+			c.add(Wire, old.FullName(), "message removed")
+			continue
+		}
+		c.checkFields(old, cur)
+		c.checkMessages(old.Messages())
+		c.checkEnums(old.Enums())
+	}
+}
+
+func (c *checker) checkFields(old, cur protoreflect.MessageDescriptor) {
+	fields := old.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		of := fields.Get(i)
+		cf := cur.Fields().ByNumber(of.Number())
+		if cf == nil {
+			if moved := cur.Fields().ByName(of.Name()); moved != nil {
+				c.add(Wire, of.FullName(), "field number changed from %d to %d", of.Number(), moved.Number())
+				continue
+			}
+			if !cur.ReservedRanges().Has(of.Number()) {
+				c.add(Wire, of.FullName(), "field removed without reserving its number %d", of.Number())
+			}
+			c.add(JSON, of.FullName(), "field removed")
+			continue
+		}
+		if cf.JSONName() != of.JSONName() || cf.Name() != of.Name() {
+			c.add(JSON, of.FullName(), "field %d renamed to %q", of.Number(), cf.Name())
+		}
+		if cf.Cardinality() != of.Cardinality() || cf.IsMap() != of.IsMap() {
+			c.add(Wire, of.FullName(), "field cardinality changed from %s to %s", label(of), label(cf))
+			continue
+		}
+		if !wireCompatible(of, cf) {
+			c.add(Wire, of.FullName(), "field type changed from %s to %s", typeName(of), typeName(cf))
+		} else if !jsonCompatible(of, cf) {
+			c.add(JSON, of.FullName(), "field type changed from %s to %s", typeName(of), typeName(cf))
+		}
+		if of.ContainingOneof() != nil && cf.ContainingOneof() == nil {
+			c.add(Wire, of.FullName(), "field moved out of oneof %s", of.ContainingOneof().Name())
+		}
+	}
+}
+
+func (c *checker) checkEnums(enums protoreflect.EnumDescriptors) {
+	for i := 0; i < enums.Len(); i++ {
+		old := enums.Get(i)
+		cur, ok := c.current[old.FullName()].(protoreflect.EnumDescriptor)
+		if !ok {
+			c.add(Wire, old.FullName(), "enum removed")
+			continue
+		}
+		values := old.Values()
+		for j := 0; j < values.Len(); j++ {
+			ov := values.Get(j)
+			cv := cur.Values().ByNumber(ov.Number())
+			if cv == nil {
+				if !cur.ReservedRanges().Has(ov.Number()) {
+					c.add(Wire, ov.FullName(), "enum value removed without reserving its number %d", ov.Number())
+				}
+				c.add(JSON, ov.FullName(), "enum value removed")
+				continue
+			}
+			if cv.Name() != ov.Name() {
+				c.add(JSON, ov.FullName(), "enum value %d renamed to %q", ov.Number(), cv.Name())
+			}
+		}
+	}
+}
+
+func (c *checker) checkService(old protoreflect.ServiceDescriptor) {
+	cur, ok := c.current[old.FullName()].(protoreflect.ServiceDescriptor)
+	if !ok {
+		c.add(Wire, old.FullName(), "service removed")
+		return
+	}
+	methods := old.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		om := methods.Get(i)
+		cm := cur.Methods().ByName(om.Name())
+		if cm == nil {
+			c.add(Wire, om.FullName(), "method removed")
+			continue
+		}
+		if cm.Input().FullName() != om.Input().FullName() {
+			c.add(Wire, om.FullName(), "request type changed from %s to %s", om.Input().FullName(), cm.Input().FullName())
+		}
+		if cm.Output().FullName() != om.Output().FullName() {
+			c.add(Wire, om.FullName(), "response type changed from %s to %s", om.Output().FullName(), cm.Output().FullName())
+		}
+		if cm.IsStreamingClient() != om.IsStreamingClient() || cm.IsStreamingServer() != om.IsStreamingServer() {
+			c.add(Wire, om.FullName(), "streaming mode changed")
+		}
+	}
+}
+
+// wireGroups contains groups of kinds which can be decoded one as another
+// in binary format.
+var wireGroups = [][]protoreflect.Kind{
+	{protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.BoolKind, protoreflect.EnumKind},
+	{protoreflect.Sint32Kind, protoreflect.Sint64Kind},
+	{protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind},
+	{protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind},
+	{protoreflect.StringKind, protoreflect.BytesKind},
+}
+
+// jsonGroups contains groups of kinds which share protojson representation.
+var jsonGroups = [][]protoreflect.Kind{
+	{protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.Uint32Kind, protoreflect.Fixed32Kind},
+	{protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind},
+}
+
+func wireCompatible(old, cur protoreflect.FieldDescriptor) bool {
+	if old.Kind() == cur.Kind() {
+		return sameType(old, cur)
+	}
+	return inSameGroup(wireGroups, old.Kind(), cur.Kind())
+}
+
+func jsonCompatible(old, cur protoreflect.FieldDescriptor) bool {
+	if old.Kind() == cur.Kind() {
+		return sameType(old, cur)
+	}
+	return inSameGroup(jsonGroups, old.Kind(), cur.Kind())
+}
+
+// sameType compares referenced types of message and enum fields.
+func sameType(old, cur protoreflect.FieldDescriptor) bool {
+	switch old.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return old.Message().FullName() == cur.Message().FullName()
+	case protoreflect.EnumKind:
+		return old.Enum().FullName() == cur.Enum().FullName()
+	}
+	return true
+}
+
+func inSameGroup(groups [][]protoreflect.Kind, a, b protoreflect.Kind) bool {
+	for _, g := range groups {
+		if hasKind(g, a) && hasKind(g, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasKind(kinds []protoreflect.Kind, k protoreflect.Kind) bool {
+	for _, kind := range kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+func typeName(fd protoreflect.FieldDescriptor) string {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(fd.Message().FullName())
+	case protoreflect.EnumKind:
+		return string(fd.Enum().FullName())
+	}
+	return fd.Kind().String()
+}
+
+func label(fd protoreflect.FieldDescriptor) string {
+	if fd.IsMap() {
+		return "map"
+	}
+	return fd.Cardinality().String()
+}
+
+// index returns all messages, enums and services declared in files
+// by their full names.
+func index(files []protoreflect.FileDescriptor) map[protoreflect.FullName]protoreflect.Descriptor {
+	out := map[protoreflect.FullName]protoreflect.Descriptor{}
+	var addMessages func(protoreflect.MessageDescriptors)
+	addEnums := func(enums protoreflect.EnumDescriptors) {
+		for i := 0; i < enums.Len(); i++ {
+			out[enums.Get(i).FullName()] = enums.Get(i)
+		}
+	}
+	addMessages = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			out[msgs.Get(i).FullName()] = msgs.Get(i)
+			addMessages(msgs.Get(i).Messages())
+			addEnums(msgs.Get(i).Enums())
+		}
+	}
+	for _, fd := range files {
+		addMessages(fd.Messages())
+		addEnums(fd.Enums())
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			out[services.Get(i).FullName()] = services.Get(i)
+		}
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package protocompat
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestCheck(t *testing.T) {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+	}
+	baseline := func() *descriptorpb.FileDescriptorProto {
+		return &descriptorpb.FileDescriptorProto{
+			Name:    proto.String("test.proto"),
+			Package: proto.String("test.v1"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Event"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+				},
+			}},
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name: proto.String("State"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("STATE_UNSPECIFIED"), Number: proto.Int32(0)},
+					{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+				},
+			}},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("Events"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String("Get"),
+					InputType:  proto.String(".test.v1.Event"),
+					OutputType: proto.String(".test.v1.Event"),
+				}},
+			}},
+		}
+	}
+	testCases := []struct {
+		desc   string
+		change func(*descriptorpb.FileDescriptorProto)
+		exp    []string
+	}{
+		{
+			desc:   "no changes",
+			change: func(*descriptorpb.FileDescriptorProto) {},
+		},
+		{
+			desc: "new field and enum value are compatible",
+			change: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field = append(f.MessageType[0].Field, field("name", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING))
+				f.EnumType[0].Value = append(f.EnumType[0].Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String("DELETED"), Number: proto.Int32(2)})
+			},
+		},
+		{
+			desc: "field renumbered",
+			change: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field[1].Number = proto.Int32(3)
+			},
+			exp: []string{"wire-breaking change in test.v1.Event.count: field number changed from 2 to 3"},
+		},
+		{
+			desc: "field renamed",
+			change: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field[1] = field("total", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32)
+			},
+			exp: []string{`json-breaking change in test.v1.Event.count: field 2 renamed to "total"`},
+		},
+		{
+			desc: "field removed with and without reservation",
+			change: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field = nil
+				f.MessageType[0].ReservedRange = []*descriptorpb.DescriptorProto_ReservedRange{{Start: proto.Int32(1), End: proto.Int32(2)}}
+			},
+			exp: []string{
+				"wire-breaking change in test.v1.Event.count: field removed without reserving its number 2",
+				"json-breaking change in test.v1.Event.count: field removed",
+				"json-breaking change in test.v1.Event.id: field removed",
+			},
+		},
+		{
+			desc: "field type changed",
+			change: func(f *descriptorpb.FileDescriptorProto) {
+				f.MessageType[0].Field[0].Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum()
+				f.MessageType[0].Field[1].Type = descriptorpb.FieldDescriptorProto_TYPE_FIXED32.Enum()
+			},
+			exp: []string{
+				"wire-breaking change in test.v1.Event.count: field type changed from int32 to fixed32",
+				"json-breaking change in test.v1.Event.id: field type changed from string to bytes",
+			},
+		},
+		{
+			desc: "enum value renamed",
+			change: func(f *descriptorpb.FileDescriptorProto) {
+				f.EnumType[0].Value[1].Name = proto.String("ENABLED")
+			},
+			exp: []string{`json-breaking change in test.v1.ACTIVE: enum value 1 renamed to "ENABLED"`},
+		},
+		{
+			desc: "method and enum removed",
+			change: func(f *descriptorpb.FileDescriptorProto) {
+				f.Service[0].Method = nil
+				f.EnumType = nil
+			},
+			exp: []string{
+				"wire-breaking change in test.v1.Events.Get: method removed",
+				"wire-breaking change in test.v1.State: enum removed",
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			cur := baseline()
+			tC.change(cur)
+			var got []string
+			for _, v := range Check([]protoreflect.FileDescriptor{mustNewFile(t, baseline())}, []protoreflect.FileDescriptor{mustNewFile(t, cur)}) {
+				got = append(got, v.String())
+			}
+			if diff := cmp.Diff(tC.exp, got); diff != "" {
+				t.Errorf("Violations mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func mustNewFile(t *testing.T, fdp *descriptorpb.FileDescriptorProto) protoreflect.FileDescriptor {
+	t.Helper()
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatalf("Invalid descriptor: %v", err)
+	}
+	return fd
+}
@@ -9,8 +9,9 @@ import (
 )
 
 type pubsubmock struct {
-	mu     sync.Mutex
-	events []proto.Message
+	mu          sync.Mutex
+	events      []proto.Message
+	subscribers []func(context.Context, proto.Message)
 }
 
 // New retruns pubsubmock implementation.
@@ -25,5 +26,16 @@ func (p *pubsubmock) Publish(_ context.Context, in proto.Message) error {
 	defer p.mu.Unlock()
 	p.events = append(p.events, in)
 	log.WithField("msg", in).Infof("Received event: %T", in)
+	for _, fn := range p.subscribers {
+		go fn(context.Background(), in)
+	}
 	return nil
 }
+
+// Subscribe registers fn which is asynchronously called with every
+// published event.
+func (p *pubsubmock) Subscribe(fn func(context.Context, proto.Message)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscribers = append(p.subscribers, fn)
+}
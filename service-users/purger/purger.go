@@ -0,0 +1,94 @@
+package purger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+type storer interface {
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]*store.User, error)
+}
+
+type eventsPublisher interface {
+	Publish(context.Context, proto.Message) error
+}
+
+// Config configures purging of soft deleted users.
+type Config struct {
+	// Interval is how often expired users are purged.
+	Interval time.Duration
+	// Retention is how long soft deleted users are kept before they are purged.
+	Retention time.Duration
+	// BatchSize is max number of users purged in single transaction.
+	BatchSize int
+}
+
+// Purger permanently removes users soft deleted longer than retention period.
+type Purger struct {
+	cfg             Config
+	storer          storer
+	eventsPublisher eventsPublisher
+	now             func() time.Time
+}
+
+func New(cfg Config, storer storer, eventsPublisher eventsPublisher) *Purger {
+	return &Purger{
+		cfg:             cfg,
+		storer:          storer,
+		eventsPublisher: eventsPublisher,
+		now:             time.Now,
+	}
+}
+
+// Run purges expired users until ctx is done.
+func (p *Purger) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if n, err := p.PurgeExpired(ctx); err != nil {
+			log.WithError(err).Error("Failed to purge deleted users")
+		} else if n > 0 {
+			log.Infof("Purged %d deleted users", n)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired removes all users deleted before retention period and
+// publishes UserPurged event for each of them. It returns number of purged users.
+func (p *Purger) PurgeExpired(ctx context.Context) (int, error) {
+	deletedBefore := p.now().UTC().Add(-p.cfg.Retention)
+	total := 0
+	for {
+		users, err := p.storer.PurgeDeletedUsers(ctx, deletedBefore, p.cfg.BatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to purge users: %w", err)
+		}
+		total += len(users)
+		for _, u := range users {
+			// Users are already removed, so failed event cannot be retried later.
+			// It is only logged to not block purging of remaining users.
+			// Event is published in scope of user's tenant, e.g. to reach
+			// only its webhooks.
+			ev := &pb.UserPurged{Id: u.ID, TenantId: u.TenantID}
+			if err := p.eventsPublisher.Publish(tenant.NewContext(ctx, u.TenantID), ev); err != nil {
+				log.WithError(err).WithField("user_id", u.ID).Error("Failed to publish UserPurged event")
+			}
+		}
+		if len(users) < p.cfg.BatchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package purger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func TestPurgeExpired(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc      string
+		batches   [][]*store.User
+		storeErr  error
+		expTotal  int
+		expErr    string
+		expEvents []proto.Message
+	}{
+		{
+			desc:    "nothing to purge",
+			batches: [][]*store.User{nil},
+		},
+		{
+			desc: "purges in batches until batch is not full",
+			batches: [][]*store.User{
+				{{ID: "id-1", TenantID: "acme"}, {ID: "id-2", TenantID: "default"}},
+				{{ID: "id-3", TenantID: "acme"}},
+			},
+			expTotal: 3,
+			expEvents: []proto.Message{
+				&pb.UserPurged{Id: "id-1", TenantId: "acme"},
+				&pb.UserPurged{Id: "id-2", TenantId: "default"},
+				&pb.UserPurged{Id: "id-3", TenantId: "acme"},
+			},
+		},
+		{
+			desc:     "store err",
+			storeErr: fmt.Errorf("some err"),
+			expErr:   "failed to purge users: some err",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ms := &mockStore{batches: tC.batches, err: tC.storeErr}
+			mp := &mockPublisher{}
+			p := New(Config{Retention: 24 * time.Hour, BatchSize: 2}, ms, mp)
+			p.now = func() time.Time { return now }
+
+			total, err := p.PurgeExpired(context.Background())
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tC.expErr, gotErr); diff != "" {
+				t.Errorf("Error mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.expTotal, total); diff != "" {
+				t.Errorf("Total mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.expEvents, mp.events, cmpopts.IgnoreUnexported(pb.UserPurged{})); diff != "" {
+				t.Errorf("Published events mismatch, diff: %s", diff)
+			}
+			for _, got := range ms.deletedBefore {
+				if !got.Equal(now.Add(-24 * time.Hour)) {
+					t.Errorf("Unexpected deletedBefore: %v", got)
+				}
+			}
+		})
+	}
+}
+
+type mockStore struct {
+	batches       [][]*store.User
+	err           error
+	deletedBefore []time.Time
+}
+
+func (m *mockStore) PurgeDeletedUsers(_ context.Context, deletedBefore time.Time, _ int) ([]*store.User, error) {
+	m.deletedBefore = append(m.deletedBefore, deletedBefore)
+	if m.err != nil {
+		return nil, m.err
+	}
+	out := m.batches[0]
+	m.batches = m.batches[1:]
+	return out, nil
+}
+
+type mockPublisher struct {
+	mu     sync.Mutex
+	events []proto.Message
+}
+
+func (m *mockPublisher) Publish(_ context.Context, in proto.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, in)
+	return nil
+}
@@ -0,0 +1,241 @@
+// Package ratelimit limits rate of gRPC calls of every client with token
+// buckets configured per method.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+)
+
+// DefaultMethod is key of Limits applied to methods without their own
+// limit.
+const DefaultMethod = "*"
+
+// sweepInterval is how often buckets of idle clients are removed.
+const sweepInterval = time.Minute
+
+// Limit of single client calling single method. Client can make Burst
+// calls at once, then bucket is refilled with Rate calls per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limits are keyed by full gRPC method name, e.g. "/users.Users/CreateUser",
+// or DefaultMethod.
+type Limits map[string]Limit
+
+// ParseLimits parses comma separated list of "method=rate:burst" entries,
+// e.g. "*=50:100,/users.Users/CreateUser=5:10". Rate is number of calls per
+// second, it can be fractional.
+func ParseLimits(in string) (Limits, error) {
+	out := Limits{}
+	for _, entry := range strings.Split(in, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid rate limit entry %q, expected method=rate:burst", entry)
+		}
+		values := strings.SplitN(parts[1], ":", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid rate limit entry %q, expected method=rate:burst", entry)
+		}
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate of %s, expected positive number", parts[0])
+		}
+		burst, err := strconv.Atoi(values[1])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst of %s, expected positive integer", parts[0])
+		}
+		out[parts[0]] = Limit{Rate: rate, Burst: burst}
+	}
+	return out, nil
+}
+
+var rejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "users_rate_limited_requests_total",
+	Help: "Total number of gRPC requests rejected by rate limiter.",
+}, []string{"method"})
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// refill adds tokens accumulated since last refill.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// Limiter keeps token bucket of every client and method in memory, so
+// limits apply to every instance of service separately.
+type Limiter struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	limits    Limits
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New returns limiter enforcing given limits. Methods without limit and
+// without DefaultMethod limit are not limited.
+func New(limits Limits) *Limiter {
+	return &Limiter{
+		limits:  limits,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// SetLimits replaces limits enforced by l, e.g. on reload of config. Buckets
+// of clients are kept, so reload does not let them burst again: they are
+// refilled up to now with previous limit and their tokens are capped at new
+// burst. Buckets of methods which are no longer limited are removed.
+func (l *Limiter) SetLimits(limits Limits) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	for key, b := range l.buckets {
+		limit, ok := l.limitOf(key[:strings.Index(key, " ")])
+		if !ok {
+			delete(l.buckets, key)
+			continue
+		}
+		b.refill(now)
+		b.limit = limit
+		b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	}
+}
+
+// limitOf returns limit of method, or false if it is not limited. It must
+// be called with mu held.
+func (l *Limiter) limitOf(method string) (Limit, bool) {
+	if limit, ok := l.limits[method]; ok {
+		return limit, true
+	}
+	limit, ok := l.limits[DefaultMethod]
+	return limit, ok
+}
+
+// Allow takes token from bucket of client calling method. If there is none,
+// it returns false along with time after which call will be allowed.
+func (l *Limiter) Allow(method, client string) (bool, time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit, ok := l.limitOf(method)
+	if !ok {
+		return true, 0
+	}
+	l.sweep(now)
+	key := method + " " + client
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep removes full buckets, they are the same as new ones. It must be
+// called with mu held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// UnaryServerInterceptor rejects calls exceeding limits with
+// ResourceExhausted carrying RetryInfo. It must be chained after
+// authentication, as authenticated callers are limited by identity and
+// other ones by address.
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := l.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is streaming counterpart of UnaryServerInterceptor.
+// Stream takes single token, regardless of number of its messages.
+func StreamServerInterceptor(l *Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.check(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func (l *Limiter) check(ctx context.Context, method string) error {
+	ok, wait := l.Allow(method, clientID(ctx))
+	if ok {
+		return nil
+	}
+	rejectedTotal.WithLabelValues(method).Inc()
+	st := status.Newf(codes.ResourceExhausted, "rate limit exceeded, retry after %v", wait.Round(time.Millisecond))
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// clientID identifies caller by its identity, or by host of its address if
+// authentication is disabled.
+func clientID(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok && id != auth.Anonymous && id != auth.AnonymousAdmin {
+		return "id:" + id.Tenant + "/" + id.Subject
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	return "addr:" + host
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+)
+
+func TestParseLimits(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		in     string
+		exp    Limits
+		expErr string
+	}{
+		{
+			desc: "default and method limits",
+			in:   "*=50:100, /users.Users/CreateUser=0.5:10",
+			exp: Limits{
+				DefaultMethod:             {Rate: 50, Burst: 100},
+				"/users.Users/CreateUser": {Rate: 0.5, Burst: 10},
+			},
+		},
+		{
+			desc:   "missing burst",
+			in:     "*=50",
+			expErr: `invalid rate limit entry "*=50", expected method=rate:burst`,
+		},
+		{
+			desc:   "zero rate",
+			in:     "*=0:10",
+			expErr: "invalid rate of *, expected positive number",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := ParseLimits(tC.in)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tC.expErr, gotErr); diff != "" {
+				t.Errorf("Error mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.exp, got); diff != "" {
+				t.Errorf("Limits mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	l := New(Limits{
+		DefaultMethod: {Rate: 100, Burst: 100},
+		"/m/Create":   {Rate: 2, Burst: 2},
+	})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("/m/Create", "c-1"); !ok {
+			t.Fatalf("Expected call %d within burst to be allowed", i)
+		}
+	}
+	ok, wait := l.Allow("/m/Create", "c-1")
+	if ok {
+		t.Fatalf("Expected call over burst to be rejected")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("Expected wait 500ms, got: %v", wait)
+	}
+	if ok, _ := l.Allow("/m/Create", "c-2"); !ok {
+		t.Errorf("Expected other client to be allowed")
+	}
+	if ok, _ := l.Allow("/m/Get", "c-1"); !ok {
+		t.Errorf("Expected other method to be allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("/m/Create", "c-1"); !ok {
+		t.Errorf("Expected call after refill to be allowed")
+	}
+
+	now = now.Add(sweepInterval)
+	l.Allow("/m/Get", "c-3")
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected full buckets to be swept, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestLimiterSetLimits(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	l := New(Limits{DefaultMethod: {Rate: 1, Burst: 1}})
+	l.now = func() time.Time { return now }
+	l.Allow("/m/Create", "c-1")
+	l.Allow("/m/Get", "c-1")
+
+	// Exhausted bucket is kept, so reload does not allow burst again.
+	l.SetLimits(Limits{"/m/Create": {Rate: 1, Burst: 2}})
+	if ok, _ := l.Allow("/m/Create", "c-1"); ok {
+		t.Fatalf("Expected call to be rejected until bucket is refilled")
+	}
+	now = now.Add(2 * time.Second)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("/m/Create", "c-1"); !ok {
+			t.Fatalf("Expected call %d within new burst to be allowed", i)
+		}
+	}
+	if _, ok := l.buckets["/m/Get c-1"]; ok {
+		t.Errorf("Expected bucket of method without limit to be removed")
+	}
+	if ok, _ := l.Allow("/m/Get", "c-1"); !ok {
+		t.Errorf("Expected method without limit to be allowed after default limit was removed")
+	}
+
+	// Tokens are capped at lowered burst.
+	now = now.Add(2 * time.Second)
+	l.SetLimits(Limits{"/m/Create": {Rate: 1, Burst: 1}})
+	if ok, _ := l.Allow("/m/Create", "c-1"); !ok {
+		t.Fatalf("Expected call within lowered burst to be allowed")
+	}
+	if ok, _ := l.Allow("/m/Create", "c-1"); ok {
+		t.Errorf("Expected call over lowered burst to be rejected")
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l := New(Limits{DefaultMethod: {Rate: 1, Burst: 1}})
+	interceptor := UnaryServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: "/users.Users/CreateUser"}
+	handler := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	anonymous := auth.NewContext(peer.NewContext(context.Background(), &peer.Peer{Addr: addr}), auth.Anonymous)
+
+	if _, err := interceptor(anonymous, nil, info, handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Authenticated caller from the same address has its own bucket.
+	importer := auth.NewContext(anonymous, auth.Identity{Subject: "importer", Tenant: "acme"})
+	if _, err := interceptor(importer, nil, info, handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err := interceptor(anonymous, nil, info, handler)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted, got: %v", err)
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("Expected single detail, got: %v", st.Details())
+	}
+	retry, ok := st.Details()[0].(*errdetails.RetryInfo)
+	if !ok || retry.GetRetryDelay().AsDuration() <= 0 {
+		t.Errorf("Expected positive retry delay, got: %v", st.Details()[0])
+	}
+}
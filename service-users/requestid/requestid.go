@@ -0,0 +1,82 @@
+// Package requestid passes id of request via context, so it can be
+// correlated across logs, audit entries and downstream calls.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is gRPC metadata key carrying request id.
+const MetadataKey = "x-request-id"
+
+// maxLen is max length of request id taken from client, it must fit into
+// request_id columns of audit log and erasure receipts.
+const maxLen = 255
+
+type ctxKey struct{}
+
+// NewContext returns context carrying given request id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns request id or empty string if it is not set.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// UnaryServerInterceptor takes request id from incoming metadata or
+// generates new one, also if id sent by client is longer than 255 bytes or
+// has characters other than printable ASCII. Request id is stored in context and sent back in header.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id := fromMetadata(ctx)
+		if id == "" {
+			id = uuid.New().String()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(MetadataKey, id))
+		return handler(NewContext(ctx, id), req)
+	}
+}
+
+// StreamServerInterceptor is streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := fromMetadata(stream.Context())
+		if id == "" {
+			id = uuid.New().String()
+		}
+		stream.SetHeader(metadata.Pairs(MetadataKey, id))
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = NewContext(stream.Context(), id)
+		return handler(srv, wrapped)
+	}
+}
+
+// fromMetadata returns valid request id from incoming metadata, or empty
+// string if there is none.
+func fromMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(MetadataKey); len(values) > 0 && valid(values[0]) {
+		return values[0]
+	}
+	return ""
+}
+
+func valid(id string) bool {
+	if len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x20 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		id        string
+		expClient bool
+	}{
+		{
+			desc:      "id from client",
+			id:        "req-1",
+			expClient: true,
+		},
+		{
+			desc: "no id",
+		},
+		{
+			desc: "too long id",
+			id:   strings.Repeat("a", 256),
+		},
+		{
+			desc: "non printable id",
+			id:   "req\n1",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ctx := context.Background()
+			if tC.id != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, tC.id))
+			}
+			var got string
+			handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+				got = FromContext(ctx)
+				return nil, nil
+			}
+			if _, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tC.expClient {
+				if got != tC.id {
+					t.Errorf("Expected id %q from client, got: %q", tC.id, got)
+				}
+				return
+			}
+			if _, err := uuid.Parse(got); err != nil {
+				t.Errorf("Expected generated id, got: %q", got)
+			}
+		})
+	}
+}
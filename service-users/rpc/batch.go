@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func (s *server) BatchGetUsers(ctx context.Context, req *pb.BatchGetUsersRequest) (*pb.BatchGetUsersResponse, error) {
+	if err := s.validateBatchGetUsersRequest(req); err != nil {
+		return nil, err
+	}
+	users, err := s.storer.BatchGetUsers(ctx, req.GetIds())
+	if err != nil {
+		return nil, grpc.Errorf(storeErrCode(err), "failed to get users: %v", err)
+	}
+	byID := make(map[string]*store.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	out := &pb.BatchGetUsersResponse{}
+	for _, id := range req.GetIds() {
+		if u, ok := byID[id]; ok {
+			out.Users = append(out.Users, toPbUser(u))
+		} else {
+			out.NotFoundIds = append(out.NotFoundIds, id)
+		}
+	}
+	return out, nil
+}
+
+func (s *server) validateBatchGetUsersRequest(req *pb.BatchGetUsersRequest) error {
+	// TODO: replace with better validation builder.
+	eb := strings.Builder{}
+	eb.WriteString(s.validateBatchSize("ids", len(req.GetIds())))
+	for _, id := range req.GetIds() {
+		if id == "" {
+			eb.WriteString("'ids' cannot contain empty id,")
+			break
+		}
+	}
+	if eb.String() != "" {
+		return grpc.Errorf(codes.InvalidArgument, "invalid request: %s", eb.String())
+	}
+	return nil
+}
+
+func (s *server) BatchCreateUsers(ctx context.Context, req *pb.BatchCreateUsersRequest) (*pb.BatchUsersResponse, error) {
+	if msg := s.validateBatchSize("requests", len(req.GetRequests())); msg != "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: %s", msg)
+	}
+	results := make([]*pb.BatchUserResult, len(req.GetRequests()))
+	var (
+		users []*store.User
+		idx   []int
+	)
+	for i, r := range req.GetRequests() {
+		if err := validateCreateUserRequest(r); err != nil {
+			results[i] = batchError(err)
+			continue
+		}
+		users = append(users, toStoreUser(r.GetUser()))
+		idx = append(idx, i)
+	}
+	if err := s.writeBatch(ctx, "create", users, idx, results, req.GetAtomic(), s.storer.BatchCreateUsers, func(u *pb.User) proto.Message {
+		return &pb.UserCreated{User: u}
+	}); err != nil {
+		return nil, err
+	}
+	return &pb.BatchUsersResponse{Results: results}, nil
+}
+
+func (s *server) BatchUpdateUsers(ctx context.Context, req *pb.BatchUpdateUsersRequest) (*pb.BatchUsersResponse, error) {
+	if msg := s.validateBatchSize("requests", len(req.GetRequests())); msg != "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: %s", msg)
+	}
+	results := make([]*pb.BatchUserResult, len(req.GetRequests()))
+	var (
+		users []*store.User
+		idx   []int
+	)
+	seen := map[string]bool{}
+	for i, r := range req.GetRequests() {
+		if err := validateUpdateUserRequest(r); err != nil {
+			results[i] = batchError(err)
+			continue
+		}
+		if id := r.GetUser().GetId(); seen[id] {
+			results[i] = batchError(grpc.Errorf(codes.InvalidArgument, "invalid request: 'user.id' is duplicated in batch,"))
+			continue
+		}
+		seen[r.GetUser().GetId()] = true
+		users = append(users, toStoreUser(r.GetUser()))
+		idx = append(idx, i)
+	}
+	if err := s.writeBatch(ctx, "update", users, idx, results, req.GetAtomic(), s.storer.BatchUpdateUsers, func(u *pb.User) proto.Message {
+		return &pb.UserUpdated{User: u}
+	}); err != nil {
+		return nil, err
+	}
+	return &pb.BatchUsersResponse{Results: results}, nil
+}
+
+type batchWriteFn func(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+
+// writeBatch writes valid users and fills their results, idx maps users
+// to their positions in results. Event created by newEvent is published for
+// every written user.
+func (s *server) writeBatch(ctx context.Context, action string, users []*store.User, idx []int, results []*pb.BatchUserResult, atomic bool, write batchWriteFn, newEvent func(*pb.User) proto.Message) error {
+	if len(users) == 0 || (atomic && len(users) < len(results)) {
+		abortBatch(results)
+		return nil
+	}
+	written, err := write(ctx, users, atomic)
+	if err != nil {
+		if errors.Is(err, store.ErrUserAlreadyExists) {
+			return grpc.Errorf(codes.AlreadyExists, "failed to %s users: %v", action, err)
+		}
+		return grpc.Errorf(storeErrCode(err), "failed to %s users: %v", action, err)
+	}
+	for j, r := range written {
+		i := idx[j]
+		if r.Err != nil {
+			results[i] = batchError(batchStoreError(action, r.Err))
+			continue
+		}
+		out := toPbUser(r.User)
+		if err := s.eventsPublisher.Publish(ctx, newEvent(out)); err != nil {
+			results[i] = batchError(grpc.Errorf(codes.Internal, "failed to publish event: %v", err))
+			continue
+		}
+		results[i] = &pb.BatchUserResult{User: out}
+	}
+	return nil
+}
+
+// validateBatchSize returns validation error message of batch field.
+func (s *server) validateBatchSize(field string, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("'%s' must be provided,", field)
+	}
+	if n > s.maxBatchSize {
+		return fmt.Sprintf("'%s' cannot have more than %d items,", field, s.maxBatchSize)
+	}
+	return ""
+}
+
+// batchStoreError maps store error of single item to status error,
+// the same as single item request would return.
+func batchStoreError(action string, err error) error {
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return grpc.Errorf(codes.NotFound, "failed to %s user: %v", action, err)
+	case errors.Is(err, store.ErrUserAlreadyExists):
+		return grpc.Errorf(codes.AlreadyExists, "failed to %s user: %v", action, err)
+	case errors.Is(err, store.ErrBatchAborted):
+		return grpc.Errorf(codes.Aborted, "failed to %s user: %v", action, err)
+	}
+	return grpc.Errorf(storeErrCode(err), "failed to %s user: %v", action, err)
+}
+
+func batchError(err error) *pb.BatchUserResult {
+	st := status.Convert(err)
+	return &pb.BatchUserResult{
+		Error: &pb.BatchUserResult_Error{
+			Code:    int32(st.Code()),
+			Message: st.Message(),
+		},
+	}
+}
+
+// abortBatch fails all items which did not fail on their own.
+func abortBatch(results []*pb.BatchUserResult) {
+	for i := range results {
+		if results[i] == nil {
+			results[i] = batchError(grpc.Errorf(codes.Aborted, "batch aborted"))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+var batchCmpOpts = cmpopts.IgnoreUnexported(
+	pb.BatchUsersResponse{},
+	pb.BatchUserResult{},
+	pb.BatchUserResult_Error{},
+	pb.BatchGetUsersResponse{},
+	pb.User{},
+	timestamppb.Timestamp{},
+)
+
+func batchErr(code codes.Code, msg string) *pb.BatchUserResult {
+	return &pb.BatchUserResult{Error: &pb.BatchUserResult_Error{Code: int32(code), Message: msg}}
+}
+
+// storeCreated returns store results which create all users, except ones
+// with given errors.
+func storeCreated(errs map[int]error) func([]*store.User, bool) ([]store.BatchResult, error) {
+	return func(in []*store.User, _ bool) ([]store.BatchResult, error) {
+		out := make([]store.BatchResult, len(in))
+		for i, u := range in {
+			if err, ok := errs[i]; ok {
+				out[i].Err = err
+				continue
+			}
+			out[i].User = &store.User{ID: fmt.Sprintf("id-%d", i), Email: u.Email}
+		}
+		return out, nil
+	}
+}
+
+func TestBatchCreateUsers(t *testing.T) {
+	valid := func(email string) *pb.CreateUserRequest {
+		return &pb.CreateUserRequest{User: &pb.User{Email: email}}
+	}
+	testCases := []struct {
+		desc         string
+		req          *pb.BatchCreateUsersRequest
+		storeFn      func([]*store.User, bool) ([]store.BatchResult, error)
+		expResp      *pb.BatchUsersResponse
+		expErr       string
+		expStoreIn   int
+		expNumEvents int
+	}{
+		{
+			desc:    "empty batch",
+			req:     &pb.BatchCreateUsersRequest{},
+			storeFn: storeCreated(nil),
+			expErr:  "rpc error: code = InvalidArgument desc = invalid request: 'requests' must be provided,",
+		},
+		{
+			desc: "too big batch",
+			req: &pb.BatchCreateUsersRequest{Requests: []*pb.CreateUserRequest{
+				valid("a@test.com"), valid("b@test.com"), valid("c@test.com"),
+			}},
+			storeFn: storeCreated(nil),
+			expErr:  "rpc error: code = InvalidArgument desc = invalid request: 'requests' cannot have more than 2 items,",
+		},
+		{
+			desc: "per item results",
+			req: &pb.BatchCreateUsersRequest{Requests: []*pb.CreateUserRequest{
+				valid("a@test.com"), {User: &pb.User{}},
+			}},
+			storeFn: storeCreated(nil),
+			expResp: &pb.BatchUsersResponse{Results: []*pb.BatchUserResult{
+				{User: &pb.User{Id: "id-0", Email: "a@test.com", UpdatedAt: timestamppb.New(store.User{}.UpdatedAt), CreatedAt: timestamppb.New(store.User{}.CreatedAt)}},
+				batchErr(codes.InvalidArgument, "invalid request: 'user.email' must be provided,"),
+			}},
+			expStoreIn:   1,
+			expNumEvents: 1,
+		},
+		{
+			desc: "store item error",
+			req: &pb.BatchCreateUsersRequest{Requests: []*pb.CreateUserRequest{
+				valid("a@test.com"), valid("b@test.com"),
+			}},
+			storeFn: storeCreated(map[int]error{0: store.ErrUserAlreadyExists}),
+			expResp: &pb.BatchUsersResponse{Results: []*pb.BatchUserResult{
+				batchErr(codes.AlreadyExists, "failed to create user: user already exists"),
+				{User: &pb.User{Id: "id-1", Email: "b@test.com", UpdatedAt: timestamppb.New(store.User{}.UpdatedAt), CreatedAt: timestamppb.New(store.User{}.CreatedAt)}},
+			}},
+			expStoreIn:   2,
+			expNumEvents: 1,
+		},
+		{
+			desc: "atomic with invalid item",
+			req: &pb.BatchCreateUsersRequest{Atomic: true, Requests: []*pb.CreateUserRequest{
+				valid("a@test.com"), {User: &pb.User{}},
+			}},
+			storeFn: storeCreated(nil),
+			expResp: &pb.BatchUsersResponse{Results: []*pb.BatchUserResult{
+				batchErr(codes.Aborted, "batch aborted"),
+				batchErr(codes.InvalidArgument, "invalid request: 'user.email' must be provided,"),
+			}},
+		},
+		{
+			desc: "atomic with store item error",
+			req: &pb.BatchCreateUsersRequest{Atomic: true, Requests: []*pb.CreateUserRequest{
+				valid("a@test.com"), valid("b@test.com"),
+			}},
+			storeFn: storeCreated(map[int]error{0: store.ErrUserAlreadyExists, 1: store.ErrBatchAborted}),
+			expResp: &pb.BatchUsersResponse{Results: []*pb.BatchUserResult{
+				batchErr(codes.AlreadyExists, "failed to create user: user already exists"),
+				batchErr(codes.Aborted, "failed to create user: batch aborted"),
+			}},
+			expStoreIn: 2,
+		},
+		{
+			desc: "whole batch failed",
+			req: &pb.BatchCreateUsersRequest{Requests: []*pb.CreateUserRequest{
+				valid("a@test.com"),
+			}},
+			storeFn: func([]*store.User, bool) ([]store.BatchResult, error) {
+				return nil, fmt.Errorf("some err")
+			},
+			expErr:     "rpc error: code = Internal desc = failed to create users: some err",
+			expStoreIn: 1,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			st := &mockStore{batchWriteFn: tC.storeFn}
+			pub := &mockPublisher{}
+			svc := New(st, pub, WithMaxBatchSize(2))
+			resp, err := svc.BatchCreateUsers(context.Background(), tC.req)
+			assertErrString(t, tC.expErr, err)
+			if diff := cmp.Diff(tC.expResp, resp, batchCmpOpts); diff != "" {
+				t.Errorf("Response mismatch, diff: %s", diff)
+			}
+			if len(st.batchWriteIn) != tC.expStoreIn {
+				t.Errorf("Expected %d users passed to store, got: %d", tC.expStoreIn, len(st.batchWriteIn))
+			}
+			if len(pub.events) != tC.expNumEvents {
+				t.Errorf("Expected %d events, got: %d", tC.expNumEvents, len(pub.events))
+			}
+		})
+	}
+}
+
+func TestBatchUpdateUsers(t *testing.T) {
+	st := &mockStore{batchWriteFn: storeCreated(map[int]error{1: store.ErrUserNotFound})}
+	pub := &mockPublisher{}
+	svc := New(st, pub)
+	resp, err := svc.BatchUpdateUsers(context.Background(), &pb.BatchUpdateUsersRequest{Requests: []*pb.UpdateUserRequest{
+		{User: &pb.User{Id: "id-0", Email: "a@test.com"}},
+		{User: &pb.User{Id: "id-1", Email: "b@test.com"}},
+		{User: &pb.User{Id: "id-0", Email: "c@test.com"}},
+	}})
+	assertErrString(t, "", err)
+	exp := &pb.BatchUsersResponse{Results: []*pb.BatchUserResult{
+		{User: &pb.User{Id: "id-0", Email: "a@test.com", UpdatedAt: timestamppb.New(store.User{}.UpdatedAt), CreatedAt: timestamppb.New(store.User{}.CreatedAt)}},
+		batchErr(codes.NotFound, "failed to update user: user not found"),
+		batchErr(codes.InvalidArgument, "invalid request: 'user.id' is duplicated in batch,"),
+	}}
+	if diff := cmp.Diff(exp, resp, batchCmpOpts); diff != "" {
+		t.Errorf("Response mismatch, diff: %s", diff)
+	}
+	if _, ok := pub.events[0].(*pb.UserUpdated); !ok || len(pub.events) != 1 {
+		t.Errorf("Expected single UserUpdated event, got: %v", pub.events)
+	}
+}
+
+func TestBatchGetUsers(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		req     *pb.BatchGetUsersRequest
+		expResp *pb.BatchGetUsersResponse
+		expErr  string
+	}{
+		{
+			desc:   "no ids",
+			req:    &pb.BatchGetUsersRequest{},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'ids' must be provided,",
+		},
+		{
+			desc:   "empty id",
+			req:    &pb.BatchGetUsersRequest{Ids: []string{"id-1", ""}},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'ids' cannot contain empty id,",
+		},
+		{
+			desc: "users in order of ids",
+			req:  &pb.BatchGetUsersRequest{Ids: []string{"id-2", "id-3", "id-1"}},
+			expResp: &pb.BatchGetUsersResponse{
+				Users: []*pb.User{
+					{Id: "id-2", UpdatedAt: timestamppb.New(store.User{}.UpdatedAt), CreatedAt: timestamppb.New(store.User{}.CreatedAt)},
+					{Id: "id-1", UpdatedAt: timestamppb.New(store.User{}.UpdatedAt), CreatedAt: timestamppb.New(store.User{}.CreatedAt)},
+				},
+				NotFoundIds: []string{"id-3"},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			st := &mockStore{batchGetResp: []*store.User{{ID: "id-1"}, {ID: "id-2"}}}
+			svc := New(st, &mockPublisher{})
+			resp, err := svc.BatchGetUsers(context.Background(), tC.req)
+			assertErrString(t, tC.expErr, err)
+			if diff := cmp.Diff(tC.expResp, resp, batchCmpOpts); diff != "" {
+				t.Errorf("Response mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package rpc
 
 import (
+	"strconv"
+
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
@@ -19,6 +21,9 @@ func toStoreUser(in *pb.User) *store.User {
 		Email:     in.GetEmail(),
 		Country:   in.GetCountry(),
 		UpdatedAt: in.GetUpdatedAt().AsTime(),
+		CreatedAt: in.GetCreatedAt().AsTime(),
+		CreatedBy: in.GetCreatedBy(),
+		UpdatedBy: in.GetUpdatedBy(),
 	}
 }
 
@@ -26,7 +31,7 @@ func toPbUser(in *store.User) *pb.User {
 	if in == nil {
 		return nil
 	}
-	return &pb.User{
+	out := &pb.User{
 		Id:        in.ID,
 		FirstName: in.FirstName,
 		LastName:  in.LastName,
@@ -34,5 +39,152 @@ func toPbUser(in *store.User) *pb.User {
 		Email:     in.Email,
 		Country:   in.Country,
 		UpdatedAt: timestamppb.New(in.UpdatedAt),
+		CreatedAt: timestamppb.New(in.CreatedAt),
+		CreatedBy: in.CreatedBy,
+		UpdatedBy: in.UpdatedBy,
+		TenantId:  in.TenantID,
+	}
+	if in.DeletedAt.Valid {
+		out.DeletedAt = timestamppb.New(in.DeletedAt.Time)
+	}
+	if in.ErasedAt.Valid {
+		out.ErasedAt = timestamppb.New(in.ErasedAt.Time)
+	}
+	return out
+}
+
+func toPbUserHistoryEntry(in *store.AuditEntry) *pb.UserHistoryEntry {
+	if in == nil {
+		return nil
+	}
+	out := &pb.UserHistoryEntry{
+		Id:        strconv.FormatInt(in.ID, 10),
+		UserId:    in.UserID,
+		Action:    toPbUserHistoryAction(in.Action),
+		Actor:     in.Actor,
+		RequestId: in.RequestID,
+		CreatedAt: timestamppb.New(in.CreatedAt),
+	}
+	for _, c := range in.Changes {
+		out.Changes = append(out.Changes, &pb.UserHistoryEntry_FieldChange{
+			Field:  c.Field,
+			Before: c.Before,
+			After:  c.After,
+		})
+	}
+	return out
+}
+
+func toPbUserHistoryAction(in string) pb.UserHistoryEntry_Action {
+	switch in {
+	case store.AuditActionCreate:
+		return pb.UserHistoryEntry_CREATE
+	case store.AuditActionUpdate:
+		return pb.UserHistoryEntry_UPDATE
+	case store.AuditActionDelete:
+		return pb.UserHistoryEntry_DELETE
+	case store.AuditActionUndelete:
+		return pb.UserHistoryEntry_UNDELETE
+	case store.AuditActionPurge:
+		return pb.UserHistoryEntry_PURGE
+	case store.AuditActionErase:
+		return pb.UserHistoryEntry_ERASE
+	}
+	return pb.UserHistoryEntry_ACTION_UNSPECIFIED
+}
+
+func toStoreWebhookSubscription(in *pb.WebhookSubscription) *store.WebhookSubscription {
+	if in == nil {
+		return nil
+	}
+	return &store.WebhookSubscription{
+		ID:         in.GetId(),
+		URL:        in.GetUrl(),
+		EventTypes: in.GetEventTypes(),
+		Secret:     in.GetSecret(),
+	}
+}
+
+// toPbWebhookSubscription converts subscription omitting its secret.
+func toPbWebhookSubscription(in *store.WebhookSubscription) *pb.WebhookSubscription {
+	if in == nil {
+		return nil
+	}
+	return &pb.WebhookSubscription{
+		Id:         in.ID,
+		Url:        in.URL,
+		EventTypes: in.EventTypes,
+		CreatedAt:  timestamppb.New(in.CreatedAt),
+	}
+}
+
+func toPbWebhookDelivery(in *store.WebhookDelivery) *pb.WebhookDelivery {
+	if in == nil {
+		return nil
+	}
+	out := &pb.WebhookDelivery{
+		Id:             in.ID,
+		SubscriptionId: in.SubscriptionID,
+		EventId:        in.EventID,
+		EventType:      in.EventType,
+		Status:         toPbWebhookDeliveryStatus(in.Status),
+		AttemptCount:   int32(in.AttemptCount),
+		CreatedAt:      timestamppb.New(in.CreatedAt),
+		UpdatedAt:      timestamppb.New(in.UpdatedAt),
+	}
+	if in.NextAttemptAt.Valid && in.Status == store.WebhookDeliveryPending {
+		out.NextAttemptAt = timestamppb.New(in.NextAttemptAt.Time)
+	}
+	return out
+}
+
+func toPbWebhookDeliveryAttempt(in *store.WebhookDeliveryAttempt) *pb.WebhookDeliveryAttempt {
+	if in == nil {
+		return nil
+	}
+	return &pb.WebhookDeliveryAttempt{
+		Number:         int32(in.Number),
+		AttemptedAt:    timestamppb.New(in.AttemptedAt),
+		ResponseStatus: int32(in.ResponseStatus),
+		Error:          in.Error,
+		DurationMs:     in.DurationMs,
+	}
+}
+
+func toPbWebhookDeliveryStatus(in string) pb.WebhookDelivery_Status {
+	switch in {
+	case store.WebhookDeliveryPending:
+		return pb.WebhookDelivery_PENDING
+	case store.WebhookDeliverySucceeded:
+		return pb.WebhookDelivery_SUCCEEDED
+	case store.WebhookDeliveryFailed:
+		return pb.WebhookDelivery_FAILED
+	}
+	return pb.WebhookDelivery_STATUS_UNSPECIFIED
+}
+
+func toStoreWebhookDeliveryStatus(in pb.WebhookDelivery_Status) string {
+	switch in {
+	case pb.WebhookDelivery_PENDING:
+		return store.WebhookDeliveryPending
+	case pb.WebhookDelivery_SUCCEEDED:
+		return store.WebhookDeliverySucceeded
+	case pb.WebhookDelivery_FAILED:
+		return store.WebhookDeliveryFailed
+	}
+	return ""
+}
+
+func toPbErasureReceipt(in *store.Erasure) *pb.ErasureReceipt {
+	if in == nil {
+		return nil
+	}
+	return &pb.ErasureReceipt{
+		Id:        in.ID,
+		UserId:    in.UserID,
+		Reason:    in.Reason,
+		ErasedBy:  in.Actor,
+		RequestId: in.RequestID,
+		ErasedAt:  timestamppb.New(in.ErasedAt),
 	}
 }
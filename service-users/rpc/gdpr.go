@@ -0,0 +1,191 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+// userDataBundle is JSON document returned by ExportUserData. Its format
+// is independent of store types, so it does not change with them.
+type userDataBundle struct {
+	ExportedAt time.Time          `json:"exported_at"`
+	User       *bundleUser        `json:"user"`
+	AuditLog   []bundleAuditEntry `json:"audit_log"`
+	Events     []bundleEvent      `json:"events"`
+	Erasures   []bundleErasure    `json:"erasures"`
+}
+
+type bundleUser struct {
+	ID        string     `json:"id"`
+	TenantID  string     `json:"tenant_id"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	Nickname  string     `json:"nickname"`
+	Email     string     `json:"email"`
+	Country   string     `json:"country"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	UpdatedBy string     `json:"updated_by"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	ErasedAt  *time.Time `json:"erased_at"`
+}
+
+type bundleAuditEntry struct {
+	ID        int64               `json:"id"`
+	Action    string              `json:"action"`
+	Actor     string              `json:"actor"`
+	RequestID string              `json:"request_id"`
+	Changes   []bundleFieldChange `json:"changes"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+type bundleFieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type bundleEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type bundleErasure struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id"`
+	ErasedAt  time.Time `json:"erased_at"`
+}
+
+func (s *server) ExportUserData(ctx context.Context, req *pb.ExportUserDataRequest) (*pb.ExportUserDataResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'id' must be provided,")
+	}
+	data, err := s.storer.GetUserData(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return nil, grpc.Errorf(codes.NotFound, "failed to get user data: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to get user data: %v", err)
+	}
+	out, err := json.MarshalIndent(toUserDataBundle(data, time.Now().UTC()), "", "  ")
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "failed to marshal user data: %v", err)
+	}
+	return &pb.ExportUserDataResponse{Data: out}, nil
+}
+
+func (s *server) EraseUser(ctx context.Context, req *pb.EraseUserRequest) (*pb.ErasureReceipt, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := validateEraseUserRequest(req); err != nil {
+		return nil, err
+	}
+	receipt, err := s.storer.EraseUser(ctx, req.GetId(), req.GetReason())
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrUserNotFound):
+			return nil, grpc.Errorf(codes.NotFound, "failed to erase user: %v", err)
+		case errors.Is(err, store.ErrUserErased):
+			return nil, grpc.Errorf(codes.FailedPrecondition, "failed to erase user: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to erase user: %v", err)
+	}
+	event := &pb.UserErased{
+		Id:       receipt.UserID,
+		TenantId: receipt.TenantID,
+		ErasedAt: timestamppb.New(receipt.ErasedAt),
+	}
+	if err := s.eventsPublisher.Publish(ctx, event); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "failed to publish event: %v", err)
+	}
+	return toPbErasureReceipt(receipt), nil
+}
+
+func validateEraseUserRequest(req *pb.EraseUserRequest) error {
+	eb := strings.Builder{}
+	if req.GetId() == "" {
+		eb.WriteString("'id' must be provided,")
+	}
+	if req.GetReason() == "" {
+		eb.WriteString("'reason' must be provided,")
+	}
+	if eb.Len() > 0 {
+		return grpc.Errorf(codes.InvalidArgument, "invalid request: %s", eb.String())
+	}
+	return nil
+}
+
+func toUserDataBundle(in *store.UserData, exportedAt time.Time) *userDataBundle {
+	out := &userDataBundle{
+		ExportedAt: exportedAt,
+		AuditLog:   []bundleAuditEntry{},
+		Events:     []bundleEvent{},
+		Erasures:   []bundleErasure{},
+	}
+	if u := in.User; u != nil {
+		out.User = &bundleUser{
+			ID:        u.ID,
+			TenantID:  u.TenantID,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Nickname:  u.Nickname,
+			Email:     u.Email,
+			Country:   u.Country,
+			CreatedAt: u.CreatedAt,
+			CreatedBy: u.CreatedBy,
+			UpdatedAt: u.UpdatedAt,
+			UpdatedBy: u.UpdatedBy,
+		}
+		if u.DeletedAt.Valid {
+			out.User.DeletedAt = &u.DeletedAt.Time
+		}
+		if u.ErasedAt.Valid {
+			out.User.ErasedAt = &u.ErasedAt.Time
+		}
+	}
+	for _, e := range in.AuditLog {
+		entry := bundleAuditEntry{
+			ID:        e.ID,
+			Action:    e.Action,
+			Actor:     e.Actor,
+			RequestID: e.RequestID,
+			Changes:   []bundleFieldChange{},
+			CreatedAt: e.CreatedAt,
+		}
+		for _, c := range e.Changes {
+			entry.Changes = append(entry.Changes, bundleFieldChange{Field: c.Field, Before: c.Before, After: c.After})
+		}
+		out.AuditLog = append(out.AuditLog, entry)
+	}
+	for _, e := range in.Events {
+		out.Events = append(out.Events, bundleEvent{ID: e.ID, Type: e.Type, CreatedAt: e.CreatedAt})
+	}
+	for _, e := range in.Erasures {
+		out.Erasures = append(out.Erasures, bundleErasure{
+			ID:        e.ID,
+			Reason:    e.Reason,
+			Actor:     e.Actor,
+			RequestID: e.RequestID,
+			ErasedAt:  e.ErasedAt,
+		})
+	}
+	return out
+}
@@ -0,0 +1,242 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func TestExportUserData(t *testing.T) {
+	adminCtx := auth.NewContext(context.Background(), auth.Identity{Subject: "admin", Admin: true})
+	createdAt := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc      string
+		ctx       context.Context
+		req       *pb.ExportUserDataRequest
+		dataResp  *store.UserData
+		dataErr   error
+		expBundle map[string]interface{}
+		expErr    string
+	}{
+		{
+			desc:   "caller is not admin",
+			ctx:    context.Background(),
+			req:    &pb.ExportUserDataRequest{Id: "id-1"},
+			expErr: "rpc error: code = PermissionDenied desc = admin privileges required",
+		},
+		{
+			desc:   "invalid req",
+			ctx:    adminCtx,
+			req:    &pb.ExportUserDataRequest{},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'id' must be provided,",
+		},
+		{
+			desc:    "user not found",
+			ctx:     adminCtx,
+			req:     &pb.ExportUserDataRequest{Id: "id-1"},
+			dataErr: store.ErrUserNotFound,
+			expErr:  "rpc error: code = NotFound desc = failed to get user data: user not found",
+		},
+		{
+			desc: "purged user",
+			ctx:  adminCtx,
+			req:  &pb.ExportUserDataRequest{Id: "id-1"},
+			dataResp: &store.UserData{
+				AuditLog: []*store.AuditEntry{{
+					ID:        7,
+					UserID:    "id-1",
+					Action:    store.AuditActionPurge,
+					Actor:     "purger",
+					CreatedAt: createdAt,
+				}},
+			},
+			expBundle: map[string]interface{}{
+				"user": nil,
+				"audit_log": []interface{}{map[string]interface{}{
+					"id":         float64(7),
+					"action":     "purge",
+					"actor":      "purger",
+					"request_id": "",
+					"changes":    []interface{}{},
+					"created_at": "2020-12-10T11:00:00Z",
+				}},
+				"events":   []interface{}{},
+				"erasures": []interface{}{},
+			},
+		},
+		{
+			desc: "deleted user",
+			ctx:  adminCtx,
+			req:  &pb.ExportUserDataRequest{Id: "id-1"},
+			dataResp: &store.UserData{
+				User: &store.User{
+					ID:        "id-1",
+					TenantID:  "acme",
+					FirstName: "John",
+					Email:     "john@test.com",
+					CreatedAt: createdAt,
+					UpdatedAt: createdAt,
+					DeletedAt: sql.NullTime{Time: createdAt, Valid: true},
+				},
+				Events: []*store.UserEvent{{ID: "ev-1", UserID: "id-1", Type: "UserDeleted", CreatedAt: createdAt}},
+			},
+			expBundle: map[string]interface{}{
+				"user": map[string]interface{}{
+					"id":         "id-1",
+					"tenant_id":  "acme",
+					"first_name": "John",
+					"last_name":  "",
+					"nickname":   "",
+					"email":      "john@test.com",
+					"country":    "",
+					"created_at": "2020-12-10T11:00:00Z",
+					"created_by": "",
+					"updated_at": "2020-12-10T11:00:00Z",
+					"updated_by": "",
+					"deleted_at": "2020-12-10T11:00:00Z",
+					"erased_at":  nil,
+				},
+				"audit_log": []interface{}{},
+				"events": []interface{}{map[string]interface{}{
+					"id":         "ev-1",
+					"type":       "UserDeleted",
+					"created_at": "2020-12-10T11:00:00Z",
+				}},
+				"erasures": []interface{}{},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			st := &mockStore{
+				userDataRespFn: func() (*store.UserData, error) {
+					return tC.dataResp, tC.dataErr
+				},
+			}
+			resp, err := New(st, &mockPublisher{}).ExportUserData(tC.ctx, tC.req)
+			assertErrString(t, tC.expErr, err)
+			if tC.expBundle == nil {
+				return
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(resp.GetData(), &got); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, ok := got["exported_at"]; !ok {
+				t.Errorf("Expected exported_at in bundle")
+			}
+			delete(got, "exported_at")
+			if diff := cmp.Diff(tC.expBundle, got); diff != "" {
+				t.Errorf("Bundle mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	adminCtx := auth.NewContext(context.Background(), auth.Identity{Subject: "admin", Admin: true})
+	erasedAt := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc       string
+		ctx        context.Context
+		req        *pb.EraseUserRequest
+		eraseErr   error
+		expReceipt *pb.ErasureReceipt
+		expEvents  []proto.Message
+		expErr     string
+	}{
+		{
+			desc:   "caller is not admin",
+			ctx:    context.Background(),
+			req:    &pb.EraseUserRequest{Id: "id-1", Reason: "DSR-1"},
+			expErr: "rpc error: code = PermissionDenied desc = admin privileges required",
+		},
+		{
+			desc:   "invalid req",
+			ctx:    adminCtx,
+			req:    &pb.EraseUserRequest{},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'id' must be provided,'reason' must be provided,",
+		},
+		{
+			desc:     "user not found",
+			ctx:      adminCtx,
+			req:      &pb.EraseUserRequest{Id: "id-1", Reason: "DSR-1"},
+			eraseErr: store.ErrUserNotFound,
+			expErr:   "rpc error: code = NotFound desc = failed to erase user: user not found",
+		},
+		{
+			desc:     "user already erased",
+			ctx:      adminCtx,
+			req:      &pb.EraseUserRequest{Id: "id-1", Reason: "DSR-1"},
+			eraseErr: store.ErrUserErased,
+			expErr:   "rpc error: code = FailedPrecondition desc = failed to erase user: user is already erased",
+		},
+		{
+			desc:     "store error",
+			ctx:      adminCtx,
+			req:      &pb.EraseUserRequest{Id: "id-1", Reason: "DSR-1"},
+			eraseErr: fmt.Errorf("some err"),
+			expErr:   "rpc error: code = Internal desc = failed to erase user: some err",
+		},
+		{
+			desc: "user erased",
+			ctx:  adminCtx,
+			req:  &pb.EraseUserRequest{Id: "id-1", Reason: "DSR-1"},
+			expReceipt: &pb.ErasureReceipt{
+				Id:        "receipt-1",
+				UserId:    "id-1",
+				Reason:    "DSR-1",
+				ErasedBy:  "admin",
+				RequestId: "req-1",
+				ErasedAt:  timestamppb.New(erasedAt),
+			},
+			expEvents: []proto.Message{&pb.UserErased{
+				Id:       "id-1",
+				TenantId: "acme",
+				ErasedAt: timestamppb.New(erasedAt),
+			}},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			st := &mockStore{
+				eraseUserRespFn: func() (*store.Erasure, error) {
+					if tC.eraseErr != nil {
+						return nil, tC.eraseErr
+					}
+					return &store.Erasure{
+						ID:        "receipt-1",
+						TenantID:  "acme",
+						UserID:    "id-1",
+						Reason:    "DSR-1",
+						Actor:     "admin",
+						RequestID: "req-1",
+						ErasedAt:  erasedAt,
+					}, nil
+				},
+			}
+			publisher := &mockPublisher{}
+			resp, err := New(st, publisher).EraseUser(tC.ctx, tC.req)
+			assertErrString(t, tC.expErr, err)
+			opts := cmpopts.IgnoreUnexported(pb.ErasureReceipt{}, pb.UserErased{}, timestamppb.Timestamp{})
+			if diff := cmp.Diff(tC.expReceipt, resp, opts); diff != "" {
+				t.Errorf("Receipt mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.expEvents, publisher.events, opts); diff != "" {
+				t.Errorf("Events mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
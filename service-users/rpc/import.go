@@ -0,0 +1,262 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/requestid"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+const exportPageSize = 500
+
+// importLogInterval is how often progress of running import is logged.
+const importLogInterval = 10 * time.Second
+
+var importedRowsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "users_import_rows_total",
+	Help: "Total number of rows processed by ImportUsers by result (created, updated or failed) and dry run.",
+}, []string{"result", "dry_run"})
+
+// importProgress reports progress of import on server, as client gets
+// response only once whole stream is processed. Counters are updated after
+// every batch and progress is logged at most every importLogInterval.
+type importProgress struct {
+	logger  *log.Entry
+	dryRun  string
+	last    pb.ImportUsersResponse
+	started time.Time
+	logged  time.Time
+}
+
+func newImportProgress(ctx context.Context, opts *pb.ImportUsersRequest_Options) *importProgress {
+	now := time.Now()
+	return &importProgress{
+		logger: log.WithFields(log.Fields{
+			"request_id": requestid.FromContext(ctx),
+			"dry_run":    opts.GetDryRun(),
+		}),
+		dryRun:  strconv.FormatBool(opts.GetDryRun()),
+		started: now,
+		logged:  now,
+	}
+}
+
+// batchDone updates counters with results of rows added to resp since
+// previous batch.
+func (p *importProgress) batchDone(rows int64, resp *pb.ImportUsersResponse) {
+	importedRowsTotal.WithLabelValues("created", p.dryRun).Add(float64(resp.Created - p.last.Created))
+	importedRowsTotal.WithLabelValues("updated", p.dryRun).Add(float64(resp.Updated - p.last.Updated))
+	importedRowsTotal.WithLabelValues("failed", p.dryRun).Add(float64(resp.Failed - p.last.Failed))
+	p.last.Created, p.last.Updated, p.last.Failed = resp.Created, resp.Updated, resp.Failed
+	if now := time.Now(); now.Sub(p.logged) >= importLogInterval {
+		p.logged = now
+		p.entry(rows).Info("Import in progress")
+	}
+}
+
+// done logs summary of finished import.
+func (p *importProgress) done(rows int64) {
+	p.entry(rows).WithField("duration", time.Since(p.started).Round(time.Millisecond).String()).Info("Import finished")
+}
+
+func (p *importProgress) entry(rows int64) *log.Entry {
+	return p.logger.WithFields(log.Fields{
+		"rows":    rows,
+		"created": p.last.Created,
+		"updated": p.last.Updated,
+		"failed":  p.last.Failed,
+	})
+}
+
+// importRow is user received in import stream.
+type importRow struct {
+	// number is position of user in stream, starting from 1.
+	number int64
+	user   *pb.User
+}
+
+func (s *server) ImportUsers(stream pb.Users_ImportUsersServer) error {
+	var (
+		opts     *pb.ImportUsersRequest_Options
+		progress *importProgress
+		rows     []importRow
+		n        int64
+	)
+	resp := &pb.ImportUsersResponse{}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if opts == nil {
+			opts = req.GetOptions()
+			if opts == nil {
+				opts = &pb.ImportUsersRequest_Options{}
+			}
+			progress = newImportProgress(stream.Context(), opts)
+		}
+		for _, u := range req.GetUsers() {
+			n++
+			rows = append(rows, importRow{number: n, user: u})
+			if len(rows) < s.maxBatchSize {
+				continue
+			}
+			if err := s.importBatch(stream.Context(), opts, rows, resp); err != nil {
+				return err
+			}
+			progress.batchDone(n, resp)
+			rows = rows[:0]
+		}
+	}
+	if len(rows) > 0 {
+		if err := s.importBatch(stream.Context(), opts, rows, resp); err != nil {
+			return err
+		}
+		progress.batchDone(n, resp)
+	}
+	if progress != nil {
+		progress.done(n)
+	}
+	return stream.SendAndClose(resp)
+}
+
+// importBatch validates rows and, unless it is dry run, writes them.
+// Counters and errors of rows are added to resp.
+func (s *server) importBatch(ctx context.Context, opts *pb.ImportUsersRequest_Options, rows []importRow, resp *pb.ImportUsersResponse) error {
+	results := make([]*pb.BatchUserResult, len(rows))
+	var emails []string
+	for i, r := range rows {
+		if err := validateCreateUserRequest(&pb.CreateUserRequest{User: r.user}); err != nil {
+			results[i] = batchError(err)
+			continue
+		}
+		emails = append(emails, r.user.GetEmail())
+	}
+	// Existing users are needed to decide about upserts and to report
+	// conflicts without writing anything in dry run.
+	existing := map[string]string{}
+	if opts.GetUpsertByEmail() || opts.GetDryRun() {
+		users, err := s.storer.BatchGetUsersByEmail(ctx, emails)
+		if err != nil {
+			return grpc.Errorf(storeErrCode(err), "failed to get users by email: %v", err)
+		}
+		for _, u := range users {
+			existing[strings.ToLower(u.Email)] = u.ID
+		}
+	}
+
+	var (
+		creates, updates     []*store.User
+		createIdx, updateIdx []int
+		seen                 = map[string]bool{}
+		updated              = make([]bool, len(rows))
+	)
+	for i, r := range rows {
+		if results[i] != nil {
+			continue
+		}
+		email := strings.ToLower(r.user.GetEmail())
+		id, exists := existing[email]
+		switch {
+		case seen[email] || (exists && !opts.GetUpsertByEmail()):
+			results[i] = batchError(batchStoreError("create", store.ErrUserAlreadyExists))
+			continue
+		case opts.GetDryRun():
+			results[i] = &pb.BatchUserResult{User: r.user}
+		case exists:
+			u := toStoreUser(r.user)
+			u.ID = id
+			updates = append(updates, u)
+			updateIdx = append(updateIdx, i)
+		default:
+			creates = append(creates, toStoreUser(r.user))
+			createIdx = append(createIdx, i)
+		}
+		seen[email] = true
+		updated[i] = exists
+	}
+	if len(creates) > 0 {
+		err := s.writeBatch(ctx, "create", creates, createIdx, results, false, s.storer.BatchCreateUsers, func(u *pb.User) proto.Message {
+			return &pb.UserCreated{User: u}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(updates) > 0 {
+		err := s.writeBatch(ctx, "update", updates, updateIdx, results, false, s.storer.BatchUpdateUsers, func(u *pb.User) proto.Message {
+			return &pb.UserUpdated{User: u}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, r := range results {
+		switch {
+		case r.GetError() != nil:
+			resp.Failed++
+			resp.Errors = append(resp.Errors, &pb.ImportUsersResponse_RowError{
+				Row:     rows[i].number,
+				Email:   rows[i].user.GetEmail(),
+				Code:    r.GetError().GetCode(),
+				Message: r.GetError().GetMessage(),
+			})
+		case updated[i]:
+			resp.Updated++
+		default:
+			resp.Created++
+		}
+	}
+	return nil
+}
+
+func (s *server) ExportUsers(req *pb.ExportUsersRequest, stream pb.Users_ExportUsersServer) error {
+	ctx := stream.Context()
+	var opts []store.ReadOption
+	if req.GetShowDeleted() {
+		if err := requireAdmin(ctx); err != nil {
+			return err
+		}
+		opts = append(opts, store.WithDeleted())
+	}
+	listFilter, err := parseListUsersFilter(req.GetFiltering(), req.GetFilter(), "")
+	if err != nil {
+		return err
+	}
+	after := ""
+	for {
+		users, err := s.storer.ListUsers(ctx, listFilter, after, exportPageSize, opts...)
+		if err != nil {
+			if errors.Is(err, store.ErrFieldEncrypted) {
+				return grpc.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+			}
+			return grpc.Errorf(storeErrCode(err), "failed to export users: %v", err)
+		}
+		for _, u := range users {
+			if err := stream.Send(toPbUser(u)); err != nil {
+				return err
+			}
+		}
+		if len(users) < exportPageSize {
+			return nil
+		}
+		after = users[len(users)-1].ID
+	}
+}
@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func TestImportUsers(t *testing.T) {
+	user := func(email string) *pb.User {
+		return &pb.User{FirstName: "Johnny", Email: email}
+	}
+	testCases := []struct {
+		desc         string
+		reqs         []*pb.ImportUsersRequest
+		existing     []*store.User
+		storeErrs    map[string]error
+		expResp      *pb.ImportUsersResponse
+		expWrites    int
+		expNumEvents int
+	}{
+		{
+			desc: "per row errors",
+			reqs: []*pb.ImportUsersRequest{
+				{Users: []*pb.User{user("a@test.com"), user(""), user("b@test.com")}},
+			},
+			storeErrs: map[string]error{"b@test.com": store.ErrUserAlreadyExists},
+			expResp: &pb.ImportUsersResponse{
+				Created: 1,
+				Failed:  2,
+				Errors: []*pb.ImportUsersResponse_RowError{
+					{Row: 2, Code: int32(codes.InvalidArgument), Message: "invalid request: 'user.email' must be provided,"},
+					{Row: 3, Email: "b@test.com", Code: int32(codes.AlreadyExists), Message: "failed to create user: user already exists"},
+				},
+			},
+			expWrites:    2,
+			expNumEvents: 1,
+		},
+		{
+			desc: "rows are numbered across batches and messages",
+			reqs: []*pb.ImportUsersRequest{
+				{Users: []*pb.User{user("a@test.com")}},
+				{Users: []*pb.User{user("b@test.com"), user("c@test.com")}},
+			},
+			storeErrs: map[string]error{
+				"a@test.com": store.ErrUserAlreadyExists,
+				"c@test.com": store.ErrUserAlreadyExists,
+			},
+			expResp: &pb.ImportUsersResponse{
+				Created: 1,
+				Failed:  2,
+				Errors: []*pb.ImportUsersResponse_RowError{
+					{Row: 1, Email: "a@test.com", Code: int32(codes.AlreadyExists), Message: "failed to create user: user already exists"},
+					{Row: 3, Email: "c@test.com", Code: int32(codes.AlreadyExists), Message: "failed to create user: user already exists"},
+				},
+			},
+			expWrites:    2,
+			expNumEvents: 1,
+		},
+		{
+			desc: "dry run",
+			reqs: []*pb.ImportUsersRequest{
+				{Options: &pb.ImportUsersRequest_Options{DryRun: true}, Users: []*pb.User{user("a@test.com"), user("a@test.com"), user("b@test.com")}},
+			},
+			existing: []*store.User{{ID: "id-1", Email: "B@test.com"}},
+			expResp: &pb.ImportUsersResponse{
+				Created: 1,
+				Failed:  2,
+				Errors: []*pb.ImportUsersResponse_RowError{
+					{Row: 2, Email: "a@test.com", Code: int32(codes.AlreadyExists), Message: "failed to create user: user already exists"},
+					{Row: 3, Email: "b@test.com", Code: int32(codes.AlreadyExists), Message: "failed to create user: user already exists"},
+				},
+			},
+		},
+		{
+			desc: "upsert by email",
+			reqs: []*pb.ImportUsersRequest{
+				{Options: &pb.ImportUsersRequest_Options{UpsertByEmail: true}, Users: []*pb.User{user("a@test.com"), user("b@test.com")}},
+			},
+			existing: []*store.User{{ID: "id-1", Email: "b@test.com"}},
+			expResp: &pb.ImportUsersResponse{
+				Created: 1,
+				Updated: 1,
+			},
+			expWrites:    2,
+			expNumEvents: 2,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			writes := 0
+			st := &mockStore{
+				byEmailResp: tC.existing,
+				batchWriteFn: func(in []*store.User, _ bool) ([]store.BatchResult, error) {
+					out := make([]store.BatchResult, len(in))
+					for i, u := range in {
+						if err, ok := tC.storeErrs[u.Email]; ok {
+							out[i].Err = err
+							continue
+						}
+						out[i].User = u
+					}
+					writes++
+					return out, nil
+				},
+			}
+			pub := &mockPublisher{}
+			svc := New(st, pub, WithMaxBatchSize(2))
+			stream := &mockImportStream{reqs: tC.reqs}
+			dryRun := strconv.FormatBool(tC.reqs[0].GetOptions().GetDryRun())
+			counters := map[string]prometheus.Counter{}
+			before := map[string]float64{}
+			for _, result := range []string{"created", "updated", "failed"} {
+				counters[result] = importedRowsTotal.WithLabelValues(result, dryRun)
+				before[result] = testutil.ToFloat64(counters[result])
+			}
+			err := svc.ImportUsers(stream)
+			assertErrString(t, "", err)
+			expCounts := map[string]int64{"created": tC.expResp.Created, "updated": tC.expResp.Updated, "failed": tC.expResp.Failed}
+			for result, exp := range expCounts {
+				if got := testutil.ToFloat64(counters[result]) - before[result]; got != float64(exp) {
+					t.Errorf("Expected %d %s rows counted, got: %v", exp, result, got)
+				}
+			}
+			opts := cmpopts.IgnoreUnexported(pb.ImportUsersResponse{}, pb.ImportUsersResponse_RowError{})
+			if diff := cmp.Diff(tC.expResp, stream.resp, opts); diff != "" {
+				t.Errorf("Response mismatch, diff: %s", diff)
+			}
+			if writes != tC.expWrites {
+				t.Errorf("Expected %d store writes, got: %d", tC.expWrites, writes)
+			}
+			if len(pub.events) != tC.expNumEvents {
+				t.Errorf("Expected %d events, got: %d", tC.expNumEvents, len(pub.events))
+			}
+		})
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	st := &mockStore{listUsersResp: []*store.User{{ID: "id-1"}, {ID: "id-2"}}}
+	svc := New(st, &mockPublisher{})
+
+	stream := &mockExportStream{ctx: context.Background()}
+	err := svc.ExportUsers(&pb.ExportUsersRequest{
+		Filtering: &pb.ListUsersRequest_Filtering{Countries: []string{"PL"}},
+	}, stream)
+	assertErrString(t, "", err)
+	if len(stream.users) != 2 || stream.users[1].GetId() != "id-2" {
+		t.Errorf("Expected all users to be exported, got: %v", stream.users)
+	}
+	if diff := cmp.Diff([]string{"PL"}, st.listUsersFilter.Countries); diff != "" {
+		t.Errorf("Filter mismatch, diff: %s", diff)
+	}
+
+	// Deleted users can be exported only by admins.
+	stream = &mockExportStream{ctx: auth.NewContext(context.Background(), auth.Identity{Subject: "john"})}
+	err = svc.ExportUsers(&pb.ExportUsersRequest{ShowDeleted: true}, stream)
+	assertErrString(t, "rpc error: code = PermissionDenied desc = admin privileges required", err)
+}
+
+type mockImportStream struct {
+	grpc.ServerStream
+	reqs []*pb.ImportUsersRequest
+	resp *pb.ImportUsersResponse
+}
+
+func (m *mockImportStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockImportStream) Recv() (*pb.ImportUsersRequest, error) {
+	if len(m.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := m.reqs[0]
+	m.reqs = m.reqs[1:]
+	return req, nil
+}
+
+func (m *mockImportStream) SendAndClose(resp *pb.ImportUsersResponse) error {
+	m.resp = resp
+	return nil
+}
+
+type mockExportStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	users []*pb.User
+}
+
+func (m *mockExportStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockExportStream) Send(u *pb.User) error {
+	m.users = append(m.users, u)
+	return nil
+}
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// pageSize returns page size which should be used for given requested size.
+func pageSize(requested int32) int {
+	switch {
+	case requested <= 0:
+		return defaultPageSize
+	case requested > maxPageSize:
+		return maxPageSize
+	}
+	return int(requested)
+}
+
+// encodePageToken returns opaque page token which points after given key.
+func encodePageToken(key string) string {
+	if key == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodePageToken returns key encoded by encodePageToken.
+func decodePageToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("malformed page token")
+	}
+	return string(key), nil
+}
+
+// decodeOffsetPageToken returns offset encoded by encodePageToken.
+func decodeOffsetPageToken(token string) (int, error) {
+	key, err := decodePageToken(token)
+	if err != nil || key == "" {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(key)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("malformed page token")
+	}
+	return offset, nil
+}
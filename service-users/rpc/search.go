@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+// searcher is full-text index of users. Results are paginated by offset,
+// as order by relevance has no stable key.
+type searcher interface {
+	SearchUsers(ctx context.Context, q store.SearchQuery, offset, limit int, opts ...store.ReadOption) ([]*store.SearchResult, error)
+}
+
+func (s *server) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	if s.searcher == nil {
+		return nil, grpc.Errorf(codes.Unimplemented, "search is not configured")
+	}
+	offset, err := validateSearchUsersRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var opts []store.ReadOption
+	if req.GetShowDeleted() {
+		if err := requireAdmin(ctx); err != nil {
+			return nil, err
+		}
+		opts = append(opts, store.WithDeleted())
+	}
+	limit := pageSize(req.GetPageSize())
+	q := store.SearchQuery{
+		Text:      req.GetQuery(),
+		Fuzzy:     req.GetFuzzy(),
+		Countries: req.GetFiltering().GetCountries(),
+	}
+	results, err := s.searcher.SearchUsers(ctx, q, offset, limit, opts...)
+	if err != nil {
+		if errors.Is(err, store.ErrFieldEncrypted) {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to search users: %v", err)
+	}
+	out := &pb.SearchUsersResponse{}
+	for _, r := range results {
+		out.Results = append(out.Results, &pb.SearchUsersResponse_Result{
+			User:  toPbUser(&r.User),
+			Score: r.Score,
+		})
+	}
+	if len(results) == limit {
+		out.NextPageToken = encodePageToken(strconv.Itoa(offset + limit))
+	}
+	return out, nil
+}
+
+// validateSearchUsersRequest validates request and returns offset decoded
+// from page token.
+func validateSearchUsersRequest(req *pb.SearchUsersRequest) (int, error) {
+	eb := strings.Builder{}
+	if strings.TrimSpace(req.GetQuery()) == "" {
+		eb.WriteString("'query' must be provided,")
+	}
+	offset, err := decodeOffsetPageToken(req.GetPageToken())
+	if err != nil {
+		eb.WriteString("'page_token' " + err.Error() + ",")
+	}
+	if eb.String() != "" {
+		return 0, grpc.Errorf(codes.InvalidArgument, "invalid request: %s", eb.String())
+	}
+	return offset, nil
+}
@@ -0,0 +1,131 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func TestSearchUsers(t *testing.T) {
+	adminCtx := auth.NewContext(context.Background(), auth.Identity{Subject: "admin", Admin: true})
+	testCases := []struct {
+		desc           string
+		ctx            context.Context
+		req            *pb.SearchUsersRequest
+		resp           []*store.SearchResult
+		searchErr      error
+		expQuery       store.SearchQuery
+		expOffset      int
+		expShowDeleted bool
+		expResp        *pb.SearchUsersResponse
+		expErr         string
+	}{
+		{
+			desc:   "invalid request",
+			req:    &pb.SearchUsersRequest{Query: " ", PageToken: encodePageToken("-1")},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'query' must be provided,'page_token' malformed page token,",
+		},
+		{
+			desc:   "deleted users requested by non admin",
+			ctx:    auth.NewContext(context.Background(), auth.Identity{Subject: "user"}),
+			req:    &pb.SearchUsersRequest{Query: "john", ShowDeleted: true},
+			expErr: "rpc error: code = PermissionDenied desc = admin privileges required",
+		},
+		{
+			desc:      "encrypted fields searched",
+			req:       &pb.SearchUsersRequest{Query: "john"},
+			searchErr: fmt.Errorf("%w: only exact email can be searched", store.ErrFieldEncrypted),
+			expErr:    "rpc error: code = InvalidArgument desc = invalid request: field is encrypted: only exact email can be searched",
+		},
+		{
+			desc: "fuzzy filtered by countries with deleted users, last page",
+			ctx:  adminCtx,
+			req: &pb.SearchUsersRequest{
+				Query:       "jonny cash",
+				Fuzzy:       true,
+				Filtering:   &pb.ListUsersRequest_Filtering{Countries: []string{"US"}},
+				ShowDeleted: true,
+			},
+			resp:           []*store.SearchResult{{User: store.User{ID: "id-1", Country: "US"}, Score: 1.5}},
+			expQuery:       store.SearchQuery{Text: "jonny cash", Fuzzy: true, Countries: []string{"US"}},
+			expShowDeleted: true,
+			expResp: &pb.SearchUsersResponse{
+				Results: []*pb.SearchUsersResponse_Result{
+					{User: &pb.User{Id: "id-1", Country: "US"}, Score: 1.5},
+				},
+			},
+		},
+		{
+			desc:      "full page returns next page token",
+			req:       &pb.SearchUsersRequest{Query: "john", PageSize: 1, PageToken: encodePageToken("3")},
+			resp:      []*store.SearchResult{{User: store.User{ID: "id-1"}, Score: 2}},
+			expQuery:  store.SearchQuery{Text: "john"},
+			expOffset: 3,
+			expResp: &pb.SearchUsersResponse{
+				Results: []*pb.SearchUsersResponse_Result{
+					{User: &pb.User{Id: "id-1"}, Score: 2},
+				},
+				NextPageToken: encodePageToken("4"),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			searcher := &mockSearcher{resp: tC.resp, err: tC.searchErr}
+			ctx := tC.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			svc := New(&mockStore{}, &mockPublisher{}, WithSearcher(searcher))
+			resp, err := svc.SearchUsers(ctx, tC.req)
+			assertErrString(t, tC.expErr, err)
+			if err != nil {
+				return
+			}
+			opts := cmp.Options{
+				cmpopts.IgnoreUnexported(pb.SearchUsersResponse{}, pb.SearchUsersResponse_Result{}, pb.User{}),
+				cmpopts.IgnoreFields(pb.User{}, "UpdatedAt", "CreatedAt"),
+			}
+			if diff := cmp.Diff(tC.expResp, resp, opts); diff != "" {
+				t.Errorf("Response mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.expQuery, searcher.query); diff != "" {
+				t.Errorf("Query mismatch, diff: %s", diff)
+			}
+			if searcher.offset != tC.expOffset {
+				t.Errorf("Expected offset %d, got: %d", tC.expOffset, searcher.offset)
+			}
+			if searcher.showDeleted != tC.expShowDeleted {
+				t.Errorf("Expected show deleted %t, got: %t", tC.expShowDeleted, searcher.showDeleted)
+			}
+		})
+	}
+}
+
+func TestSearchUsersNotConfigured(t *testing.T) {
+	svc := New(&mockStore{}, &mockPublisher{})
+	_, err := svc.SearchUsers(context.Background(), &pb.SearchUsersRequest{Query: "john"})
+	assertErrString(t, "rpc error: code = Unimplemented desc = search is not configured", err)
+}
+
+type mockSearcher struct {
+	resp        []*store.SearchResult
+	err         error
+	query       store.SearchQuery
+	offset      int
+	showDeleted bool
+}
+
+func (m *mockSearcher) SearchUsers(_ context.Context, q store.SearchQuery, offset, _ int, opts ...store.ReadOption) ([]*store.SearchResult, error) {
+	m.query = q
+	m.offset = offset
+	m.showDeleted = len(opts) > 0
+	return m.resp, m.err
+}
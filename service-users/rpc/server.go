@@ -3,33 +3,74 @@ package rpc
 import (
 	"context"
 	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/filter"
 	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
 	"github.com/tobiaszheller/example-go-microservice/service-users/store"
 )
 
+const defaultMaxBatchSize = 100
+
 type server struct {
 	pb.UnimplementedUsersServer
 	storer          storer
 	eventsPublisher eventsPublisher
+	searcher        searcher
+	maxBatchSize    int
 }
 
-func New(storer storer, eventsPublisher eventsPublisher) *server {
-	return &server{
+// Option configures server.
+type Option func(*server)
+
+// WithMaxBatchSize sets max number of items in batch requests.
+func WithMaxBatchSize(n int) Option {
+	return func(s *server) {
+		s.maxBatchSize = n
+	}
+}
+
+// WithSearcher sets index used by SearchUsers. Without it SearchUsers is
+// unimplemented.
+func WithSearcher(searcher searcher) Option {
+	return func(s *server) {
+		s.searcher = searcher
+	}
+}
+
+func New(storer storer, eventsPublisher eventsPublisher, opts ...Option) *server {
+	s := &server{
 		storer:          storer,
 		eventsPublisher: eventsPublisher,
+		maxBatchSize:    defaultMaxBatchSize,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 type storer interface {
 	CreateUser(context.Context, *store.User) (*store.User, error)
 	UpdateUser(context.Context, *store.User) (*store.User, error)
-	GetUser(context.Context, string) (*store.User, error)
+	GetUser(context.Context, string, ...store.ReadOption) (*store.User, error)
+	ListUsers(ctx context.Context, filter store.ListUsersFilter, afterID string, limit int, opts ...store.ReadOption) ([]*store.User, error)
+	DeleteUser(context.Context, string) (*store.User, error)
+	UndeleteUser(context.Context, string) (*store.User, error)
+	ListUserHistory(ctx context.Context, userID string, beforeID int64, limit int) ([]*store.AuditEntry, error)
+	BatchGetUsers(context.Context, []string) ([]*store.User, error)
+	BatchCreateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchUpdateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchGetUsersByEmail(context.Context, []string) ([]*store.User, error)
+	GetUserData(context.Context, string) (*store.UserData, error)
+	EraseUser(ctx context.Context, id, reason string) (*store.Erasure, error)
 }
 
 type eventsPublisher interface {
@@ -45,7 +86,7 @@ func (s *server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb
 		if errors.Is(err, store.ErrUserAlreadyExists) {
 			return nil, grpc.Errorf(codes.AlreadyExists, "failed to create user: %v", err)
 		}
-		return nil, grpc.Errorf(codes.Internal, "failed to create user: %v", err)
+		return nil, grpc.Errorf(storeErrCode(err), "failed to create user: %v", err)
 	}
 	out := toPbUser(user)
 	if err := s.eventsPublisher.Publish(ctx, &pb.UserCreated{User: out}); err != nil {
@@ -63,6 +104,24 @@ func validateCreateUserRequest(req *pb.CreateUserRequest) error {
 	if req.GetUser().GetUpdatedAt() != nil {
 		eb.WriteString("'user.updated_at' cannot be provided,")
 	}
+	if req.GetUser().GetDeletedAt() != nil {
+		eb.WriteString("'user.deleted_at' cannot be provided,")
+	}
+	if req.GetUser().GetCreatedAt() != nil {
+		eb.WriteString("'user.created_at' cannot be provided,")
+	}
+	if req.GetUser().GetCreatedBy() != "" {
+		eb.WriteString("'user.created_by' cannot be provided,")
+	}
+	if req.GetUser().GetUpdatedBy() != "" {
+		eb.WriteString("'user.updated_by' cannot be provided,")
+	}
+	if req.GetUser().GetTenantId() != "" {
+		eb.WriteString("'user.tenant_id' cannot be provided,")
+	}
+	if req.GetUser().GetErasedAt() != nil {
+		eb.WriteString("'user.erased_at' cannot be provided,")
+	}
 	// TODO: check for valid email signiture.
 	if req.GetUser().GetEmail() == "" {
 		eb.WriteString("'user.email' must be provided,")
@@ -83,7 +142,10 @@ func (s *server) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb
 		if errors.Is(err, store.ErrUserNotFound) {
 			return nil, grpc.Errorf(codes.NotFound, "failed to update user: %v", err)
 		}
-		return nil, grpc.Errorf(codes.Internal, "failed to update user: %v", err)
+		if errors.Is(err, store.ErrUserAlreadyExists) {
+			return nil, grpc.Errorf(codes.AlreadyExists, "failed to update user: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to update user: %v", err)
 	}
 	out := toPbUser(user)
 	if err := s.eventsPublisher.Publish(ctx, &pb.UserUpdated{User: out}); err != nil {
@@ -101,6 +163,24 @@ func validateUpdateUserRequest(req *pb.UpdateUserRequest) error {
 	if req.GetUser().GetUpdatedAt() != nil {
 		eb.WriteString("'user.updated_at' cannot be provided,")
 	}
+	if req.GetUser().GetDeletedAt() != nil {
+		eb.WriteString("'user.deleted_at' cannot be provided,")
+	}
+	if req.GetUser().GetCreatedAt() != nil {
+		eb.WriteString("'user.created_at' cannot be provided,")
+	}
+	if req.GetUser().GetCreatedBy() != "" {
+		eb.WriteString("'user.created_by' cannot be provided,")
+	}
+	if req.GetUser().GetUpdatedBy() != "" {
+		eb.WriteString("'user.updated_by' cannot be provided,")
+	}
+	if req.GetUser().GetTenantId() != "" {
+		eb.WriteString("'user.tenant_id' cannot be provided,")
+	}
+	if req.GetUser().GetErasedAt() != nil {
+		eb.WriteString("'user.erased_at' cannot be provided,")
+	}
 	// TODO: check for valid email signiture.
 	if req.GetUser().GetEmail() == "" {
 		eb.WriteString("'user.email' must be provided,")
@@ -116,12 +196,19 @@ func (s *server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User,
 	if err := validateGetUserRequest(req); err != nil {
 		return nil, err
 	}
-	user, err := s.storer.GetUser(ctx, req.GetId())
+	var opts []store.ReadOption
+	if req.GetShowDeleted() {
+		if err := requireAdmin(ctx); err != nil {
+			return nil, err
+		}
+		opts = append(opts, store.WithDeleted())
+	}
+	user, err := s.storer.GetUser(ctx, req.GetId(), opts...)
 	if err != nil {
 		if errors.Is(err, store.ErrUserNotFound) {
 			return nil, grpc.Errorf(codes.NotFound, "failed to get user: %v", err)
 		}
-		return nil, grpc.Errorf(codes.Internal, "failed to get user: %v", err)
+		return nil, grpc.Errorf(storeErrCode(err), "failed to get user: %v", err)
 	}
 	return toPbUser(user), nil
 }
@@ -137,3 +224,160 @@ func validateGetUserRequest(req *pb.GetUserRequest) error {
 	}
 	return nil
 }
+
+func (s *server) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	after, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'page_token' %v,", err)
+	}
+	var opts []store.ReadOption
+	if req.GetShowDeleted() {
+		if err := requireAdmin(ctx); err != nil {
+			return nil, err
+		}
+		opts = append(opts, store.WithDeleted())
+	}
+	listFilter, err := parseListUsersFilter(req.GetFiltering(), req.GetFilter(), req.GetOrderBy())
+	if err != nil {
+		return nil, err
+	}
+	limit := pageSize(req.GetPageSize())
+	users, err := s.storer.ListUsers(ctx, listFilter, after, limit, opts...)
+	if err != nil {
+		if errors.Is(err, store.ErrFieldEncrypted) {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to list users: %v", err)
+	}
+	out := &pb.ListUsersResponse{}
+	for _, u := range users {
+		out.Users = append(out.Users, toPbUser(u))
+	}
+	if len(users) == limit {
+		out.NextPageToken = encodePageToken(users[len(users)-1].ID)
+	}
+	return out, nil
+}
+
+// parseListUsersFilter returns store filter combining filtering with AIP-160
+// filter and order by expressions.
+func parseListUsersFilter(filtering *pb.ListUsersRequest_Filtering, expr, orderBy string) (store.ListUsersFilter, error) {
+	out := store.ListUsersFilter{
+		Countries: filtering.GetCountries(),
+	}
+	var err error
+	out.Expr, err = filter.Parse(expr, store.UserFilterFields)
+	if err != nil {
+		return out, grpc.Errorf(codes.InvalidArgument, "invalid request: 'filter' %v,", err)
+	}
+	out.OrderBy, err = filter.ParseOrderBy(orderBy, store.UserOrderFields)
+	if err != nil {
+		return out, grpc.Errorf(codes.InvalidArgument, "invalid request: 'order_by' %v,", err)
+	}
+	return out, nil
+}
+
+func (s *server) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*empty.Empty, error) {
+	if req.GetId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'id' must be provided,")
+	}
+	user, err := s.storer.DeleteUser(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return nil, grpc.Errorf(codes.NotFound, "failed to delete user: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to delete user: %v", err)
+	}
+	if err := s.eventsPublisher.Publish(ctx, &pb.UserDeleted{User: toPbUser(user)}); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "failed to publish event: %v", err)
+	}
+	return &empty.Empty{}, nil
+}
+
+func (s *server) UndeleteUser(ctx context.Context, req *pb.UndeleteUserRequest) (*pb.User, error) {
+	if req.GetId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'id' must be provided,")
+	}
+	user, err := s.storer.UndeleteUser(ctx, req.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrUserNotFound):
+			return nil, grpc.Errorf(codes.NotFound, "failed to undelete user: %v", err)
+		case errors.Is(err, store.ErrUserNotDeleted):
+			return nil, grpc.Errorf(codes.FailedPrecondition, "failed to undelete user: %v", err)
+		case errors.Is(err, store.ErrUserAlreadyExists):
+			return nil, grpc.Errorf(codes.AlreadyExists, "failed to undelete user: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to undelete user: %v", err)
+	}
+	out := toPbUser(user)
+	if err := s.eventsPublisher.Publish(ctx, &pb.UserRestored{User: out}); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "failed to publish event: %v", err)
+	}
+	return out, nil
+}
+
+func (s *server) ListUserHistory(ctx context.Context, req *pb.ListUserHistoryRequest) (*pb.ListUserHistoryResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := validateListUserHistoryRequest(req); err != nil {
+		return nil, err
+	}
+	var before int64
+	if req.GetPageToken() != "" {
+		key, _ := decodePageToken(req.GetPageToken())
+		before, _ = strconv.ParseInt(key, 10, 64)
+	}
+	limit := pageSize(req.GetPageSize())
+	entries, err := s.storer.ListUserHistory(ctx, req.GetUserId(), before, limit)
+	if err != nil {
+		return nil, grpc.Errorf(storeErrCode(err), "failed to list user history: %v", err)
+	}
+	out := &pb.ListUserHistoryResponse{}
+	for _, e := range entries {
+		out.Entries = append(out.Entries, toPbUserHistoryEntry(e))
+	}
+	if len(entries) == limit {
+		out.NextPageToken = encodePageToken(strconv.FormatInt(entries[len(entries)-1].ID, 10))
+	}
+	return out, nil
+}
+
+func validateListUserHistoryRequest(req *pb.ListUserHistoryRequest) error {
+	// TODO: replace with better validation builder.
+	eb := strings.Builder{}
+	if req.GetUserId() == "" {
+		eb.WriteString("'user_id' must be provided,")
+	}
+	if req.GetPageToken() != "" {
+		key, err := decodePageToken(req.GetPageToken())
+		if _, perr := strconv.ParseInt(key, 10, 64); err != nil || perr != nil {
+			eb.WriteString("'page_token' malformed page token,")
+		}
+	}
+	if eb.String() != "" {
+		return grpc.Errorf(codes.InvalidArgument, "invalid request: %s", eb.String())
+	}
+	return nil
+}
+
+// storeErrCode returns code of unexpected store error. Transient errors
+// get codes which tell caller that request can be retried.
+func storeErrCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, store.ErrConflict):
+		return codes.Aborted
+	case errors.Is(err, store.ErrUnavailable):
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// requireAdmin returns error if caller has no administrative privileges.
+func requireAdmin(ctx context.Context) error {
+	if id, ok := auth.FromContext(ctx); !ok || !id.Admin {
+		return grpc.Errorf(codes.PermissionDenied, "admin privileges required")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"sync"
 	"testing"
@@ -12,6 +13,8 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/filter"
 	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
 	"github.com/tobiaszheller/example-go-microservice/service-users/store"
 )
@@ -84,6 +87,18 @@ func TestCreateUser(t *testing.T) {
 				hasError("rpc error: code = InvalidArgument desc = invalid request: 'user.id' cannot be provided,'user.updated_at' cannot be provided,'user.email' must be provided,"),
 			),
 		},
+		{
+			desc: "invalid req, actor fields provided",
+			req: &pb.CreateUserRequest{User: &pb.User{
+				Email:     "test@test.com",
+				CreatedAt: timestamppb.New(time.Now()),
+				CreatedBy: "admin",
+				UpdatedBy: "admin",
+			}},
+			checks: checks(
+				hasError("rpc error: code = InvalidArgument desc = invalid request: 'user.created_at' cannot be provided,'user.created_by' cannot be provided,'user.updated_by' cannot be provided,"),
+			),
+		},
 		{
 			desc: "valid req, already exists user with given email",
 			req: &pb.CreateUserRequest{User: &pb.User{
@@ -117,6 +132,9 @@ func TestCreateUser(t *testing.T) {
 				return &store.User{
 					ID:        "id-1",
 					UpdatedAt: time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+					CreatedAt: time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC),
+					CreatedBy: "importer",
+					UpdatedBy: "importer",
 				}, nil
 			},
 			checks: checks(
@@ -124,11 +142,17 @@ func TestCreateUser(t *testing.T) {
 				hasUser(&pb.User{
 					Id:        "id-1",
 					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
+					CreatedBy: "importer",
+					UpdatedBy: "importer",
 				}),
 				hasPublishedNEvents(1),
 				hasLastEvent(&pb.UserCreated{User: &pb.User{
 					Id:        "id-1",
 					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
+					CreatedBy: "importer",
+					UpdatedBy: "importer",
 				}}, cmpopts.IgnoreUnexported(pb.UserCreated{})),
 			),
 		},
@@ -200,6 +224,7 @@ func TestUpdateUser(t *testing.T) {
 				return &store.User{
 					ID:        "id-1",
 					UpdatedAt: time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+					CreatedAt: time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC),
 				}, nil
 			},
 			checks: checks(
@@ -207,11 +232,13 @@ func TestUpdateUser(t *testing.T) {
 				hasUser(&pb.User{
 					Id:        "id-1",
 					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
 				}),
 				hasPublishedNEvents(1),
 				hasLastEvent(&pb.UserUpdated{User: &pb.User{
 					Id:        "id-1",
 					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
 				}}, cmpopts.IgnoreUnexported(pb.UserUpdated{})),
 			),
 		},
@@ -278,6 +305,7 @@ func TestGetUser(t *testing.T) {
 				return &store.User{
 					ID:        "id-1",
 					UpdatedAt: time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+					CreatedAt: time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC),
 				}, nil
 			},
 			checks: checks(
@@ -285,6 +313,7 @@ func TestGetUser(t *testing.T) {
 				hasUser(&pb.User{
 					Id:        "id-1",
 					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
 				}),
 				hasPublishedNEvents(0),
 			),
@@ -305,10 +334,360 @@ func TestGetUser(t *testing.T) {
 	}
 }
 
+func TestDeleteUser(t *testing.T) {
+	deletedAt := time.Date(2020, 12, 11, 11, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc             string
+		deleteUserRespFn func() (*store.User, error)
+		req              *pb.DeleteUserRequest
+		checks           []check
+	}{
+		{
+			desc: "invalid req",
+			req:  &pb.DeleteUserRequest{},
+			checks: checks(
+				hasError("rpc error: code = InvalidArgument desc = invalid request: 'id' must be provided,"),
+			),
+		},
+		{
+			desc: "valid req, user not found",
+			req:  &pb.DeleteUserRequest{Id: "id-1"},
+			deleteUserRespFn: func() (*store.User, error) {
+				return nil, store.ErrUserNotFound
+			},
+			checks: checks(
+				hasError("rpc error: code = NotFound desc = failed to delete user: user not found"),
+				hasPublishedNEvents(0),
+			),
+		},
+		{
+			desc: "valid req, transaction conflict",
+			req:  &pb.DeleteUserRequest{Id: "id-1"},
+			deleteUserRespFn: func() (*store.User, error) {
+				return nil, store.ErrConflict
+			},
+			checks: checks(
+				hasError("rpc error: code = Aborted desc = failed to delete user: transaction conflict"),
+				hasPublishedNEvents(0),
+			),
+		},
+		{
+			desc: "valid req, db unavailable",
+			req:  &pb.DeleteUserRequest{Id: "id-1"},
+			deleteUserRespFn: func() (*store.User, error) {
+				return nil, store.ErrUnavailable
+			},
+			checks: checks(
+				hasError("rpc error: code = Unavailable desc = failed to delete user: database unavailable"),
+				hasPublishedNEvents(0),
+			),
+		},
+		{
+			desc: "valid req, user deleted",
+			req:  &pb.DeleteUserRequest{Id: "id-1"},
+			deleteUserRespFn: func() (*store.User, error) {
+				return &store.User{
+					ID:        "id-1",
+					UpdatedAt: time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+					CreatedAt: time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC),
+					DeletedAt: sql.NullTime{Time: deletedAt, Valid: true},
+				}, nil
+			},
+			checks: checks(
+				hasNoError(),
+				hasPublishedNEvents(1),
+				hasLastEvent(&pb.UserDeleted{User: &pb.User{
+					Id:        "id-1",
+					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
+					DeletedAt: timestamppb.New(deletedAt),
+				}}, cmpopts.IgnoreUnexported(pb.UserDeleted{})),
+			),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			store := &mockStore{
+				deleteUserRespFn: tC.deleteUserRespFn,
+			}
+			eventsPublisher := &mockPublisher{}
+			svc := New(store, eventsPublisher)
+			_, err := svc.DeleteUser(context.Background(), tC.req)
+			for _, ch := range tC.checks {
+				ch(nil, eventsPublisher, err, t)
+			}
+		})
+	}
+}
+
+func TestUndeleteUser(t *testing.T) {
+	testCases := []struct {
+		desc               string
+		undeleteUserRespFn func() (*store.User, error)
+		req                *pb.UndeleteUserRequest
+		checks             []check
+	}{
+		{
+			desc: "valid req, user is not deleted",
+			req:  &pb.UndeleteUserRequest{Id: "id-1"},
+			undeleteUserRespFn: func() (*store.User, error) {
+				return nil, store.ErrUserNotDeleted
+			},
+			checks: checks(
+				hasError("rpc error: code = FailedPrecondition desc = failed to undelete user: user is not deleted"),
+			),
+		},
+		{
+			desc: "valid req, email taken by other user",
+			req:  &pb.UndeleteUserRequest{Id: "id-1"},
+			undeleteUserRespFn: func() (*store.User, error) {
+				return nil, store.ErrUserAlreadyExists
+			},
+			checks: checks(
+				hasError("rpc error: code = AlreadyExists desc = failed to undelete user: user already exists"),
+			),
+		},
+		{
+			desc: "valid req, user restored",
+			req:  &pb.UndeleteUserRequest{Id: "id-1"},
+			undeleteUserRespFn: func() (*store.User, error) {
+				return &store.User{
+					ID:        "id-1",
+					UpdatedAt: time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+					CreatedAt: time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC),
+				}, nil
+			},
+			checks: checks(
+				hasNoError(),
+				hasUser(&pb.User{
+					Id:        "id-1",
+					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
+				}),
+				hasPublishedNEvents(1),
+				hasLastEvent(&pb.UserRestored{User: &pb.User{
+					Id:        "id-1",
+					UpdatedAt: timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+					CreatedAt: timestamppb.New(time.Date(2020, 12, 1, 11, 0, 0, 0, time.UTC)),
+				}}, cmpopts.IgnoreUnexported(pb.UserRestored{})),
+			),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			store := &mockStore{
+				undeleteUserRespFn: tC.undeleteUserRespFn,
+			}
+			eventsPublisher := &mockPublisher{}
+			svc := New(store, eventsPublisher)
+			resp, err := svc.UndeleteUser(context.Background(), tC.req)
+			for _, ch := range tC.checks {
+				ch(resp, eventsPublisher, err, t)
+			}
+		})
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	adminCtx := auth.NewContext(context.Background(), auth.Identity{Subject: "admin", Admin: true})
+	testCases := []struct {
+		desc           string
+		ctx            context.Context
+		req            *pb.ListUsersRequest
+		listUsersResp  []*store.User
+		expFilter      store.ListUsersFilter
+		expShowDeleted bool
+		expResp        *pb.ListUsersResponse
+		expErr         string
+	}{
+		{
+			desc:   "invalid page token",
+			req:    &pb.ListUsersRequest{PageToken: "!"},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'page_token' malformed page token,",
+		},
+		{
+			desc:   "invalid filter",
+			req:    &pb.ListUsersRequest{Filter: `country = "US" AND age > 30`},
+			expErr: `rpc error: code = InvalidArgument desc = invalid request: 'filter' column 20: unknown field "age",`,
+		},
+		{
+			desc:   "invalid order by",
+			req:    &pb.ListUsersRequest{OrderBy: "country descending"},
+			expErr: `rpc error: code = InvalidArgument desc = invalid request: 'order_by' column 9: expected "asc" or "desc", got "descending",`,
+		},
+		{
+			desc: "filter and order by",
+			req: &pb.ListUsersRequest{
+				Filtering: &pb.ListUsersRequest_Filtering{Countries: []string{"PL", "US"}},
+				Filter:    `country = "US"`,
+				OrderBy:   "updated_at desc",
+			},
+			listUsersResp: []*store.User{{ID: "id-1", Country: "US"}},
+			expFilter: store.ListUsersFilter{
+				Countries: []string{"PL", "US"},
+				Expr:      filter.Comparison{Field: "country", Op: "=", Value: "US"},
+				OrderBy:   []filter.OrderField{{Field: "updated_at", Desc: true}},
+			},
+			expResp: &pb.ListUsersResponse{
+				Users: []*pb.User{{Id: "id-1", Country: "US"}},
+			},
+		},
+		{
+			desc:   "deleted users requested by non admin",
+			ctx:    auth.NewContext(context.Background(), auth.Identity{Subject: "user"}),
+			req:    &pb.ListUsersRequest{ShowDeleted: true},
+			expErr: "rpc error: code = PermissionDenied desc = admin privileges required",
+		},
+		{
+			desc: "filtered by countries with deleted users, last page",
+			ctx:  adminCtx,
+			req: &pb.ListUsersRequest{
+				Filtering:   &pb.ListUsersRequest_Filtering{Countries: []string{"PL"}},
+				ShowDeleted: true,
+			},
+			listUsersResp:  []*store.User{{ID: "id-1", Country: "PL"}},
+			expFilter:      store.ListUsersFilter{Countries: []string{"PL"}},
+			expShowDeleted: true,
+			expResp: &pb.ListUsersResponse{
+				Users: []*pb.User{{Id: "id-1", Country: "PL"}},
+			},
+		},
+		{
+			desc:          "full page returns next page token",
+			req:           &pb.ListUsersRequest{PageSize: 1},
+			listUsersResp: []*store.User{{ID: "id-1"}},
+			expResp: &pb.ListUsersResponse{
+				Users:         []*pb.User{{Id: "id-1"}},
+				NextPageToken: encodePageToken("id-1"),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			store := &mockStore{
+				listUsersResp: tC.listUsersResp,
+			}
+			ctx := tC.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			svc := New(store, &mockPublisher{})
+			resp, err := svc.ListUsers(ctx, tC.req)
+			assertErrString(t, tC.expErr, err)
+			if err != nil {
+				return
+			}
+			opts := cmp.Options{
+				cmpopts.IgnoreUnexported(pb.ListUsersResponse{}, pb.User{}),
+				cmpopts.IgnoreFields(pb.User{}, "UpdatedAt", "CreatedAt"),
+			}
+			if diff := cmp.Diff(tC.expResp, resp, opts); diff != "" {
+				t.Errorf("Response mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.expFilter, store.listUsersFilter); diff != "" {
+				t.Errorf("Filter mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.expShowDeleted, store.listUsersOpts.showDeleted); diff != "" {
+				t.Errorf("Show deleted mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestListUserHistory(t *testing.T) {
+	adminCtx := auth.NewContext(context.Background(), auth.Identity{Subject: "admin", Admin: true})
+	createdAt := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc        string
+		ctx         context.Context
+		req         *pb.ListUserHistoryRequest
+		historyResp []*store.AuditEntry
+		expBefore   int64
+		expResp     *pb.ListUserHistoryResponse
+		expErr      string
+	}{
+		{
+			desc:   "caller is not admin",
+			ctx:    context.Background(),
+			req:    &pb.ListUserHistoryRequest{UserId: "id-1"},
+			expErr: "rpc error: code = PermissionDenied desc = admin privileges required",
+		},
+		{
+			desc:   "invalid req",
+			ctx:    adminCtx,
+			req:    &pb.ListUserHistoryRequest{PageToken: encodePageToken("not-a-number")},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'user_id' must be provided,'page_token' malformed page token,",
+		},
+		{
+			desc: "full page returns next page token",
+			ctx:  adminCtx,
+			req: &pb.ListUserHistoryRequest{
+				UserId:    "id-1",
+				PageSize:  1,
+				PageToken: encodePageToken("10"),
+			},
+			historyResp: []*store.AuditEntry{{
+				ID:        7,
+				UserID:    "id-1",
+				Action:    store.AuditActionUpdate,
+				Actor:     "support",
+				RequestID: "req-1",
+				Changes:   store.FieldChanges{{Field: "email", Before: "old@test.com", After: "new@test.com"}},
+				CreatedAt: createdAt,
+			}},
+			expBefore: 10,
+			expResp: &pb.ListUserHistoryResponse{
+				Entries: []*pb.UserHistoryEntry{{
+					Id:        "7",
+					UserId:    "id-1",
+					Action:    pb.UserHistoryEntry_UPDATE,
+					Actor:     "support",
+					RequestId: "req-1",
+					Changes: []*pb.UserHistoryEntry_FieldChange{
+						{Field: "email", Before: "old@test.com", After: "new@test.com"},
+					},
+					CreatedAt: timestamppb.New(createdAt),
+				}},
+				NextPageToken: encodePageToken("7"),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			store := &mockStore{
+				historyResp: tC.historyResp,
+			}
+			svc := New(store, &mockPublisher{})
+			resp, err := svc.ListUserHistory(tC.ctx, tC.req)
+			assertErrString(t, tC.expErr, err)
+			opts := cmpopts.IgnoreUnexported(pb.ListUserHistoryResponse{}, pb.UserHistoryEntry{}, pb.UserHistoryEntry_FieldChange{}, timestamppb.Timestamp{})
+			if diff := cmp.Diff(tC.expResp, resp, opts); diff != "" {
+				t.Errorf("Response mismatch, diff: %s", diff)
+			}
+			if diff := cmp.Diff(tC.expBefore, store.historyBefore); diff != "" {
+				t.Errorf("Before id mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
 type mockStore struct {
-	createUserRespFn func() (*store.User, error)
-	updateUserRespFn func() (*store.User, error)
-	getUserRespFn    func() (*store.User, error)
+	createUserRespFn   func() (*store.User, error)
+	updateUserRespFn   func() (*store.User, error)
+	getUserRespFn      func() (*store.User, error)
+	deleteUserRespFn   func() (*store.User, error)
+	undeleteUserRespFn func() (*store.User, error)
+	listUsersResp      []*store.User
+	listUsersFilter    store.ListUsersFilter
+	listUsersOpts      mockReadOptions
+	historyResp        []*store.AuditEntry
+	historyBefore      int64
+	batchGetResp       []*store.User
+	batchWriteFn       func(in []*store.User, atomic bool) ([]store.BatchResult, error)
+	batchWriteIn       []*store.User
+	byEmailResp        []*store.User
+	userDataRespFn     func() (*store.UserData, error)
+	eraseUserRespFn    func() (*store.Erasure, error)
 }
 
 func (m *mockStore) CreateUser(context.Context, *store.User) (*store.User, error) {
@@ -319,10 +698,61 @@ func (m *mockStore) UpdateUser(context.Context, *store.User) (*store.User, error
 	return m.updateUserRespFn()
 }
 
-func (m *mockStore) GetUser(context.Context, string) (*store.User, error) {
+func (m *mockStore) GetUser(context.Context, string, ...store.ReadOption) (*store.User, error) {
 	return m.getUserRespFn()
 }
 
+func (m *mockStore) ListUsers(_ context.Context, filter store.ListUsersFilter, _ string, _ int, opts ...store.ReadOption) ([]*store.User, error) {
+	m.listUsersFilter = filter
+	m.listUsersOpts = mockReadOptions{showDeleted: len(opts) > 0}
+	return m.listUsersResp, nil
+}
+
+func (m *mockStore) DeleteUser(context.Context, string) (*store.User, error) {
+	return m.deleteUserRespFn()
+}
+
+func (m *mockStore) UndeleteUser(context.Context, string) (*store.User, error) {
+	return m.undeleteUserRespFn()
+}
+
+func (m *mockStore) ListUserHistory(_ context.Context, _ string, beforeID int64, _ int) ([]*store.AuditEntry, error) {
+	m.historyBefore = beforeID
+	return m.historyResp, nil
+}
+
+func (m *mockStore) BatchGetUsers(context.Context, []string) ([]*store.User, error) {
+	return m.batchGetResp, nil
+}
+
+func (m *mockStore) BatchCreateUsers(_ context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error) {
+	m.batchWriteIn = in
+	return m.batchWriteFn(in, atomic)
+}
+
+func (m *mockStore) BatchUpdateUsers(_ context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error) {
+	m.batchWriteIn = in
+	return m.batchWriteFn(in, atomic)
+}
+
+func (m *mockStore) BatchGetUsersByEmail(context.Context, []string) ([]*store.User, error) {
+	return m.byEmailResp, nil
+}
+
+func (m *mockStore) GetUserData(context.Context, string) (*store.UserData, error) {
+	return m.userDataRespFn()
+}
+
+func (m *mockStore) EraseUser(context.Context, string, string) (*store.Erasure, error) {
+	return m.eraseUserRespFn()
+}
+
+// mockReadOptions records read options passed to store, they are opaque
+// outside of store so only their presence is checked.
+type mockReadOptions struct {
+	showDeleted bool
+}
+
 type mockPublisher struct {
 	mu     sync.Mutex
 	events []proto.Message
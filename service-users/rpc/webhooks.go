@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/webhooks"
+)
+
+const minWebhookSecretLen = 16
+
+type webhooksServer struct {
+	pb.UnimplementedWebhooksServer
+	storer webhooksStorer
+}
+
+func NewWebhooks(storer webhooksStorer) *webhooksServer {
+	return &webhooksServer{
+		storer: storer,
+	}
+}
+
+type webhooksStorer interface {
+	CreateWebhookSubscription(context.Context, *store.WebhookSubscription) (*store.WebhookSubscription, error)
+	GetWebhookSubscription(context.Context, string) (*store.WebhookSubscription, error)
+	DeleteWebhookSubscription(context.Context, string) error
+	ListWebhookSubscriptions(ctx context.Context, afterID string, limit int) ([]*store.WebhookSubscription, error)
+	GetWebhookDelivery(context.Context, string) (*store.WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, subscriptionID, status, afterID string, limit int) ([]*store.WebhookDelivery, error)
+	ListWebhookDeliveryAttempts(context.Context, string) ([]*store.WebhookDeliveryAttempt, error)
+}
+
+func (s *webhooksServer) CreateWebhookSubscription(ctx context.Context, req *pb.CreateWebhookSubscriptionRequest) (*pb.WebhookSubscription, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := validateCreateWebhookSubscriptionRequest(req); err != nil {
+		return nil, err
+	}
+	sub, err := s.storer.CreateWebhookSubscription(ctx, toStoreWebhookSubscription(req.GetSubscription()))
+	if err != nil {
+		return nil, grpc.Errorf(storeErrCode(err), "failed to create webhook subscription: %v", err)
+	}
+	return toPbWebhookSubscription(sub), nil
+}
+
+func validateCreateWebhookSubscriptionRequest(req *pb.CreateWebhookSubscriptionRequest) error {
+	// TODO: replace with better validation builder.
+	eb := strings.Builder{}
+	sub := req.GetSubscription()
+	if sub.GetId() != "" {
+		eb.WriteString("'subscription.id' cannot be provided,")
+	}
+	if sub.GetCreatedAt() != nil {
+		eb.WriteString("'subscription.created_at' cannot be provided,")
+	}
+	if u, err := url.Parse(sub.GetUrl()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		eb.WriteString("'subscription.url' must be valid http or https URL,")
+	} else if err := webhooks.CheckTarget(u); err != nil {
+		eb.WriteString("'subscription.url' must point to public address: " + err.Error() + ",")
+	}
+	for _, et := range sub.GetEventTypes() {
+		if !isKnownEventType(et) {
+			eb.WriteString("'subscription.event_types' contains unknown event type '" + et + "',")
+		}
+	}
+	if len(sub.GetSecret()) < minWebhookSecretLen {
+		eb.WriteString("'subscription.secret' must have at least 16 characters,")
+	}
+	if eb.String() != "" {
+		return grpc.Errorf(codes.InvalidArgument, "invalid request: %s", eb.String())
+	}
+	return nil
+}
+
+func isKnownEventType(eventType string) bool {
+	for _, et := range webhooks.EventTypes {
+		if et == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *webhooksServer) GetWebhookSubscription(ctx context.Context, req *pb.GetWebhookSubscriptionRequest) (*pb.WebhookSubscription, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'id' must be provided,")
+	}
+	sub, err := s.storer.GetWebhookSubscription(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, store.ErrWebhookSubscriptionNotFound) {
+			return nil, grpc.Errorf(codes.NotFound, "failed to get webhook subscription: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to get webhook subscription: %v", err)
+	}
+	return toPbWebhookSubscription(sub), nil
+}
+
+func (s *webhooksServer) DeleteWebhookSubscription(ctx context.Context, req *pb.DeleteWebhookSubscriptionRequest) (*empty.Empty, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'id' must be provided,")
+	}
+	if err := s.storer.DeleteWebhookSubscription(ctx, req.GetId()); err != nil {
+		if errors.Is(err, store.ErrWebhookSubscriptionNotFound) {
+			return nil, grpc.Errorf(codes.NotFound, "failed to delete webhook subscription: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to delete webhook subscription: %v", err)
+	}
+	return &empty.Empty{}, nil
+}
+
+func (s *webhooksServer) ListWebhookSubscriptions(ctx context.Context, req *pb.ListWebhookSubscriptionsRequest) (*pb.ListWebhookSubscriptionsResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	after, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'page_token' %v,", err)
+	}
+	limit := pageSize(req.GetPageSize())
+	subs, err := s.storer.ListWebhookSubscriptions(ctx, after, limit)
+	if err != nil {
+		return nil, grpc.Errorf(storeErrCode(err), "failed to list webhook subscriptions: %v", err)
+	}
+	out := &pb.ListWebhookSubscriptionsResponse{}
+	for _, sub := range subs {
+		out.Subscriptions = append(out.Subscriptions, toPbWebhookSubscription(sub))
+	}
+	if len(subs) == limit {
+		out.NextPageToken = encodePageToken(subs[len(subs)-1].ID)
+	}
+	return out, nil
+}
+
+func (s *webhooksServer) GetWebhookDelivery(ctx context.Context, req *pb.GetWebhookDeliveryRequest) (*pb.WebhookDelivery, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid request: 'id' must be provided,")
+	}
+	delivery, err := s.storer.GetWebhookDelivery(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, store.ErrWebhookDeliveryNotFound) {
+			return nil, grpc.Errorf(codes.NotFound, "failed to get webhook delivery: %v", err)
+		}
+		return nil, grpc.Errorf(storeErrCode(err), "failed to get webhook delivery: %v", err)
+	}
+	attempts, err := s.storer.ListWebhookDeliveryAttempts(ctx, req.GetId())
+	if err != nil {
+		return nil, grpc.Errorf(storeErrCode(err), "failed to get webhook delivery attempts: %v", err)
+	}
+	out := toPbWebhookDelivery(delivery)
+	for _, a := range attempts {
+		out.Attempts = append(out.Attempts, toPbWebhookDeliveryAttempt(a))
+	}
+	return out, nil
+}
+
+func (s *webhooksServer) ListWebhookDeliveries(ctx context.Context, req *pb.ListWebhookDeliveriesRequest) (*pb.ListWebhookDeliveriesResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := validateListWebhookDeliveriesRequest(req); err != nil {
+		return nil, err
+	}
+	after, _ := decodePageToken(req.GetPageToken())
+	limit := pageSize(req.GetPageSize())
+	deliveries, err := s.storer.ListWebhookDeliveries(ctx, req.GetSubscriptionId(), toStoreWebhookDeliveryStatus(req.GetStatus()), after, limit)
+	if err != nil {
+		return nil, grpc.Errorf(storeErrCode(err), "failed to list webhook deliveries: %v", err)
+	}
+	out := &pb.ListWebhookDeliveriesResponse{}
+	for _, d := range deliveries {
+		out.Deliveries = append(out.Deliveries, toPbWebhookDelivery(d))
+	}
+	if len(deliveries) == limit {
+		out.NextPageToken = encodePageToken(deliveries[len(deliveries)-1].ID)
+	}
+	return out, nil
+}
+
+func validateListWebhookDeliveriesRequest(req *pb.ListWebhookDeliveriesRequest) error {
+	// TODO: replace with better validation builder.
+	eb := strings.Builder{}
+	if req.GetSubscriptionId() == "" {
+		eb.WriteString("'subscription_id' must be provided,")
+	}
+	if _, err := decodePageToken(req.GetPageToken()); err != nil {
+		eb.WriteString("'page_token' " + err.Error() + ",")
+	}
+	if eb.String() != "" {
+		return grpc.Errorf(codes.InvalidArgument, "invalid request: %s", eb.String())
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func TestWebhooksRequireAdmin(t *testing.T) {
+	svc := NewWebhooks(&mockWebhooksStore{})
+	ctx := auth.NewContext(context.Background(), auth.Identity{Subject: "svc", Tenant: "acme"})
+	calls := map[string]func() error{
+		"CreateWebhookSubscription": func() error {
+			_, err := svc.CreateWebhookSubscription(ctx, &pb.CreateWebhookSubscriptionRequest{})
+			return err
+		},
+		"GetWebhookSubscription": func() error {
+			_, err := svc.GetWebhookSubscription(ctx, &pb.GetWebhookSubscriptionRequest{Id: "sub-1"})
+			return err
+		},
+		"DeleteWebhookSubscription": func() error {
+			_, err := svc.DeleteWebhookSubscription(ctx, &pb.DeleteWebhookSubscriptionRequest{Id: "sub-1"})
+			return err
+		},
+		"ListWebhookSubscriptions": func() error {
+			_, err := svc.ListWebhookSubscriptions(ctx, &pb.ListWebhookSubscriptionsRequest{})
+			return err
+		},
+		"GetWebhookDelivery": func() error {
+			_, err := svc.GetWebhookDelivery(ctx, &pb.GetWebhookDeliveryRequest{Id: "delivery-1"})
+			return err
+		},
+		"ListWebhookDeliveries": func() error {
+			_, err := svc.ListWebhookDeliveries(ctx, &pb.ListWebhookDeliveriesRequest{SubscriptionId: "sub-1"})
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			assertErrString(t, "rpc error: code = PermissionDenied desc = admin privileges required", call())
+		})
+	}
+}
+
+func TestCreateWebhookSubscription(t *testing.T) {
+	adminCtx := auth.NewContext(context.Background(), auth.Identity{Subject: "admin", Admin: true})
+	testCases := []struct {
+		desc         string
+		createRespFn func() (*store.WebhookSubscription, error)
+		req          *pb.CreateWebhookSubscriptionRequest
+		expErr       string
+		expResp      *pb.WebhookSubscription
+	}{
+		{
+			desc: "invalid req",
+			req: &pb.CreateWebhookSubscriptionRequest{Subscription: &pb.WebhookSubscription{
+				Id:         "id",
+				Url:        "ftp://example.com",
+				EventTypes: []string{"UserCreated", "UserRenamed"},
+				Secret:     "short",
+			}},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'subscription.id' cannot be provided,'subscription.url' must be valid http or https URL,'subscription.event_types' contains unknown event type 'UserRenamed','subscription.secret' must have at least 16 characters,",
+		},
+		{
+			desc: "metadata service url",
+			req: &pb.CreateWebhookSubscriptionRequest{Subscription: &pb.WebhookSubscription{
+				Url:    "http://169.254.169.254/latest/meta-data",
+				Secret: "0123456789abcdef",
+			}},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'subscription.url' must point to public address: address 169.254.169.254 is not allowed,",
+		},
+		{
+			desc: "valid req, store err",
+			req: &pb.CreateWebhookSubscriptionRequest{Subscription: &pb.WebhookSubscription{
+				Url:    "https://example.com/hook",
+				Secret: "0123456789abcdef",
+			}},
+			createRespFn: func() (*store.WebhookSubscription, error) {
+				return nil, fmt.Errorf("some err")
+			},
+			expErr: "rpc error: code = Internal desc = failed to create webhook subscription: some err",
+		},
+		{
+			desc: "valid req, subscription created without secret in response",
+			req: &pb.CreateWebhookSubscriptionRequest{Subscription: &pb.WebhookSubscription{
+				Url:        "https://example.com/hook",
+				EventTypes: []string{"UserCreated"},
+				Secret:     "0123456789abcdef",
+			}},
+			createRespFn: func() (*store.WebhookSubscription, error) {
+				return &store.WebhookSubscription{
+					ID:         "sub-1",
+					URL:        "https://example.com/hook",
+					EventTypes: []string{"UserCreated"},
+					Secret:     "0123456789abcdef",
+					CreatedAt:  time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+				}, nil
+			},
+			expResp: &pb.WebhookSubscription{
+				Id:         "sub-1",
+				Url:        "https://example.com/hook",
+				EventTypes: []string{"UserCreated"},
+				CreatedAt:  timestamppb.New(time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			svc := NewWebhooks(&mockWebhooksStore{createRespFn: tC.createRespFn})
+			resp, err := svc.CreateWebhookSubscription(adminCtx, tC.req)
+			assertErrString(t, tC.expErr, err)
+			if diff := cmp.Diff(tC.expResp, resp, cmpopts.IgnoreUnexported(pb.WebhookSubscription{}, timestamppb.Timestamp{})); diff != "" {
+				t.Errorf("Subscription mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestListWebhookDeliveries(t *testing.T) {
+	adminCtx := auth.NewContext(context.Background(), auth.Identity{Subject: "admin", Admin: true})
+	testCases := []struct {
+		desc    string
+		req     *pb.ListWebhookDeliveriesRequest
+		listed  []*store.WebhookDelivery
+		expErr  string
+		expResp *pb.ListWebhookDeliveriesResponse
+	}{
+		{
+			desc:   "invalid req",
+			req:    &pb.ListWebhookDeliveriesRequest{PageToken: "!"},
+			expErr: "rpc error: code = InvalidArgument desc = invalid request: 'subscription_id' must be provided,'page_token' malformed page token,",
+		},
+		{
+			desc: "full page returns next page token",
+			req:  &pb.ListWebhookDeliveriesRequest{SubscriptionId: "sub-1", PageSize: 1},
+			listed: []*store.WebhookDelivery{
+				{ID: "delivery-1", SubscriptionID: "sub-1", Status: store.WebhookDeliverySucceeded, AttemptCount: 1},
+			},
+			expResp: &pb.ListWebhookDeliveriesResponse{
+				Deliveries: []*pb.WebhookDelivery{
+					{Id: "delivery-1", SubscriptionId: "sub-1", Status: pb.WebhookDelivery_SUCCEEDED, AttemptCount: 1},
+				},
+				NextPageToken: encodePageToken("delivery-1"),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			svc := NewWebhooks(&mockWebhooksStore{listDeliveries: tC.listed})
+			resp, err := svc.ListWebhookDeliveries(adminCtx, tC.req)
+			assertErrString(t, tC.expErr, err)
+			opts := cmp.Options{
+				cmpopts.IgnoreUnexported(pb.ListWebhookDeliveriesResponse{}, pb.WebhookDelivery{}),
+				cmpopts.IgnoreFields(pb.WebhookDelivery{}, "CreatedAt", "UpdatedAt"),
+			}
+			if diff := cmp.Diff(tC.expResp, resp, opts); diff != "" {
+				t.Errorf("Response mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func assertErrString(t *testing.T, exp string, err error) {
+	t.Helper()
+	got := ""
+	if err != nil {
+		got = err.Error()
+	}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("Error mismatch, diff: %s", diff)
+	}
+}
+
+type mockWebhooksStore struct {
+	createRespFn   func() (*store.WebhookSubscription, error)
+	listDeliveries []*store.WebhookDelivery
+}
+
+func (m *mockWebhooksStore) CreateWebhookSubscription(context.Context, *store.WebhookSubscription) (*store.WebhookSubscription, error) {
+	return m.createRespFn()
+}
+
+func (m *mockWebhooksStore) GetWebhookSubscription(context.Context, string) (*store.WebhookSubscription, error) {
+	return nil, store.ErrWebhookSubscriptionNotFound
+}
+
+func (m *mockWebhooksStore) DeleteWebhookSubscription(context.Context, string) error {
+	return nil
+}
+
+func (m *mockWebhooksStore) ListWebhookSubscriptions(context.Context, string, int) ([]*store.WebhookSubscription, error) {
+	return nil, nil
+}
+
+func (m *mockWebhooksStore) GetWebhookDelivery(context.Context, string) (*store.WebhookDelivery, error) {
+	return nil, store.ErrWebhookDeliveryNotFound
+}
+
+func (m *mockWebhooksStore) ListWebhookDeliveries(context.Context, string, string, string, int) ([]*store.WebhookDelivery, error) {
+	return m.listDeliveries, nil
+}
+
+func (m *mockWebhooksStore) ListWebhookDeliveryAttempts(context.Context, string) ([]*store.WebhookDeliveryAttempt, error) {
+	return nil, nil
+}
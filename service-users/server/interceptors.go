@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/deadline"
+	"github.com/tobiaszheller/example-go-microservice/service-users/loadshed"
+	"github.com/tobiaszheller/example-go-microservice/service-users/ratelimit"
+	"github.com/tobiaszheller/example-go-microservice/service-users/requestid"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+// interceptors returns chains of interceptors configured by o. Calls are
+// rate limited by limiter.
+func interceptors(o *options, limiter *ratelimit.Limiter) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	if o.authenticator == nil {
+		log.Warn("Authentication is disabled, callers have no administrative privileges")
+	}
+	unary := []grpc.UnaryServerInterceptor{
+		requestid.UnaryServerInterceptor(),
+		storeSessionInterceptor,
+		grpc_logrus.UnaryServerInterceptor(log.NewEntry(log.StandardLogger())),
+		auth.UnaryServerInterceptor(o.authenticator),
+		tenant.UnaryServerInterceptor(),
+		ratelimit.UnaryServerInterceptor(limiter),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		requestid.StreamServerInterceptor(),
+		storeSessionStreamInterceptor,
+		grpc_logrus.StreamServerInterceptor(log.NewEntry(log.StandardLogger())),
+		auth.StreamServerInterceptor(o.authenticator),
+		tenant.StreamServerInterceptor(),
+		ratelimit.StreamServerInterceptor(limiter),
+	}
+	if o.loadShedding != nil {
+		shedder := loadshed.New(*o.loadShedding, o.poolStats)
+		unary = append(unary, loadshed.UnaryServerInterceptor(shedder))
+		stream = append(stream, loadshed.StreamServerInterceptor(shedder))
+	}
+	unary = append(unary, deadline.UnaryServerInterceptor(o.timeouts))
+	stream = append(stream, deadline.StreamServerInterceptor(o.timeouts))
+	return unary, stream
+}
+
+// storeSessionInterceptor makes reads following writes of the same request
+// go to primary DB.
+func storeSessionInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(store.NewSessionContext(ctx), req)
+}
+
+func storeSessionStreamInterceptor(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	wrapped := grpc_middleware.WrapServerStream(stream)
+	wrapped.WrappedContext = store.NewSessionContext(stream.Context())
+	return handler(srv, wrapped)
+}
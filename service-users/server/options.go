@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/deadline"
+	"github.com/tobiaszheller/example-go-microservice/service-users/loadshed"
+	"github.com/tobiaszheller/example-go-microservice/service-users/ratelimit"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+type usersStorer interface {
+	CreateUser(context.Context, *store.User) (*store.User, error)
+	UpdateUser(context.Context, *store.User) (*store.User, error)
+	GetUser(context.Context, string, ...store.ReadOption) (*store.User, error)
+	ListUsers(ctx context.Context, filter store.ListUsersFilter, afterID string, limit int, opts ...store.ReadOption) ([]*store.User, error)
+	DeleteUser(context.Context, string) (*store.User, error)
+	UndeleteUser(context.Context, string) (*store.User, error)
+	ListUserHistory(ctx context.Context, userID string, beforeID int64, limit int) ([]*store.AuditEntry, error)
+	BatchGetUsers(context.Context, []string) ([]*store.User, error)
+	BatchCreateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchUpdateUsers(ctx context.Context, in []*store.User, atomic bool) ([]store.BatchResult, error)
+	BatchGetUsersByEmail(context.Context, []string) ([]*store.User, error)
+	GetUserData(context.Context, string) (*store.UserData, error)
+	EraseUser(ctx context.Context, id, reason string) (*store.Erasure, error)
+}
+
+type storer interface {
+	usersStorer
+	SearchUsers(ctx context.Context, q store.SearchQuery, offset, limit int, opts ...store.ReadOption) ([]*store.SearchResult, error)
+	CreateWebhookSubscription(context.Context, *store.WebhookSubscription) (*store.WebhookSubscription, error)
+	GetWebhookSubscription(context.Context, string) (*store.WebhookSubscription, error)
+	DeleteWebhookSubscription(context.Context, string) error
+	ListWebhookSubscriptions(ctx context.Context, afterID string, limit int) ([]*store.WebhookSubscription, error)
+	GetWebhookDelivery(context.Context, string) (*store.WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, subscriptionID, status, afterID string, limit int) ([]*store.WebhookDelivery, error)
+	ListWebhookDeliveryAttempts(context.Context, string) ([]*store.WebhookDeliveryAttempt, error)
+}
+
+type eventsPublisher interface {
+	Publish(context.Context, proto.Message) error
+}
+
+type options struct {
+	addr              string
+	lis               net.Listener
+	tls               *tls.Config
+	telemetryAddr     string
+	telemetryHandlers map[string]http.Handler
+	shutdownTimeout   time.Duration
+
+	storer       storer
+	cache        usersStorer
+	publisher    eventsPublisher
+	maxBatchSize int
+	services     []func(*grpc.Server)
+	workers      []func(context.Context) error
+
+	authenticator auth.Authenticator
+	limits        ratelimit.Limits
+	timeouts      deadline.Timeouts
+	loadShedding  *loadshed.Config
+	poolStats     func() sql.DBStats
+}
+
+// Option configures Server.
+type Option func(*options)
+
+// WithAddr sets address on which gRPC API is served, ":18082" by default.
+func WithAddr(addr string) Option {
+	return func(o *options) {
+		o.addr = addr
+	}
+}
+
+// WithListener makes gRPC API served on lis instead of address, e.g. on
+// bufconn in tests.
+func WithListener(lis net.Listener) Option {
+	return func(o *options) {
+		o.lis = lis
+	}
+}
+
+// WithTLS makes gRPC API served over TLS. Without it traffic is plain text.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+// WithTelemetry makes health checks and metrics served on addr.
+func WithTelemetry(addr string) Option {
+	return func(o *options) {
+		o.telemetryAddr = addr
+	}
+}
+
+// WithTelemetryHandler serves h at pattern of telemetry server, along with
+// health checks and metrics.
+func WithTelemetryHandler(pattern string, h http.Handler) Option {
+	return func(o *options) {
+		if o.telemetryHandlers == nil {
+			o.telemetryHandlers = map[string]http.Handler{}
+		}
+		o.telemetryHandlers[pattern] = h
+	}
+}
+
+// WithShutdownTimeout sets how long calls in progress are waited for when
+// server is stopped, 10s by default.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.shutdownTimeout = d
+	}
+}
+
+// WithStore makes server serve Users and Webhooks services backed by s.
+// Publisher has to be set as well.
+func WithStore(s storer) Option {
+	return func(o *options) {
+		o.storer = s
+	}
+}
+
+// WithCache makes Users service use c, e.g. cache.Storer, in front of
+// store. c has to pass through to store given to WithStore.
+func WithCache(c usersStorer) Option {
+	return func(o *options) {
+		o.cache = c
+	}
+}
+
+// WithPublisher sets publisher of events about changes of users.
+func WithPublisher(p eventsPublisher) Option {
+	return func(o *options) {
+		o.publisher = p
+	}
+}
+
+// WithMaxBatchSize sets max number of items in batch requests.
+func WithMaxBatchSize(n int) Option {
+	return func(o *options) {
+		o.maxBatchSize = n
+	}
+}
+
+// WithService registers additional service on gRPC server, calls of which
+// go through the same interceptors.
+func WithService(register func(*grpc.Server)) Option {
+	return func(o *options) {
+		o.services = append(o.services, register)
+	}
+}
+
+// WithWorker runs fn along with server, until its context is cancelled on
+// shutdown. Failure of fn stops server.
+func WithWorker(fn func(context.Context) error) Option {
+	return func(o *options) {
+		o.workers = append(o.workers, fn)
+	}
+}
+
+// WithAuthenticator sets authenticator of callers. Without it
+// authentication is disabled and all callers are auth.Anonymous, without
+// administrative privileges.
+func WithAuthenticator(a auth.Authenticator) Option {
+	return func(o *options) {
+		o.authenticator = a
+	}
+}
+
+// WithRateLimits limits rate of calls per client. Limits can be changed
+// later with Server.SetRateLimits.
+func WithRateLimits(limits ratelimit.Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// WithDeadlines sets default and max deadlines of calls.
+func WithDeadlines(timeouts deadline.Timeouts) Option {
+	return func(o *options) {
+		o.timeouts = timeouts
+	}
+}
+
+// WithLoadShedding enables rejecting calls when service is overloaded. Pool
+// of DB described by poolStats is watched as well.
+func WithLoadShedding(cfg loadshed.Config, poolStats func() sql.DBStats) Option {
+	return func(o *options) {
+		o.loadShedding = &cfg
+		o.poolStats = poolStats
+	}
+}
@@ -0,0 +1,214 @@
+// Package server runs users service: gRPC API with interceptors shared by
+// all its entry points, telemetry endpoints and background workers, until
+// it is stopped. It is configured with options, so it can be reused, e.g. by
+// main and in-process test harness, and it returns errors instead of
+// exiting.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/ratelimit"
+	"github.com/tobiaszheller/example-go-microservice/service-users/rpc"
+	"github.com/tobiaszheller/example-go-microservice/service-users/telemetry"
+)
+
+const (
+	defaultAddr            = ":18082"
+	defaultMaxBatchSize    = 100
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+// Server serves users service.
+type Server struct {
+	grpc            *grpc.Server
+	addr            string
+	lis             net.Listener
+	telemetry       *http.Server
+	workers         []func(context.Context) error
+	shutdownTimeout time.Duration
+	limiter         *ratelimit.Limiter
+}
+
+// New returns server configured by opts. Users and Webhooks services are
+// registered only if store is set, other services can be registered with
+// WithService.
+func New(opts ...Option) (*Server, error) {
+	o := &options{
+		addr:            defaultAddr,
+		maxBatchSize:    defaultMaxBatchSize,
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	limiter := ratelimit.New(o.limits)
+	unary, stream := interceptors(o, limiter)
+	serverOpts := []grpc.ServerOption{
+		grpc_middleware.WithUnaryServerChain(unary...),
+		grpc_middleware.WithStreamServerChain(stream...),
+	}
+	if o.tls != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(o.tls)))
+	} else {
+		log.Warn("TLS is disabled, gRPC traffic is not encrypted")
+	}
+	// TODO: in real life implementation following options shoud be passed:
+	// - interceptor for passing trace_id from incomming request
+	// - interceptor for panic recovey
+	grpcServer := grpc.NewServer(serverOpts...)
+	if o.storer != nil {
+		var users usersStorer = o.storer
+		if o.cache != nil {
+			users = o.cache
+		}
+		pb.RegisterUsersServer(grpcServer, rpc.New(users, o.publisher,
+			rpc.WithMaxBatchSize(o.maxBatchSize),
+			rpc.WithSearcher(o.storer),
+		))
+		pb.RegisterWebhooksServer(grpcServer, rpc.NewWebhooks(o.storer))
+	}
+	for _, register := range o.services {
+		register(grpcServer)
+	}
+	grpc_prometheus.Register(grpcServer)
+
+	s := &Server{
+		grpc:            grpcServer,
+		addr:            o.addr,
+		lis:             o.lis,
+		workers:         o.workers,
+		shutdownTimeout: o.shutdownTimeout,
+		limiter:         limiter,
+	}
+	if o.telemetryAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/", telemetry.Handler())
+		for pattern, h := range o.telemetryHandlers {
+			mux.Handle(pattern, h)
+		}
+		s.telemetry = &http.Server{Addr: o.telemetryAddr, Handler: mux}
+	}
+	return s, nil
+}
+
+// SetRateLimits replaces limits of calls per client, set initially by
+// WithRateLimits, while server is running.
+func (s *Server) SetRateLimits(limits ratelimit.Limits) {
+	s.limiter.SetLimits(limits)
+}
+
+func (o *options) validate() error {
+	if o.storer != nil && o.publisher == nil {
+		return errors.New("publisher is required to serve users")
+	}
+	if o.cache != nil && o.storer == nil {
+		return errors.New("cache requires store")
+	}
+	if o.maxBatchSize <= 0 {
+		return fmt.Errorf("max batch size must be positive, got %d", o.maxBatchSize)
+	}
+	return nil
+}
+
+// Run serves gRPC API and telemetry endpoints and runs workers until ctx is
+// done or any of them fails. Then calls in progress are given shutdown
+// timeout to complete, workers are cancelled and Run returns first failure,
+// if any.
+func (s *Server) Run(ctx context.Context) error {
+	lis := s.lis
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", s.addr); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failure  error
+	)
+	fail := func(err error) {
+		failOnce.Do(func() { failure = err })
+		cancel()
+	}
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	run(func() {
+		log.Infof("Starting gRPC server at: %s", lis.Addr())
+		if err := s.grpc.Serve(lis); err != nil && ctx.Err() == nil {
+			fail(fmt.Errorf("gRPC server failed: %w", err))
+		}
+	})
+	if s.telemetry != nil {
+		run(func() {
+			log.Infof("Starting telemetry server at: %s", s.telemetry.Addr)
+			if err := s.telemetry.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fail(fmt.Errorf("telemetry server failed: %w", err))
+			}
+		})
+	}
+	for _, worker := range s.workers {
+		worker := worker
+		run(func() {
+			if err := worker(ctx); err != nil && ctx.Err() == nil {
+				fail(fmt.Errorf("worker failed: %w", err))
+			}
+		})
+	}
+
+	<-ctx.Done()
+	log.Info("Shutting down")
+	s.shutdown()
+	wg.Wait()
+	return failure
+}
+
+// shutdown stops gRPC server gracefully, cancelling calls still in progress
+// after shutdown timeout, and stops telemetry server.
+func (s *Server) shutdown() {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(s.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		log.Warn("Shutdown timeout passed, cancelling calls in progress")
+		s.grpc.Stop()
+	}
+	if s.telemetry != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+		if err := s.telemetry.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("Failed to shut down telemetry server")
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+)
+
+type fakeStorer struct {
+	storer
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		opts   []Option
+		expErr string
+	}{
+		{
+			desc: "no options",
+		},
+		{
+			desc:   "store without publisher",
+			opts:   []Option{WithStore(fakeStorer{})},
+			expErr: "publisher is required to serve users",
+		},
+		{
+			desc:   "cache without store",
+			opts:   []Option{WithCache(fakeStorer{})},
+			expErr: "cache requires store",
+		},
+		{
+			desc:   "invalid max batch size",
+			opts:   []Option{WithMaxBatchSize(0)},
+			expErr: "max batch size must be positive, got 0",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := New(tC.opts...)
+			if tC.expErr == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tC.expErr != "" && (err == nil || err.Error() != tC.expErr) {
+				t.Errorf("Expected error %q, got: %v", tC.expErr, err)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	tokens, err := auth.ParseStaticTokens("secret=svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	workerStopped := make(chan struct{})
+	srv, err := New(
+		WithListener(lis),
+		WithAuthenticator(tokens),
+		WithService(func(s *grpc.Server) {
+			healthpb.RegisterHealthServer(s, health.NewServer())
+		}),
+		WithWorker(func(ctx context.Context) error {
+			<-ctx.Done()
+			close(workerStopped)
+			return ctx.Err()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- srv.Run(ctx) }()
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	cli := healthpb.NewHealthClient(conn)
+	// Registered service goes through the same interceptors.
+	if _, err := cli.Check(ctx, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated, got: %v", err)
+	}
+	authCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	if _, err := cli.Check(authCtx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after context was cancelled")
+	}
+	select {
+	case <-workerStopped:
+	default:
+		t.Error("Expected worker to be stopped")
+	}
+}
+
+func TestRunWorkerFailure(t *testing.T) {
+	errWorker := errors.New("worker error")
+	srv, err := New(
+		WithListener(bufconn.Listen(1<<20)),
+		WithWorker(func(context.Context) error { return errWorker }),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errWorker) {
+			t.Errorf("Expected worker error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after worker failed")
+	}
+}
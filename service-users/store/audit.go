@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/auth"
+	"github.com/tobiaszheller/example-go-microservice/service-users/requestid"
+)
+
+// Actions recorded in audit log.
+const (
+	AuditActionCreate   = "create"
+	AuditActionUpdate   = "update"
+	AuditActionDelete   = "delete"
+	AuditActionUndelete = "undelete"
+	AuditActionPurge    = "purge"
+	// AuditActionErase entry has no changes, as personal data of all other
+	// entries is erased along with user.
+	AuditActionErase = "erase"
+)
+
+// systemActor is recorded when change was not made on behalf of any caller,
+// e.g. by background jobs.
+const systemActor = "system"
+
+// AuditEntry represents single change of user.
+type AuditEntry struct {
+	ID        int64        `db:"id"`
+	TenantID  string       `db:"tenant_id"`
+	UserID    string       `db:"user_id"`
+	Action    string       `db:"action"`
+	Actor     string       `db:"actor"`
+	RequestID string       `db:"request_id"`
+	Changes   FieldChanges `db:"changes"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+// FieldChange describes change of single user field.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	// Encrypted is set if Before and After are sealed with data key of
+	// user. ListUserHistory returns them decrypted.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// FieldChanges is list of changes stored as JSON column.
+type FieldChanges []FieldChange
+
+func (c FieldChanges) Value() (driver.Value, error) {
+	if c == nil {
+		c = FieldChanges{}
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (c *FieldChanges) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	}
+	return fmt.Errorf("cannot scan %T into FieldChanges", src)
+}
+
+// ListUserHistory returns up to limit audit entries of user, newest first,
+// starting before entry with given id. Zero beforeID starts from the newest.
+func (s *store) ListUserHistory(ctx context.Context, userID string, beforeID int64, limit int) ([]*AuditEntry, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*AuditEntry
+	if err := s.db.SelectContext(ctx, &out, s.dialect.rebind(querySelectAuditEntries), tenantID, userID, beforeID, beforeID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list user history: %w", err)
+	}
+	if !hasEncryptedChanges(out) {
+		return out, nil
+	}
+	key, err := s.userDataKey(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range out {
+		if err := openChanges(e.Changes, userID, key); err != nil {
+			return nil, fmt.Errorf("failed to decrypt audit entry %d: %w", e.ID, err)
+		}
+	}
+	return out, nil
+}
+
+func hasEncryptedChanges(entries []*AuditEntry) bool {
+	for _, e := range entries {
+		for _, c := range e.Changes {
+			if c.Encrypted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userDataKey returns unwrapped data key of user, or nil if user was
+// purged, erased or is not encrypted.
+func (s *store) userDataKey(ctx context.Context, tenantID, userID string) ([]byte, error) {
+	var row piiColumns
+	if err := s.db.GetContext(ctx, &row, s.dialect.rebind(querySelectUserKey), userID, tenantID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+	if row.PIIKeyID == "" {
+		return nil, nil
+	}
+	if s.enc == nil {
+		return nil, fmt.Errorf("user %s is encrypted, but encryption is not enabled", userID)
+	}
+	key, err := s.enc.keys.Unwrap(ctx, row.PIIKeyID, row.PIIKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of user %s: %w", userID, err)
+	}
+	return key, nil
+}
+
+// audit records change of user from before to after state within tx.
+// Actor and request id are taken from ctx, tenant from user. If user is
+// encrypted, changes of its PII fields are sealed with its data key.
+func audit(ctx context.Context, tx *sqlx.Tx, action string, before, after *User, dataKey []byte) error {
+	entry := &AuditEntry{
+		Action:    action,
+		Actor:     actorFromContext(ctx),
+		RequestID: requestid.FromContext(ctx),
+		Changes:   diffUsers(before, after),
+		CreatedAt: time.Now().UTC(),
+	}
+	if after != nil {
+		entry.UserID, entry.TenantID = after.ID, after.TenantID
+	} else {
+		entry.UserID, entry.TenantID = before.ID, before.TenantID
+	}
+	if dataKey != nil {
+		if err := sealChanges(entry.Changes, entry.UserID, dataKey); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.NamedExecContext(ctx, queryInsertAuditEntry, entry); err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// actorFromContext returns subject of authenticated caller or systemActor
+// if change is not made on behalf of any caller.
+func actorFromContext(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		return id.Subject
+	}
+	return systemActor
+}
+
+// diffUsers returns changed fields between before and after state,
+// nil state is treated as user with all fields empty.
+func diffUsers(before, after *User) FieldChanges {
+	if before == nil {
+		before = &User{}
+	}
+	if after == nil {
+		after = &User{}
+	}
+	var out FieldChanges
+	add := func(field, b, a string) {
+		if b != a {
+			out = append(out, FieldChange{Field: field, Before: b, After: a})
+		}
+	}
+	add("first_name", before.FirstName, after.FirstName)
+	add("last_name", before.LastName, after.LastName)
+	add("nickname", before.Nickname, after.Nickname)
+	add("email", before.Email, after.Email)
+	add("country", before.Country, after.Country)
+	add("deleted_at", formatNullTime(before.DeletedAt.Time, before.DeletedAt.Valid), formatNullTime(after.DeletedAt.Time, after.DeletedAt.Valid))
+	return out
+}
+
+func formatNullTime(t time.Time, valid bool) string {
+	if !valid {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
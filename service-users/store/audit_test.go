@@ -0,0 +1,57 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffUsers(t *testing.T) {
+	deletedAt := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc   string
+		before *User
+		after  *User
+		exp    FieldChanges
+	}{
+		{
+			desc:  "created user",
+			after: &User{ID: "id-1", FirstName: "Johnny", Email: "johnny@test.com", Country: "US"},
+			exp: FieldChanges{
+				{Field: "first_name", After: "Johnny"},
+				{Field: "email", After: "johnny@test.com"},
+				{Field: "country", After: "US"},
+			},
+		},
+		{
+			desc:   "email changed",
+			before: &User{ID: "id-1", FirstName: "Johnny", Email: "johnny@test.com"},
+			after:  &User{ID: "id-1", FirstName: "Johnny", Email: "john@test.com", UpdatedAt: deletedAt},
+			exp: FieldChanges{
+				{Field: "email", Before: "johnny@test.com", After: "john@test.com"},
+			},
+		},
+		{
+			desc:   "deleted user",
+			before: &User{ID: "id-1"},
+			after:  &User{ID: "id-1", DeletedAt: sql.NullTime{Time: deletedAt, Valid: true}},
+			exp: FieldChanges{
+				{Field: "deleted_at", After: "2020-12-10T11:00:00Z"},
+			},
+		},
+		{
+			desc:   "nothing changed",
+			before: &User{ID: "id-1", Nickname: "johnny"},
+			after:  &User{ID: "id-1", Nickname: "johnny"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tC.exp, diffUsers(tC.before, tC.after)); diff != "" {
+				t.Errorf("Changes mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrBatchAborted is error of items of atomic batch which were not written
+// because other item failed.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchResult is result of single item of batch write, either User or Err
+// is set.
+type BatchResult struct {
+	User *User
+	Err  error
+}
+
+// BatchGetUsers returns not deleted users with given ids, in no particular
+// order. Missing users are skipped.
+func (s *store) BatchGetUsers(ctx context.Context, ids []string) ([]*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(fmt.Sprintf(querySelectUsers, "tenant_id = ? AND id IN (?) AND deleted_at IS NULL"), tenantID, ids, len(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build batch get query: %w", err)
+	}
+	var rows []*userRow
+	err = s.read(ctx, "BatchGetUsers", readOptions{}, func(db *sqlx.DB) error {
+		return db.SelectContext(ctx, &rows, s.dialect.rebind(query), args...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", wrapTransient(err))
+	}
+	return s.openUsers(ctx, rows)
+}
+
+// BatchGetUsersByEmail returns not deleted users with given emails, in no
+// particular order. Users are read from primary, as they are used to decide
+// about writes.
+func (s *store) BatchGetUsersByEmail(ctx context.Context, emails []string) ([]*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	keys, _ := s.activeEmailKeys(emails)
+	query, args, err := sqlx.In(fmt.Sprintf(querySelectUsers, "tenant_id = ? AND active_email IN (?)"), tenantID, keys, len(emails))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build batch get query: %w", err)
+	}
+	var rows []*userRow
+	err = s.read(ctx, "BatchGetUsersByEmail", readOptions{primary: true}, func(db *sqlx.DB) error {
+		return db.SelectContext(ctx, &rows, s.dialect.rebind(query), args...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", wrapTransient(err))
+	}
+	return s.openUsers(ctx, rows)
+}
+
+// BatchCreateUsers creates users with single insert. Results are in order
+// of input. In atomic mode nothing is created if any user fails.
+// Error is returned only if whole batch failed.
+func (s *store) BatchCreateUsers(ctx context.Context, in []*User, atomic bool) ([]BatchResult, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	actor := actorFromContext(ctx)
+	for _, u := range in {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate uuid: %w", err)
+		}
+		u.ID = id.String()
+		u.TenantID = tenantID
+		u.CreatedAt = now
+		u.CreatedBy = actor
+		u.UpdatedAt = now
+		u.UpdatedBy = actor
+	}
+	out := make([]BatchResult, len(in))
+	err = s.inTx(ctx, func(tx *sqlx.Tx) error {
+		// Transaction can be retried, results of previous attempt are
+		// discarded.
+		for i := range out {
+			out[i] = BatchResult{}
+		}
+		taken, err := s.emailOwners(ctx, tx, tenantID, in)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		var (
+			valid []*User
+			rows  []*userRow
+		)
+		for i, u := range in {
+			email := strings.ToLower(u.Email)
+			if _, ok := taken[email]; ok || seen[email] {
+				out[i].Err = ErrUserAlreadyExists
+				continue
+			}
+			seen[email] = true
+			row, err := s.seal(ctx, u, nil)
+			if err != nil {
+				return err
+			}
+			out[i].User = u
+			valid = append(valid, u)
+			rows = append(rows, row)
+		}
+		if len(valid) == 0 || (atomic && len(valid) < len(in)) {
+			abortBatch(out)
+			return nil
+		}
+		if err := s.insertUsers(ctx, tx, rows); err != nil {
+			return err
+		}
+		for i, u := range valid {
+			if err := audit(ctx, tx, AuditActionCreate, nil, u, rows[i].dataKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BatchUpdateUsers updates users with single update. Users must have unique
+// ids. Results are in order of input. In atomic mode nothing is updated if
+// any user fails. Error is returned only if whole batch failed.
+func (s *store) BatchUpdateUsers(ctx context.Context, in []*User, atomic bool) ([]BatchResult, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	actor := actorFromContext(ctx)
+	out := make([]BatchResult, len(in))
+	err = s.inTx(ctx, func(tx *sqlx.Tx) error {
+		// Transaction can be retried, results of previous attempt are
+		// discarded.
+		for i := range out {
+			out[i] = BatchResult{}
+		}
+		before, err := s.getUsersForUpdate(ctx, tx, tenantID, in)
+		if err != nil {
+			return err
+		}
+		taken, err := s.emailOwners(ctx, tx, tenantID, in)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		var (
+			valid []*User
+			rows  []*userRow
+		)
+		for i, u := range in {
+			b, ok := before[u.ID]
+			if !ok || b.DeletedAt.Valid {
+				out[i].Err = ErrUserNotFound
+				continue
+			}
+			email := strings.ToLower(u.Email)
+			if owner, ok := taken[email]; (ok && owner != u.ID) || seen[email] {
+				out[i].Err = ErrUserAlreadyExists
+				continue
+			}
+			seen[email] = true
+			u.TenantID = tenantID
+			u.UpdatedAt = now
+			u.UpdatedBy = actor
+			u.CreatedAt = b.CreatedAt
+			u.CreatedBy = b.CreatedBy
+			row, err := s.seal(ctx, u, b)
+			if err != nil {
+				return err
+			}
+			out[i].User = u
+			valid = append(valid, u)
+			rows = append(rows, row)
+		}
+		if len(valid) == 0 || (atomic && len(valid) < len(in)) {
+			abortBatch(out)
+			return nil
+		}
+		if err := s.updateUsers(ctx, tx, tenantID, rows, now, actor); err != nil {
+			return err
+		}
+		for i, u := range valid {
+			if err := audit(ctx, tx, AuditActionUpdate, &before[u.ID].User, u, rows[i].dataKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// abortBatch fails all items which did not fail on their own.
+func abortBatch(results []BatchResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		}
+	}
+}
+
+// emailOwners returns ids of not deleted users of tenant by lower cased
+// emails of given users. Emails are matched both in plaintext and by blind
+// index, so users which are not encrypted yet are found as well.
+func (s *store) emailOwners(ctx context.Context, tx *sqlx.Tx, tenantID string, users []*User) (map[string]string, error) {
+	emails := make([]string, 0, len(users))
+	for _, u := range users {
+		emails = append(emails, u.Email)
+	}
+	keys, byKey := s.activeEmailKeys(emails)
+	query, args, err := sqlx.In(querySelectActiveEmails, tenantID, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build emails query: %w", err)
+	}
+	var rows []struct {
+		ID          string `db:"id"`
+		ActiveEmail string `db:"active_email"`
+	}
+	if err := tx.SelectContext(ctx, &rows, s.dialect.rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to check emails: %w", err)
+	}
+	out := make(map[string]string, len(rows))
+	for _, r := range rows {
+		out[byKey[strings.ToLower(r.ActiveEmail)]] = r.ID
+	}
+	return out, nil
+}
+
+// getUsersForUpdate locks and returns decrypted users of tenant by id.
+func (s *store) getUsersForUpdate(ctx context.Context, tx *sqlx.Tx, tenantID string, users []*User) (map[string]*userRow, error) {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	query, args, err := sqlx.In(querySelectUsersByIdsForUpdate, ids, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+	var rows []*userRow
+	if err := tx.SelectContext(ctx, &rows, s.dialect.rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	out := make(map[string]*userRow, len(rows))
+	for _, r := range rows {
+		if err := s.open(ctx, r); err != nil {
+			return nil, err
+		}
+		out[r.ID] = r
+	}
+	return out, nil
+}
+
+func (s *store) insertUsers(ctx context.Context, tx *sqlx.Tx, rows []*userRow) error {
+	values := make([]string, 0, len(rows))
+	args := make([]interface{}, 0, len(rows)*17)
+	for _, r := range rows {
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, r.ID, r.TenantID, r.FirstName, r.LastName, r.Nickname, r.Email, r.Country, r.UpdatedAt, r.CreatedAt, r.CreatedBy, r.UpdatedBy,
+			r.PIIKeyID, r.PIIKey, r.FirstNameEnc, r.LastNameEnc, r.EmailEnc, r.EmailIndex)
+	}
+	query := fmt.Sprintf(queryInsertUsers, strings.Join(values, ",\n\t"))
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), args...); err != nil {
+		if isDuplicateEntryErr(err) {
+			return ErrUserAlreadyExists
+		}
+		return fmt.Errorf("failed to insert users: %w", err)
+	}
+	return nil
+}
+
+// updateUsers sets editable fields of all users with single statement.
+func (s *store) updateUsers(ctx context.Context, tx *sqlx.Tx, tenantID string, rows []*userRow, updatedAt time.Time, updatedBy string) error {
+	var (
+		sets []string
+		args []interface{}
+	)
+	for _, col := range []struct {
+		name   string
+		binary bool
+		value  func(*userRow) interface{}
+	}{
+		{"first_name", false, func(r *userRow) interface{} { return r.FirstName }},
+		{"last_name", false, func(r *userRow) interface{} { return r.LastName }},
+		{"nickname", false, func(r *userRow) interface{} { return r.Nickname }},
+		{"email", false, func(r *userRow) interface{} { return r.Email }},
+		{"country", false, func(r *userRow) interface{} { return r.Country }},
+		{"pii_key_id", false, func(r *userRow) interface{} { return r.PIIKeyID }},
+		{"pii_key", true, func(r *userRow) interface{} { return r.PIIKey }},
+		{"first_name_enc", true, func(r *userRow) interface{} { return r.FirstNameEnc }},
+		{"last_name_enc", true, func(r *userRow) interface{} { return r.LastNameEnc }},
+		{"email_enc", true, func(r *userRow) interface{} { return r.EmailEnc }},
+		{"email_index", false, func(r *userRow) interface{} { return r.EmailIndex }},
+	} {
+		param := "?"
+		if col.binary {
+			param = s.dialect.binaryParam
+		}
+		b := strings.Builder{}
+		b.WriteString(col.name + " = CASE id")
+		for _, r := range rows {
+			b.WriteString(" WHEN ? THEN " + param)
+			args = append(args, r.ID, col.value(r))
+		}
+		b.WriteString(" END")
+		sets = append(sets, b.String())
+	}
+	sets = append(sets, "updated_at = ?", "updated_by = ?")
+	args = append(args, updatedAt, updatedBy)
+	ids := make([]string, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.ID)
+	}
+	query, args, err := sqlx.In(fmt.Sprintf(queryUpdateUsers, strings.Join(sets, ",\n\t")), append(args, ids, tenantID)...)
+	if err != nil {
+		return fmt.Errorf("failed to build update query: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), args...); err != nil {
+		if isDuplicateEntryErr(err) {
+			return ErrUserAlreadyExists
+		}
+		return fmt.Errorf("failed to update users: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// dialect holds what differs between databases supported by store. Queries
+// are written with ? placeholders and rebound to bind type of driver, so
+// only statements which cannot be shared are kept here.
+type dialect struct {
+	// driverName is name of database/sql driver, it selects bind type.
+	driverName string
+	// binaryParam is placeholder of binary value whose type cannot be
+	// inferred from statement, e.g. result of CASE.
+	binaryParam string
+	// lockRows is false if database does not support SELECT ... FOR UPDATE,
+	// e.g. because it locks whole database on write anyway.
+	lockRows bool
+	// likeEscape is ESCAPE clause of LIKE patterns built by likePattern,
+	// empty if backslash is escape character by default.
+	likeEscape string
+
+	querySelectWebhookSubscriptionsByEventType string
+	queryDeleteUserWebhookDeliveries           string
+
+	// textSearch returns score expression of users matching q and condition
+	// selecting them, along with their args. Condition is empty if q cannot
+	// match anything.
+	textSearch func(q SearchQuery) (score string, scoreArgs []interface{}, cond string, condArgs []interface{})
+}
+
+var mysqlDialect = &dialect{
+	driverName:  "mysql",
+	binaryParam: "?",
+	lockRows:    true,
+
+	querySelectWebhookSubscriptionsByEventType: querySelectWebhookSubscriptionsByEventType,
+	queryDeleteUserWebhookDeliveries:           queryDeleteUserWebhookDeliveries,
+
+	textSearch: mysqlTextSearch,
+}
+
+var postgresDialect = &dialect{
+	driverName:  "postgres",
+	binaryParam: "?::bytea",
+	lockRows:    true,
+
+	querySelectWebhookSubscriptionsByEventType: queryPostgresSelectWebhookSubscriptionsByEventType,
+	queryDeleteUserWebhookDeliveries:           queryPostgresDeleteUserWebhookDeliveries,
+
+	textSearch: postgresTextSearch,
+}
+
+// sqliteLikeEscape makes backslash escape character of LIKE, which SQLite
+// has none by default.
+const sqliteLikeEscape = ` ESCAPE '\'`
+
+var sqliteDialect = &dialect{
+	driverName:  "sqlite",
+	binaryParam: "?",
+	lockRows:    false,
+	likeEscape:  sqliteLikeEscape,
+
+	querySelectWebhookSubscriptionsByEventType: querySQLiteSelectWebhookSubscriptionsByEventType,
+	queryDeleteUserWebhookDeliveries:           querySQLiteDeleteUserWebhookDeliveries,
+
+	textSearch: sqliteTextSearch,
+}
+
+// rebind returns query written with ? placeholders in form accepted by
+// database, without row locks if database does not support them.
+func (d *dialect) rebind(query string) string {
+	if !d.lockRows {
+		query = strings.Replace(query, "\nFOR UPDATE", "", 1)
+	}
+	return sqlx.Rebind(sqlx.BindType(d.driverName), query)
+}
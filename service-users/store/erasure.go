@@ -0,0 +1,207 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/requestid"
+)
+
+// ErrUserErased is returned when user is already erased.
+var ErrUserErased = errors.New("user is already erased")
+
+// personalFields are user fields which are anonymized in audit log on
+// erasure.
+var personalFields = map[string]bool{
+	"first_name": true,
+	"last_name":  true,
+	"nickname":   true,
+	"email":      true,
+	"country":    true,
+}
+
+// UserEvent is published event about user. Data of events is not kept, it
+// can be reconstructed from audit log.
+type UserEvent struct {
+	ID        string    `db:"id"`
+	TenantID  string    `db:"tenant_id"`
+	UserID    string    `db:"user_id"`
+	Type      string    `db:"type"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Erasure is receipt of erasure of user.
+type Erasure struct {
+	ID        string    `db:"id"`
+	TenantID  string    `db:"tenant_id"`
+	UserID    string    `db:"user_id"`
+	Reason    string    `db:"reason"`
+	Actor     string    `db:"actor"`
+	RequestID string    `db:"request_id"`
+	ErasedAt  time.Time `db:"erased_at"`
+}
+
+// UserData is all data stored about user.
+type UserData struct {
+	// User is nil if user was purged.
+	User     *User
+	AuditLog []*AuditEntry
+	Events   []*UserEvent
+	Erasures []*Erasure
+}
+
+// CreateUserEvent records event published about user of tenant from
+// context.
+func (s *store) CreateUserEvent(ctx context.Context, in *UserEvent) error {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	in.TenantID = tenantID
+	if _, err := s.db.NamedExecContext(ctx, queryInsertUserEvent, in); err != nil {
+		return fmt.Errorf("failed to insert user event: %w", err)
+	}
+	return nil
+}
+
+// GetUserData returns all data stored about user, including deleted and
+// purged one. It fails with ErrUserNotFound if there is none.
+func (s *store) GetUserData(ctx context.Context, id string) (*UserData, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := &UserData{}
+	user, err := s.GetUser(ctx, id, WithDeleted(), FromPrimary())
+	switch {
+	case err == nil:
+		out.User = user
+	case !errors.Is(err, ErrUserNotFound):
+		return nil, err
+	}
+	// History is read in pages, as it is decrypted by ListUserHistory.
+	var before int64
+	for {
+		entries, err := s.ListUserHistory(ctx, id, before, 1000)
+		if err != nil {
+			return nil, err
+		}
+		out.AuditLog = append(out.AuditLog, entries...)
+		if len(entries) < 1000 {
+			break
+		}
+		before = entries[len(entries)-1].ID
+	}
+	if err := s.db.SelectContext(ctx, &out.Events, s.dialect.rebind(querySelectUserEvents), tenantID, id); err != nil {
+		return nil, fmt.Errorf("failed to list user events: %w", err)
+	}
+	if err := s.db.SelectContext(ctx, &out.Erasures, s.dialect.rebind(querySelectErasures), tenantID, id); err != nil {
+		return nil, fmt.Errorf("failed to list erasures: %w", err)
+	}
+	if out.User == nil && len(out.AuditLog) == 0 && len(out.Events) == 0 {
+		return nil, ErrUserNotFound
+	}
+	return out, nil
+}
+
+// EraseUser irreversibly anonymizes user and its audit log and removes
+// webhook deliveries of events about it, which contain its personal data.
+// Erased user is deleted, it is later purged like other deleted users.
+// It works also for already purged users, whose audit log is left. Receipt
+// of erasure is recorded and returned.
+func (s *store) EraseUser(ctx context.Context, id, reason string) (*Erasure, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	receiptID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	out := &Erasure{
+		ID:        receiptID.String(),
+		TenantID:  tenantID,
+		UserID:    id,
+		Reason:    reason,
+		Actor:     actorFromContext(ctx),
+		RequestID: requestid.FromContext(ctx),
+		ErasedAt:  time.Now().UTC(),
+	}
+	err = s.inTx(ctx, func(tx *sqlx.Tx) error {
+		user, err := s.getUserForUpdate(ctx, tx, tenantID, id)
+		switch {
+		case err == nil:
+			if user.ErasedAt.Valid {
+				return ErrUserErased
+			}
+			if _, err := tx.ExecContext(ctx, s.dialect.rebind(queryEraseUser), out.ErasedAt, out.ErasedAt, out.ErasedAt, out.Actor, id, tenantID); err != nil {
+				return fmt.Errorf("failed to erase user: %w", err)
+			}
+		case !errors.Is(err, ErrUserNotFound):
+			return err
+		}
+		var entries []*AuditEntry
+		if err := tx.SelectContext(ctx, &entries, s.dialect.rebind(querySelectAllAuditEntriesForUpdate), tenantID, id); err != nil {
+			return fmt.Errorf("failed to select audit entries: %w", err)
+		}
+		if user == nil {
+			if len(entries) == 0 {
+				return ErrUserNotFound
+			}
+			// Erased user can be purged afterwards, so erasure is not
+			// necessarily the last entry.
+			for _, e := range entries {
+				if e.Action == AuditActionErase {
+					return ErrUserErased
+				}
+			}
+		}
+		for _, e := range entries {
+			if !anonymizeChanges(e.Changes) {
+				continue
+			}
+			changes, err := e.Changes.Value()
+			if err != nil {
+				return fmt.Errorf("failed to marshal changes: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, s.dialect.rebind(queryUpdateAuditEntryChanges), changes, e.ID); err != nil {
+				return fmt.Errorf("failed to anonymize audit entry %d: %w", e.ID, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(s.dialect.queryDeleteUserWebhookDeliveries), tenantID, id); err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+		// The same user as before and after records erasure without changes.
+		erased := &User{ID: id, TenantID: tenantID}
+		if err := audit(ctx, tx, AuditActionErase, erased, erased, nil); err != nil {
+			return err
+		}
+		if _, err := tx.NamedExecContext(ctx, queryInsertErasure, out); err != nil {
+			return fmt.Errorf("failed to insert erasure receipt: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// anonymizeChanges clears values of personal fields in place and reports
+// whether any was cleared.
+func anonymizeChanges(changes FieldChanges) bool {
+	cleared := false
+	for i, c := range changes {
+		if !personalFields[c.Field] || (c.Before == "" && c.After == "") {
+			continue
+		}
+		changes[i] = FieldChange{Field: c.Field}
+		cleared = true
+	}
+	return cleared
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAnonymizeChanges(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		in         FieldChanges
+		exp        FieldChanges
+		expCleared bool
+	}{
+		{
+			desc: "personal fields are cleared",
+			in: FieldChanges{
+				{Field: "first_name", After: "Johnny"},
+				{Field: "email", Before: "c2VhbGVk", After: "c2VhbGVk", Encrypted: true},
+				{Field: "deleted_at", After: "2020-12-10T11:00:00Z"},
+			},
+			exp: FieldChanges{
+				{Field: "first_name"},
+				{Field: "email"},
+				{Field: "deleted_at", After: "2020-12-10T11:00:00Z"},
+			},
+			expCleared: true,
+		},
+		{
+			desc: "nothing to clear",
+			in: FieldChanges{
+				{Field: "nickname"},
+				{Field: "deleted_at", After: "2020-12-10T11:00:00Z"},
+			},
+			exp: FieldChanges{
+				{Field: "nickname"},
+				{Field: "deleted_at", After: "2020-12-10T11:00:00Z"},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if cleared := anonymizeChanges(tC.in); cleared != tC.expCleared {
+				t.Errorf("Expected cleared %v, got: %v", tC.expCleared, cleared)
+			}
+			if diff := cmp.Diff(tC.exp, tC.in); diff != "" {
+				t.Errorf("Changes mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/filter"
+)
+
+// UserFilterFields is allowlist of fields which can be used in filter of
+// ListUsers. Fields are named after columns.
+var UserFilterFields = filter.Schema{
+	"id":         filter.String,
+	"first_name": filter.String,
+	"last_name":  filter.String,
+	"nickname":   filter.String,
+	"email":      filter.String,
+	"country":    filter.String,
+	"created_by": filter.String,
+	"updated_by": filter.String,
+	"created_at": filter.Timestamp,
+	"updated_at": filter.Timestamp,
+}
+
+// UserOrderFields is allowlist of fields by which ListUsers can be ordered.
+var UserOrderFields = filter.Schema{
+	"id":         filter.String,
+	"first_name": filter.String,
+	"last_name":  filter.String,
+	"nickname":   filter.String,
+	"email":      filter.String,
+	"country":    filter.String,
+	"created_at": filter.Timestamp,
+	"updated_at": filter.Timestamp,
+}
+
+var filterOperators = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// compileFilter returns SQL condition of expression in dialect d along with
+// its args. String equality with "*" wildcard is compiled to LIKE. If enc is
+// not nil, encrypted fields fail with ErrFieldEncrypted, except equality of
+// email which is compared also with blind index.
+func compileFilter(e filter.Expr, enc *encryption, d *dialect) (string, []interface{}, error) {
+	switch e := e.(type) {
+	case filter.And:
+		return compileBinary(e.Left, e.Right, "AND", enc, d)
+	case filter.Or:
+		return compileBinary(e.Left, e.Right, "OR", enc, d)
+	case filter.Not:
+		cond, args, err := compileFilter(e.Expr, enc, d)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + cond, args, nil
+	case filter.Comparison:
+		if _, ok := UserFilterFields[e.Field]; !ok {
+			return "", nil, fmt.Errorf("field %q cannot be filtered", e.Field)
+		}
+		if !filterOperators[e.Op] {
+			return "", nil, fmt.Errorf("unknown operator %q", e.Op)
+		}
+		s, isString := e.Value.(string)
+		wildcard := isString && strings.Contains(s, "*") && (e.Op == "=" || e.Op == "!=")
+		if enc != nil && encryptedFields[e.Field] {
+			if e.Field != "email" {
+				return "", nil, fmt.Errorf("%w: %q cannot be filtered", ErrFieldEncrypted, e.Field)
+			}
+			if wildcard || (e.Op != "=" && e.Op != "!=") {
+				return "", nil, fmt.Errorf("%w: %q can be only compared with = or != without wildcards", ErrFieldEncrypted, e.Field)
+			}
+			// Users which are not encrypted yet still have plaintext email.
+			cond := "(email = ? OR email_index = ?)"
+			if e.Op == "!=" {
+				cond = "NOT " + cond
+			}
+			return cond, []interface{}{s, enc.blindIndex(s)}, nil
+		}
+		if wildcard {
+			op := "LIKE"
+			if e.Op == "!=" {
+				op = "NOT LIKE"
+			}
+			return fmt.Sprintf("(%s %s ?%s)", e.Field, op, d.likeEscape), []interface{}{likePattern(s)}, nil
+		}
+		return fmt.Sprintf("(%s %s ?)", e.Field, e.Op), []interface{}{e.Value}, nil
+	}
+	return "", nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func compileBinary(left, right filter.Expr, op string, enc *encryption, d *dialect) (string, []interface{}, error) {
+	l, largs, err := compileFilter(left, enc, d)
+	if err != nil {
+		return "", nil, err
+	}
+	r, rargs, err := compileFilter(right, enc, d)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("(%s %s %s)", l, op, r), append(largs, rargs...), nil
+}
+
+// likePattern escapes LIKE special characters of s and replaces "*"
+// wildcards with "%".
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	return strings.ReplaceAll(s, "*", "%")
+}
+
+// compileOrder returns ORDER BY fields and, if afterID is set, condition
+// selecting rows following row with afterID of given tenant in that order.
+// id is always the last order field, so order is total. If encrypted is
+// set, encrypted fields fail with ErrFieldEncrypted.
+func compileOrder(fields []filter.OrderField, tenantID, afterID string, encrypted bool) (string, string, []interface{}, error) {
+	var ordered []filter.OrderField
+	for _, f := range fields {
+		if _, ok := UserOrderFields[f.Field]; !ok {
+			return "", "", nil, fmt.Errorf("field %q cannot be ordered", f.Field)
+		}
+		if encrypted && encryptedFields[f.Field] {
+			return "", "", nil, fmt.Errorf("%w: %q cannot be ordered", ErrFieldEncrypted, f.Field)
+		}
+		ordered = append(ordered, f)
+		// id is unique, so following fields would never be compared.
+		if f.Field == "id" {
+			break
+		}
+	}
+	if len(ordered) == 0 || ordered[len(ordered)-1].Field != "id" {
+		ordered = append(ordered, filter.OrderField{Field: "id"})
+	}
+	var order []string
+	for _, f := range ordered {
+		order = append(order, orderTerm(f))
+	}
+	if afterID == "" {
+		return strings.Join(order, ", "), "", nil, nil
+	}
+
+	// Row follows if it is equal on first i fields and after on i-th one,
+	// e.g. (a > x) OR (a = x AND id > y). Values of row with afterID are
+	// selected by subqueries, so page token can remain just id.
+	var (
+		alternatives []string
+		args         []interface{}
+	)
+	for i, f := range ordered {
+		var conds []string
+		for _, prev := range ordered[:i] {
+			conds = append(conds, fmt.Sprintf("%s = %s", prev.Field, afterValue(prev.Field)))
+			args = append(args, afterArgs(prev.Field, tenantID, afterID)...)
+		}
+		op := ">"
+		if f.Desc {
+			op = "<"
+		}
+		conds = append(conds, fmt.Sprintf("%s %s %s", f.Field, op, afterValue(f.Field)))
+		args = append(args, afterArgs(f.Field, tenantID, afterID)...)
+		alternatives = append(alternatives, "("+strings.Join(conds, " AND ")+")")
+	}
+	return strings.Join(order, ", "), "(" + strings.Join(alternatives, " OR ") + ")", args, nil
+}
+
+func orderTerm(f filter.OrderField) string {
+	if f.Desc {
+		return f.Field + " DESC"
+	}
+	return f.Field
+}
+
+func afterValue(field string) string {
+	if field == "id" {
+		return "?"
+	}
+	return fmt.Sprintf("(SELECT %s FROM users WHERE id = ? AND tenant_id = ?)", field)
+}
+
+func afterArgs(field, tenantID, afterID string) []interface{} {
+	if field == "id" {
+		return []interface{}{afterID}
+	}
+	return []interface{}{afterID, tenantID}
+}
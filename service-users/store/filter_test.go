@@ -0,0 +1,115 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/filter"
+)
+
+func TestCompileFilter(t *testing.T) {
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc    string
+		in      string
+		exp     string
+		expArgs []interface{}
+	}{
+		{
+			desc:    "comparisons",
+			in:      `country = "US" AND NOT (updated_at > "2026-01-01T00:00:00Z" OR nickname != "")`,
+			exp:     "((country = ?) AND NOT ((updated_at > ?) OR (nickname != ?)))",
+			expArgs: []interface{}{"US", since, ""},
+		},
+		{
+			desc:    "wildcards",
+			in:      `email = "*@test.com" AND first_name != "j_hn%*"`,
+			exp:     "((email LIKE ?) AND (first_name NOT LIKE ?))",
+			expArgs: []interface{}{"%@test.com", `j\_hn\%%`},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			e, err := filter.Parse(tC.in, UserFilterFields)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, args, err := compileFilter(e, nil, mysqlDialect)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tC.exp {
+				t.Errorf("Expected condition %s, got: %s", tC.exp, got)
+			}
+			if diff := cmp.Diff(tC.expArgs, args); diff != "" {
+				t.Errorf("Args mismatch, diff: %s", diff)
+			}
+		})
+	}
+
+	_, _, err := compileFilter(filter.Comparison{Field: "deleted_at", Op: "=", Value: ""}, nil, mysqlDialect)
+	if err == nil {
+		t.Errorf("Expected error for field out of allowlist")
+	}
+}
+
+func TestCompileOrder(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		in       []filter.OrderField
+		afterID  string
+		expOrder string
+		expSeek  string
+		expArgs  []interface{}
+	}{
+		{
+			desc:     "default order",
+			afterID:  "id-1",
+			expOrder: "id",
+			expSeek:  "((id > ?))",
+			expArgs:  []interface{}{"id-1"},
+		},
+		{
+			desc:     "first page",
+			in:       []filter.OrderField{{Field: "country"}},
+			expOrder: "country, id",
+		},
+		{
+			desc:     "mixed directions",
+			in:       []filter.OrderField{{Field: "country", Desc: true}, {Field: "email"}},
+			afterID:  "id-1",
+			expOrder: "country DESC, email, id",
+			expSeek: "((country < (SELECT country FROM users WHERE id = ? AND tenant_id = ?))" +
+				" OR (country = (SELECT country FROM users WHERE id = ? AND tenant_id = ?) AND email > (SELECT email FROM users WHERE id = ? AND tenant_id = ?))" +
+				" OR (country = (SELECT country FROM users WHERE id = ? AND tenant_id = ?) AND email = (SELECT email FROM users WHERE id = ? AND tenant_id = ?) AND id > ?))",
+			expArgs: []interface{}{"id-1", "t-1", "id-1", "t-1", "id-1", "t-1", "id-1", "t-1", "id-1", "t-1", "id-1"},
+		},
+		{
+			desc:     "fields after id are skipped",
+			in:       []filter.OrderField{{Field: "id", Desc: true}, {Field: "email"}},
+			afterID:  "id-1",
+			expOrder: "id DESC",
+			expSeek:  "((id < ?))",
+			expArgs:  []interface{}{"id-1"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			order, seek, args, err := compileOrder(tC.in, "t-1", tC.afterID, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if order != tC.expOrder {
+				t.Errorf("Expected order %s, got: %s", tC.expOrder, order)
+			}
+			if seek != tC.expSeek {
+				t.Errorf("Expected seek %s, got: %s", tC.expSeek, seek)
+			}
+			if diff := cmp.Diff(tC.expArgs, args); diff != "" {
+				t.Errorf("Args mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+CREATE TABLE webhook_subscriptions (
+  id varchar(36) PRIMARY KEY,
+  url text NOT NULL,
+  event_types text NOT NULL,
+  secret varchar(255) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE webhook_deliveries (
+  id varchar(36) PRIMARY KEY,
+  subscription_id varchar(36) NOT NULL,
+  event_id varchar(36) NOT NULL,
+  event_type varchar(255) NOT NULL,
+  payload mediumblob NOT NULL,
+  status varchar(16) NOT NULL,
+  attempt_count int NOT NULL DEFAULT 0,
+  next_attempt_at timestamp NULL DEFAULT NULL,
+  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions (id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE webhook_delivery_attempts (
+  delivery_id varchar(36) NOT NULL,
+  number int NOT NULL,
+  attempted_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  response_status int NOT NULL,
+  error text NOT NULL,
+  duration_ms bigint NOT NULL,
+  PRIMARY KEY (delivery_id, number),
+  FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id) ON DELETE CASCADE
+);
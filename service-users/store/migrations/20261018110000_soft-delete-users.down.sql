@@ -0,0 +1,9 @@
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX users_deleted_at ON users;
+
+ALTER TABLE users
+  DROP INDEX users_active_email,
+  DROP COLUMN active_email,
+  DROP COLUMN deleted_at,
+  ADD UNIQUE INDEX email (email);
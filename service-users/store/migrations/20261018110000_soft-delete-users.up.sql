@@ -0,0 +1,10 @@
+-- Email has to be unique only among not deleted users, so the same email
+-- can be reused after user is deleted. MySQL does not support partial
+-- indexes, generated column is NULL for deleted users instead.
+ALTER TABLE users
+  ADD COLUMN deleted_at timestamp NULL DEFAULT NULL,
+  ADD COLUMN active_email varchar(255) AS (IF(deleted_at IS NULL, email, NULL)) STORED,
+  DROP INDEX email,
+  ADD UNIQUE INDEX users_active_email (active_email);
+
+CREATE INDEX users_deleted_at ON users (deleted_at);
@@ -0,0 +1,11 @@
+CREATE TABLE users_audit_log (
+  id bigint AUTO_INCREMENT PRIMARY KEY,
+  user_id varchar(36) NOT NULL,
+  action varchar(16) NOT NULL,
+  actor varchar(255) NOT NULL,
+  request_id varchar(255) NOT NULL,
+  changes json NOT NULL,
+  created_at timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE INDEX users_audit_log_user_id ON users_audit_log (user_id, id);
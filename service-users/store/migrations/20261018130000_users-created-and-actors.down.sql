@@ -0,0 +1,5 @@
+ALTER TABLE users
+  MODIFY updated_at timestamp NULL DEFAULT NULL,
+  DROP COLUMN updated_by,
+  DROP COLUMN created_by,
+  DROP COLUMN created_at;
@@ -0,0 +1,26 @@
+ALTER TABLE users
+  ADD COLUMN created_at timestamp NULL DEFAULT NULL,
+  ADD COLUMN created_by varchar(255) NOT NULL DEFAULT '',
+  ADD COLUMN updated_by varchar(255) NOT NULL DEFAULT '';
+
+-- Backfill existing rows from audit log where possible.
+UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE updated_at IS NULL;
+
+UPDATE users u
+  LEFT JOIN users_audit_log a ON a.user_id = u.id AND a.action = 'create'
+SET
+  u.created_at = COALESCE(a.created_at, u.updated_at),
+  u.created_by = COALESCE(a.actor, 'unknown');
+
+UPDATE users u
+  JOIN users_audit_log a ON a.id = (
+    SELECT MAX(id) FROM users_audit_log WHERE user_id = u.id
+  )
+SET
+  u.updated_by = a.actor;
+
+UPDATE users SET updated_by = created_by WHERE updated_by = '';
+
+ALTER TABLE users
+  MODIFY created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  MODIFY updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP;
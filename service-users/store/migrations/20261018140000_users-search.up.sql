@@ -0,0 +1,8 @@
+-- ngram parser indexes every 2 characters long substring, so words match
+-- by prefix, in the middle and with typos. Stopwords are disabled as they
+-- are checked only on index creation and would drop common n-grams like "on".
+SET SESSION innodb_ft_enable_stopword = OFF;
+
+CREATE FULLTEXT INDEX users_search ON users (first_name, last_name, nickname, email) WITH PARSER ngram;
+
+SET SESSION innodb_ft_enable_stopword = ON;
@@ -0,0 +1,20 @@
+-- It fails if active email is used by users of multiple tenants.
+ALTER TABLE webhook_deliveries
+  DROP INDEX webhook_deliveries_tenant_id,
+  DROP COLUMN tenant_id;
+
+ALTER TABLE webhook_subscriptions
+  DROP INDEX webhook_subscriptions_tenant_id,
+  DROP COLUMN tenant_id;
+
+ALTER TABLE users_audit_log
+  DROP INDEX users_audit_log_user_id,
+  DROP COLUMN tenant_id,
+  ADD INDEX users_audit_log_user_id (user_id, id);
+
+ALTER TABLE users
+  DROP INDEX users_countries,
+  DROP INDEX users_active_email,
+  DROP COLUMN tenant_id,
+  ADD UNIQUE INDEX users_active_email (active_email),
+  ADD INDEX users_countries (country);
@@ -0,0 +1,21 @@
+-- Existing rows belong to default tenant. Emails have to be unique only
+-- within tenant, so the same email can be used by users of other tenants.
+ALTER TABLE users
+  ADD COLUMN tenant_id varchar(64) NOT NULL DEFAULT 'default' AFTER id,
+  DROP INDEX users_active_email,
+  ADD UNIQUE INDEX users_active_email (tenant_id, active_email),
+  DROP INDEX users_countries,
+  ADD INDEX users_countries (tenant_id, country);
+
+ALTER TABLE users_audit_log
+  ADD COLUMN tenant_id varchar(64) NOT NULL DEFAULT 'default' AFTER id,
+  DROP INDEX users_audit_log_user_id,
+  ADD INDEX users_audit_log_user_id (tenant_id, user_id, id);
+
+ALTER TABLE webhook_subscriptions
+  ADD COLUMN tenant_id varchar(64) NOT NULL DEFAULT 'default' AFTER id,
+  ADD INDEX webhook_subscriptions_tenant_id (tenant_id, id);
+
+ALTER TABLE webhook_deliveries
+  ADD COLUMN tenant_id varchar(64) NOT NULL DEFAULT 'default' AFTER id,
+  ADD INDEX webhook_deliveries_tenant_id (tenant_id, subscription_id, id);
@@ -0,0 +1,12 @@
+-- Names and emails of encrypted users are lost, as only ciphertexts were
+-- stored.
+DROP INDEX users_pii_key_id ON users;
+
+ALTER TABLE users
+  MODIFY active_email varchar(255) AS (IF(deleted_at IS NULL, email, NULL)) STORED,
+  DROP COLUMN email_index,
+  DROP COLUMN email_enc,
+  DROP COLUMN last_name_enc,
+  DROP COLUMN first_name_enc,
+  DROP COLUMN pii_key,
+  DROP COLUMN pii_key_id;
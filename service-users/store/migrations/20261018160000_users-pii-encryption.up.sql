@@ -0,0 +1,14 @@
+-- When encryption is enabled, first name, last name and email are stored
+-- only in *_enc columns, sealed with data key of user, which is stored
+-- wrapped in pii_key. email_index is keyed hash of lower cased email, which
+-- takes place of email in uniqueness of active emails.
+ALTER TABLE users
+  ADD COLUMN pii_key_id varchar(64) NOT NULL DEFAULT '',
+  ADD COLUMN pii_key varbinary(255) NULL DEFAULT NULL,
+  ADD COLUMN first_name_enc blob NULL DEFAULT NULL,
+  ADD COLUMN last_name_enc blob NULL DEFAULT NULL,
+  ADD COLUMN email_enc blob NULL DEFAULT NULL,
+  ADD COLUMN email_index char(64) NOT NULL DEFAULT '',
+  MODIFY active_email varchar(255) AS (IF(deleted_at IS NULL, IF(email_index = '', email, email_index), NULL)) STORED;
+
+CREATE INDEX users_pii_key_id ON users (pii_key_id);
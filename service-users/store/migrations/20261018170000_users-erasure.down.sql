@@ -0,0 +1,6 @@
+DROP TABLE users_erasures;
+
+DROP TABLE users_events;
+
+ALTER TABLE users
+  DROP COLUMN erased_at;
@@ -0,0 +1,27 @@
+ALTER TABLE users
+  ADD COLUMN erased_at timestamp NULL DEFAULT NULL;
+
+-- Log of events published about users. Data of events is not kept, it can
+-- be reconstructed from audit log.
+CREATE TABLE users_events (
+  id varchar(36) PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL,
+  user_id varchar(36) NOT NULL,
+  type varchar(255) NOT NULL,
+  created_at timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE INDEX users_events_user_id ON users_events (tenant_id, user_id, created_at);
+
+-- Receipts of erasures, they are kept after users are purged.
+CREATE TABLE users_erasures (
+  id varchar(36) PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL,
+  user_id varchar(36) NOT NULL,
+  reason text NOT NULL,
+  actor varchar(255) NOT NULL,
+  request_id varchar(255) NOT NULL,
+  erased_at timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE INDEX users_erasures_user_id ON users_erasures (tenant_id, user_id);
@@ -0,0 +1,10 @@
+// Package migrations embeds SQL migrations of MySQL store, so they can be
+// applied by the service binary itself (see migrate package).
+package migrations
+
+import "embed"
+
+// FS contains migration files named <version>_<name>.(up|down).sql.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhook_subscriptions;
+
+DROP TABLE IF EXISTS users_erasures;
+
+DROP TABLE IF EXISTS users_events;
+
+DROP TABLE IF EXISTS users_audit_log;
+
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,121 @@
+-- Email has to be unique only among not deleted users of tenant, so the
+-- same email can be reused after user is deleted. active_email is NULL for
+-- deleted users, so unique constraint is not checked for them, the same as
+-- in MySQL schema. It is lower cased, as Postgres compares strings case
+-- sensitively. When encryption is enabled, email_index takes place of email.
+CREATE TABLE users (
+  id varchar(36) PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL DEFAULT 'default',
+  first_name text NOT NULL,
+  last_name text NOT NULL,
+  nickname text NOT NULL,
+  email varchar(255) NOT NULL,
+  country varchar(2) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created_by varchar(255) NOT NULL DEFAULT '',
+  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_by varchar(255) NOT NULL DEFAULT '',
+  deleted_at timestamp NULL DEFAULT NULL,
+  erased_at timestamp NULL DEFAULT NULL,
+  pii_key_id varchar(64) NOT NULL DEFAULT '',
+  pii_key bytea NULL DEFAULT NULL,
+  first_name_enc bytea NULL DEFAULT NULL,
+  last_name_enc bytea NULL DEFAULT NULL,
+  email_enc bytea NULL DEFAULT NULL,
+  email_index varchar(64) NOT NULL DEFAULT '',
+  active_email varchar(255) GENERATED ALWAYS AS (
+    CASE WHEN deleted_at IS NULL THEN
+      CASE WHEN email_index = '' THEN lower(email) ELSE email_index END
+    END
+  ) STORED,
+  CONSTRAINT users_active_email UNIQUE (tenant_id, active_email)
+);
+
+CREATE INDEX users_countries ON users (tenant_id, country);
+
+CREATE INDEX users_deleted_at ON users (deleted_at);
+
+CREATE INDEX users_pii_key_id ON users (pii_key_id);
+
+-- Expression has to match one used by store, otherwise index is not used.
+-- simple configuration does not stem words nor drop stopwords.
+CREATE INDEX users_search ON users USING GIN (
+  to_tsvector('simple', first_name || ' ' || last_name || ' ' || nickname || ' ' || email)
+);
+
+CREATE TABLE users_audit_log (
+  id bigserial PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL DEFAULT 'default',
+  user_id varchar(36) NOT NULL,
+  action varchar(16) NOT NULL,
+  actor varchar(255) NOT NULL,
+  request_id varchar(255) NOT NULL,
+  changes jsonb NOT NULL,
+  created_at timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE INDEX users_audit_log_user_id ON users_audit_log (tenant_id, user_id, id);
+
+-- Log of events published about users. Data of events is not kept, it can
+-- be reconstructed from audit log.
+CREATE TABLE users_events (
+  id varchar(36) PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL,
+  user_id varchar(36) NOT NULL,
+  type varchar(255) NOT NULL,
+  created_at timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE INDEX users_events_user_id ON users_events (tenant_id, user_id, created_at);
+
+-- Receipts of erasures, they are kept after users are purged.
+CREATE TABLE users_erasures (
+  id varchar(36) PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL,
+  user_id varchar(36) NOT NULL,
+  reason text NOT NULL,
+  actor varchar(255) NOT NULL,
+  request_id varchar(255) NOT NULL,
+  erased_at timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE INDEX users_erasures_user_id ON users_erasures (tenant_id, user_id);
+
+CREATE TABLE webhook_subscriptions (
+  id varchar(36) PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL DEFAULT 'default',
+  url text NOT NULL,
+  event_types text NOT NULL,
+  secret varchar(255) NOT NULL,
+  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_subscriptions_tenant_id ON webhook_subscriptions (tenant_id, id);
+
+CREATE TABLE webhook_deliveries (
+  id varchar(36) PRIMARY KEY,
+  tenant_id varchar(64) NOT NULL DEFAULT 'default',
+  subscription_id varchar(36) NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+  event_id varchar(36) NOT NULL,
+  event_type varchar(255) NOT NULL,
+  payload bytea NOT NULL,
+  status varchar(16) NOT NULL,
+  attempt_count int NOT NULL DEFAULT 0,
+  next_attempt_at timestamp NULL DEFAULT NULL,
+  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+CREATE INDEX webhook_deliveries_tenant_id ON webhook_deliveries (tenant_id, subscription_id, id);
+
+CREATE TABLE webhook_delivery_attempts (
+  delivery_id varchar(36) NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+  number int NOT NULL,
+  attempted_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  response_status int NOT NULL,
+  error text NOT NULL,
+  duration_ms bigint NOT NULL,
+  PRIMARY KEY (delivery_id, number)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE webhook_deliveries
+  DROP COLUMN user_id,
+  DROP COLUMN payload_encrypted;
@@ -0,0 +1,11 @@
+// Package postgres embeds SQL migrations of Postgres store. Schema is the
+// same as after MySQL migrations of parent package, which are not replayed
+// one by one.
+package postgres
+
+import "embed"
+
+// FS contains migration files named <version>_<name>.(up|down).sql.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,5 @@
+ALTER TABLE webhook_deliveries
+  DROP COLUMN payload_encrypted;
+
+ALTER TABLE webhook_deliveries
+  DROP COLUMN user_id;
@@ -0,0 +1,7 @@
+-- Payloads of deliveries of events about encrypted users are sealed with
+-- data key of user, so they become unreadable once user is purged.
+ALTER TABLE webhook_deliveries
+  ADD COLUMN user_id varchar(36) NOT NULL DEFAULT '';
+
+ALTER TABLE webhook_deliveries
+  ADD COLUMN payload_encrypted boolean NOT NULL DEFAULT false;
@@ -0,0 +1,11 @@
+// Package sqlite embeds SQL migrations of SQLite store. Schema is the same
+// as after MySQL migrations of parent package, except for full-text index,
+// which SQLite store does not use.
+package sqlite
+
+import "embed"
+
+// FS contains migration files named <version>_<name>.(up|down).sql.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -5,97 +5,427 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/filter"
 )
 
 var (
 	ErrUserAlreadyExists = errors.New("user already exists")
 	ErrUserNotFound      = errors.New("user not found")
+	ErrUserNotDeleted    = errors.New("user is not deleted")
 )
 
 // User represents user on store side.
 type User struct {
-	ID        string    `db:"id"`
-	FirstName string    `db:"first_name"`
-	LastName  string    `db:"last_name"`
-	Nickname  string    `db:"nickname"`
-	Email     string    `db:"email"`
-	Country   string    `db:"country"`
-	UpdatedAt time.Time `db:"updated_at"`
+	ID string `db:"id"`
+	// TenantID is set from context on create and never changed.
+	TenantID  string       `db:"tenant_id"`
+	FirstName string       `db:"first_name"`
+	LastName  string       `db:"last_name"`
+	Nickname  string       `db:"nickname"`
+	Email     string       `db:"email"`
+	Country   string       `db:"country"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	DeletedAt sql.NullTime `db:"deleted_at"`
+	// ErasedAt is set if personal data of user was erased by EraseUser.
+	ErasedAt sql.NullTime `db:"erased_at"`
+	// CreatedAt and CreatedBy are set on create and never changed.
+	CreatedAt time.Time `db:"created_at"`
+	CreatedBy string    `db:"created_by"`
+	UpdatedBy string    `db:"updated_by"`
+}
+
+// ListUsersFilter represents filtering parameters of ListUsers.
+type ListUsersFilter struct {
+	// Countries, if not empty only users from given countries are listed.
+	Countries []string
+	// Expr, if not nil, must be matched by listed users. It can use only
+	// UserFilterFields.
+	Expr filter.Expr
+	// OrderBy is order of listed users, it can use only UserOrderFields.
+	// Users are always ordered by id at the end.
+	OrderBy []filter.OrderField
+}
+
+// ReadOption configures reads of users.
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	showDeleted bool
+	primary     bool
+}
+
+// WithDeleted makes soft deleted users visible to reads.
+func WithDeleted() ReadOption {
+	return func(o *readOptions) {
+		o.showDeleted = true
+	}
+}
+
+// FromPrimary makes read go to primary database even if replicas are
+// configured, so it always observes the latest writes.
+func FromPrimary() ReadOption {
+	return func(o *readOptions) {
+		o.primary = true
+	}
+}
+
+func newReadOptions(opts []ReadOption) readOptions {
+	var o readOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
 }
 
 type store struct {
-	db *sqlx.DB
+	db       *sqlx.DB
+	dialect  *dialect
+	replicas *replicaSet
+	// enc is nil if encryption is not enabled.
+	enc   *encryption
+	retry RetryPolicy
 }
 
-func New(db *sql.DB) *store {
+// New returns MySQL store writing to primary db. Reads of users are spread
+// across replicas, if any are given.
+func New(db *sql.DB, replicas ...*sql.DB) *store {
+	return newStore(mysqlDialect, db, replicas)
+}
+
+// NewForDriver returns store of database of given database/sql driver,
+// "postgres" or "sqlite", or MySQL store for any other driver. It is single
+// place where driver is mapped to store, so every backend is available
+// wherever store is created from DSN.
+func NewForDriver(driver string, db *sql.DB, replicas ...*sql.DB) *store {
+	switch driver {
+	case postgresDialect.driverName:
+		return NewPostgres(db, replicas...)
+	case sqliteDialect.driverName:
+		return NewSQLite(db, replicas...)
+	}
+	return New(db, replicas...)
+}
+
+func newStore(d *dialect, db *sql.DB, replicas []*sql.DB) *store {
 	return &store{
-		db: sqlx.NewDb(db, "mysql"),
+		db:       sqlx.NewDb(db, d.driverName),
+		dialect:  d,
+		replicas: newReplicaSet(d.driverName, replicas),
+		retry:    DefaultRetryPolicy,
 	}
 }
 
 func (s *store) CreateUser(ctx context.Context, in *User) (*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 	uuid, err := uuid.NewRandom()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate uuid: %w", err)
 	}
+	now := time.Now().UTC()
 	in.ID = uuid.String()
+	in.TenantID = tenantID
+	in.CreatedAt = now
+	in.CreatedBy = actorFromContext(ctx)
+	in.UpdatedAt = now
+	in.UpdatedBy = in.CreatedBy
+	row, err := s.seal(ctx, in, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = s.inTx(ctx, func(tx *sqlx.Tx) error {
+		if err := s.checkEmailTaken(ctx, tx, tenantID, in); err != nil {
+			return err
+		}
+		res, err := tx.NamedExecContext(ctx, queryInsertUser, row)
+		if err != nil {
+			if isDuplicateEntryErr(err) {
+				return ErrUserAlreadyExists
+			}
+			return fmt.Errorf("failed to insert user: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("cannot check affected rows: %w", err)
+		}
+		if affected == 0 {
+			return fmt.Errorf("user not created, no affected rows")
+		}
+		return audit(ctx, tx, AuditActionCreate, nil, in, row.dataKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (s *store) UpdateUser(ctx context.Context, in *User) (*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	in.TenantID = tenantID
 	in.UpdatedAt = time.Now().UTC()
-	res, err := s.db.NamedExecContext(ctx, queryInsertUser, in)
+	in.UpdatedBy = actorFromContext(ctx)
+	err = s.inTx(ctx, func(tx *sqlx.Tx) error {
+		before, err := s.getUserForUpdate(ctx, tx, tenantID, in.ID)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt.Valid {
+			return ErrUserNotFound
+		}
+		in.CreatedAt = before.CreatedAt
+		in.CreatedBy = before.CreatedBy
+		if err := s.checkEmailTaken(ctx, tx, tenantID, in); err != nil {
+			return err
+		}
+		row, err := s.seal(ctx, in, before)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NamedExecContext(ctx, queryUpdateUser, row); err != nil {
+			if isDuplicateEntryErr(err) {
+				return ErrUserAlreadyExists
+			}
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return audit(ctx, tx, AuditActionUpdate, &before.User, in, row.dataKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (s *store) GetUser(ctx context.Context, id string, opts ...ReadOption) (*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o := newReadOptions(opts)
+	var row userRow
+	err = s.read(ctx, "GetUser", o, func(db *sqlx.DB) error {
+		return db.GetContext(ctx, &row, s.dialect.rebind(querySelectUserById), id, tenantID, o.showDeleted)
+	})
 	if err != nil {
-		if isMysqlDuplicateEntryErr(err) {
-			return nil, ErrUserAlreadyExists
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
 		}
-		return nil, fmt.Errorf("failed to insert user: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", wrapTransient(err))
+	}
+	if err := s.open(ctx, &row); err != nil {
+		return nil, err
+	}
+	return &row.User, nil
+}
+
+// ListUsers returns up to limit users matching filter in its order,
+// starting after user with given id.
+func (s *store) ListUsers(ctx context.Context, filter ListUsersFilter, afterID string, limit int, opts ...ReadOption) ([]*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-	affected, err := res.RowsAffected()
+	o := newReadOptions(opts)
+	order, seek, seekArgs, err := compileOrder(filter.OrderBy, tenantID, afterID, s.enc != nil)
 	if err != nil {
-		return nil, fmt.Errorf("cannot check affected rows: %w", err)
+		return nil, fmt.Errorf("invalid order: %w", err)
+	}
+	where := []string{"tenant_id = ?"}
+	args := []interface{}{tenantID}
+	if seek != "" {
+		where = append(where, seek)
+		args = append(args, seekArgs...)
+	}
+	if len(filter.Countries) > 0 {
+		where = append(where, "country IN (?)")
+		args = append(args, filter.Countries)
 	}
-	if affected == 0 {
-		return nil, fmt.Errorf("user not created, no affected rows")
+	if filter.Expr != nil {
+		cond, condArgs, err := compileFilter(filter.Expr, s.enc, s.dialect)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	if !o.showDeleted {
+		where = append(where, "deleted_at IS NULL")
 	}
-	return in, err
+	args = append(args, limit)
+	query, args, err := sqlx.In(fmt.Sprintf(queryListUsers, strings.Join(where, " AND "), order), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build list query: %w", err)
+	}
+	var rows []*userRow
+	err = s.read(ctx, "ListUsers", o, func(db *sqlx.DB) error {
+		return db.SelectContext(ctx, &rows, s.dialect.rebind(query), args...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", wrapTransient(err))
+	}
+	return s.openUsers(ctx, rows)
 }
 
-func (s *store) UpdateUser(ctx context.Context, in *User) (*User, error) {
-	in.UpdatedAt = time.Now().UTC()
-	res, err := s.db.NamedExecContext(ctx, queryUpdateUser, in)
+// DeleteUser soft deletes user, it can be restored with UndeleteUser.
+func (s *store) DeleteUser(ctx context.Context, id string) (*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out *User
+	err = s.inTx(ctx, func(tx *sqlx.Tx) error {
+		before, err := s.getUserForUpdate(ctx, tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt.Valid {
+			return ErrUserNotFound
+		}
+		after := before.User
+		after.DeletedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(querySetUserDeletedAt), after.DeletedAt, id, tenantID); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		out = &after
+		return audit(ctx, tx, AuditActionDelete, &before.User, out, before.dataKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UndeleteUser restores soft deleted user. It fails with ErrUserAlreadyExists
+// if user's email was taken by other user in the meantime.
+func (s *store) UndeleteUser(ctx context.Context, id string) (*User, error) {
+	tenantID, err := tenantFromContext(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert user: %w", err)
+		return nil, err
 	}
-	affected, err := res.RowsAffected()
+	var out *User
+	err = s.inTx(ctx, func(tx *sqlx.Tx) error {
+		before, err := s.getUserForUpdate(ctx, tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		if before.ErasedAt.Valid {
+			return ErrUserNotFound
+		}
+		if !before.DeletedAt.Valid {
+			return ErrUserNotDeleted
+		}
+		after := before.User
+		after.DeletedAt = sql.NullTime{}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(querySetUserDeletedAt), after.DeletedAt, id, tenantID); err != nil {
+			if isDuplicateEntryErr(err) {
+				return ErrUserAlreadyExists
+			}
+			return fmt.Errorf("failed to undelete user: %w", err)
+		}
+		out = &after
+		return audit(ctx, tx, AuditActionUndelete, &before.User, out, before.dataKey)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("cannot check affected rows: %w", err)
+		return nil, err
 	}
-	if affected == 0 {
-		return nil, ErrUserNotFound
+	return out, nil
+}
+
+// PurgeDeletedUsers permanently removes up to limit users which were soft
+// deleted before given time. It returns removed users. It is not scoped by
+// tenant, as it is run by background job for all tenants.
+func (s *store) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]*User, error) {
+	var out []*User
+	err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		var rows []*userRow
+		if err := tx.SelectContext(ctx, &rows, s.dialect.rebind(querySelectUsersDeletedBefore), deletedBefore, limit); err != nil {
+			return fmt.Errorf("failed to select deleted users: %w", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		var err error
+		if out, err = s.openUsers(ctx, rows); err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(out))
+		for _, u := range out {
+			ids = append(ids, u.ID)
+		}
+		query, args, err := sqlx.In(queryDeleteUsers, ids)
+		if err != nil {
+			return fmt.Errorf("failed to build delete query: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), args...); err != nil {
+			return fmt.Errorf("failed to purge users: %w", err)
+		}
+		// Data keys are removed along with users, so encrypted fields of
+		// their audit entries cannot be decrypted anymore. Purge is recorded
+		// without changes, as state of user before it would keep personal
+		// data in audit log.
+		for _, r := range rows {
+			purged := &User{ID: r.ID, TenantID: r.TenantID}
+			if err := audit(ctx, tx, AuditActionPurge, purged, purged, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return in, err
+	return out, nil
 }
 
-func (s *store) GetUser(ctx context.Context, id string) (*User, error) {
-	var out User
-	if err := s.db.GetContext(ctx, &out, querySelectUserById, id); err != nil {
+func (s *store) getUserForUpdate(ctx context.Context, tx *sqlx.Tx, tenantID, id string) (*userRow, error) {
+	var out userRow
+	if err := tx.GetContext(ctx, &out, s.dialect.rebind(querySelectUserByIdForUpdate), id, tenantID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	if err := s.open(ctx, &out); err != nil {
+		return nil, err
+	}
 	return &out, nil
 }
 
-func isMysqlDuplicateEntryErr(err error) bool {
-	if err == nil {
-		return false
+// checkEmailTaken fails with ErrUserAlreadyExists if email of u is used by
+// other active user of tenant. It is needed only with encryption, as then
+// emails of users which are not encrypted yet are not compared with blind
+// indexes by unique index.
+func (s *store) checkEmailTaken(ctx context.Context, tx *sqlx.Tx, tenantID string, u *User) error {
+	if s.enc == nil {
+		return nil
 	}
-	me, ok := err.(*mysql.MySQLError)
-	if !ok {
-		return false
+	taken, err := s.emailOwners(ctx, tx, tenantID, []*User{u})
+	if err != nil {
+		return err
+	}
+	if owner, ok := taken[strings.ToLower(u.Email)]; ok && owner != u.ID {
+		return ErrUserAlreadyExists
+	}
+	return nil
+}
+
+// isDuplicateEntryErr tells whether err is violation of unique index, in
+// any of supported databases.
+func isDuplicateEntryErr(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == 1062
 	}
-	return me.Number == 1062
+	return isPostgresUniqueViolation(err) || isSQLiteUniqueViolation(err)
 }
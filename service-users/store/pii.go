@@ -0,0 +1,396 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/envelope"
+)
+
+// ErrFieldEncrypted is returned when ListUsers filters or orders by
+// encrypted field in a way which cannot be evaluated by database.
+var ErrFieldEncrypted = errors.New("field is encrypted")
+
+// encryptedFields are user fields which are encrypted when encryption is
+// enabled. Only equality of email can be checked, via its blind index.
+var encryptedFields = map[string]bool{
+	"first_name": true,
+	"last_name":  true,
+	"email":      true,
+}
+
+// encryption seals PII fields of users with their own data keys.
+type encryption struct {
+	keys     envelope.KeyProvider
+	indexKey []byte
+}
+
+// EnableEncryption makes store encrypt first name, last name and email of
+// written users with data keys wrapped by keys. Email is looked up by
+// keyed blind index. Users written before remain readable, they can be
+// encrypted with ReencryptUsers.
+func (s *store) EnableEncryption(keys envelope.KeyProvider, blindIndexKey []byte) {
+	s.enc = &encryption{keys: keys, indexKey: blindIndexKey}
+}
+
+// piiColumns are encrypted PII fields of user. They are set only if user
+// is encrypted, plaintext columns are empty then.
+type piiColumns struct {
+	PIIKeyID     string `db:"pii_key_id"`
+	PIIKey       []byte `db:"pii_key"`
+	FirstNameEnc []byte `db:"first_name_enc"`
+	LastNameEnc  []byte `db:"last_name_enc"`
+	EmailEnc     []byte `db:"email_enc"`
+	EmailIndex   string `db:"email_index"`
+}
+
+// userRow is user as stored in users table.
+type userRow struct {
+	User
+	piiColumns
+	// dataKey is unwrapped key of encrypted user, set by open.
+	dataKey []byte
+}
+
+func (r *userRow) encrypted() bool {
+	return r.PIIKeyID != ""
+}
+
+// piiFields returns plaintext and sealed value of each PII field.
+func (r *userRow) piiFields() []struct {
+	name   string
+	plain  *string
+	sealed *[]byte
+} {
+	return []struct {
+		name   string
+		plain  *string
+		sealed *[]byte
+	}{
+		{"first_name", &r.FirstName, &r.FirstNameEnc},
+		{"last_name", &r.LastName, &r.LastNameEnc},
+		{"email", &r.Email, &r.EmailEnc},
+	}
+}
+
+// seal returns row of user. If encryption is enabled, PII fields are sealed
+// with data key of prev row of the same user, or new one if there is none.
+func (s *store) seal(ctx context.Context, u *User, prev *userRow) (*userRow, error) {
+	row := &userRow{User: *u}
+	if s.enc == nil {
+		return row, nil
+	}
+	if prev != nil && prev.encrypted() {
+		row.PIIKeyID, row.PIIKey, row.dataKey = prev.PIIKeyID, prev.PIIKey, prev.dataKey
+	} else {
+		key, err := envelope.NewDataKey()
+		if err != nil {
+			return nil, err
+		}
+		keyID, wrapped, err := s.enc.keys.Wrap(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+		row.PIIKeyID, row.PIIKey, row.dataKey = keyID, wrapped, key
+	}
+	row.EmailIndex = s.enc.blindIndex(u.Email)
+	for _, f := range row.piiFields() {
+		sealed, err := envelope.Seal(row.dataKey, []byte(*f.plain), piiAdditionalData(u.ID, f.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", f.name, err)
+		}
+		*f.sealed, *f.plain = sealed, ""
+	}
+	return row, nil
+}
+
+// open decrypts PII fields of row in place. Rows which are not encrypted
+// are left as they are.
+func (s *store) open(ctx context.Context, row *userRow) error {
+	if !row.encrypted() {
+		return nil
+	}
+	if s.enc == nil {
+		return fmt.Errorf("user %s is encrypted, but encryption is not enabled", row.ID)
+	}
+	key, err := s.enc.keys.Unwrap(ctx, row.PIIKeyID, row.PIIKey)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap data key of user %s: %w", row.ID, err)
+	}
+	row.dataKey = key
+	for _, f := range row.piiFields() {
+		plain, err := envelope.Open(key, *f.sealed, piiAdditionalData(row.ID, f.name))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s of user %s: %w", f.name, row.ID, err)
+		}
+		*f.plain = string(plain)
+	}
+	return nil
+}
+
+// openUsers decrypts rows and returns their users.
+func (s *store) openUsers(ctx context.Context, rows []*userRow) ([]*User, error) {
+	out := make([]*User, 0, len(rows))
+	for _, r := range rows {
+		if err := s.open(ctx, r); err != nil {
+			return nil, err
+		}
+		out = append(out, &r.User)
+	}
+	return out, nil
+}
+
+// piiAdditionalData binds sealed value to user and field, so it cannot be
+// moved to other one.
+func piiAdditionalData(userID, field string) []byte {
+	return []byte(userID + "/" + field)
+}
+
+func (e *encryption) blindIndex(email string) string {
+	return envelope.BlindIndex(e.indexKey, strings.ToLower(email))
+}
+
+// activeEmailKeys returns values of active_email column under which given
+// emails can be stored, along with lower cased email of each value.
+func (s *store) activeEmailKeys(emails []string) ([]string, map[string]string) {
+	keys := make([]string, 0, len(emails)*2)
+	byKey := make(map[string]string, len(emails)*2)
+	for _, e := range emails {
+		e = strings.ToLower(e)
+		keys = append(keys, e)
+		byKey[e] = e
+		if s.enc != nil {
+			index := s.enc.blindIndex(e)
+			keys = append(keys, index)
+			byKey[index] = e
+		}
+	}
+	return keys, byKey
+}
+
+// sealChanges encrypts values of PII fields of audit entry with data key
+// of user. They cannot be decrypted after user is purged.
+func sealChanges(changes FieldChanges, userID string, key []byte) error {
+	for i, c := range changes {
+		if !encryptedFields[c.Field] || c.Encrypted {
+			continue
+		}
+		for _, v := range []*string{&changes[i].Before, &changes[i].After} {
+			sealed, err := envelope.Seal(key, []byte(*v), auditAdditionalData(userID, c.Field))
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s: %w", c.Field, err)
+			}
+			*v = base64.StdEncoding.EncodeToString(sealed)
+		}
+		changes[i].Encrypted = true
+	}
+	return nil
+}
+
+// hasPlainPII reports whether changes contain PII fields which are not
+// encrypted.
+func hasPlainPII(changes FieldChanges) bool {
+	for _, c := range changes {
+		if encryptedFields[c.Field] && !c.Encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+// openChanges decrypts values of PII fields of audit entry. If key is nil,
+// they are cleared.
+func openChanges(changes FieldChanges, userID string, key []byte) error {
+	for i, c := range changes {
+		if !c.Encrypted {
+			continue
+		}
+		for _, v := range []*string{&changes[i].Before, &changes[i].After} {
+			if key == nil {
+				*v = ""
+				continue
+			}
+			sealed, err := base64.StdEncoding.DecodeString(*v)
+			if err != nil {
+				return fmt.Errorf("malformed encrypted %s: %w", c.Field, err)
+			}
+			plain, err := envelope.Open(key, sealed, auditAdditionalData(userID, c.Field))
+			if err != nil {
+				return fmt.Errorf("failed to decrypt %s: %w", c.Field, err)
+			}
+			*v = string(plain)
+		}
+		changes[i].Encrypted = false
+	}
+	return nil
+}
+
+func auditAdditionalData(userID, field string) []byte {
+	return []byte(userID + "/audit/" + field)
+}
+
+// sealPayload encrypts payload of delivery with data key of user, if user
+// is encrypted. Data keys are cached in keys by user id.
+func (s *store) sealPayload(ctx context.Context, d *WebhookDelivery, keys map[string][]byte) error {
+	if s.enc == nil || d.UserID == "" || d.PayloadEncrypted {
+		return nil
+	}
+	key, err := s.cachedDataKey(ctx, d.TenantID, d.UserID, keys)
+	if err != nil || key == nil {
+		return err
+	}
+	sealed, err := envelope.Seal(key, d.Payload, deliveryAdditionalData(d.UserID, d.EventID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt payload of event %s: %w", d.EventID, err)
+	}
+	d.Payload, d.PayloadEncrypted = sealed, true
+	return nil
+}
+
+// openPayload decrypts payload of delivery in place. If user was purged,
+// payload is cleared.
+func (s *store) openPayload(ctx context.Context, d *WebhookDelivery, keys map[string][]byte) error {
+	if !d.PayloadEncrypted {
+		return nil
+	}
+	key, err := s.cachedDataKey(ctx, d.TenantID, d.UserID, keys)
+	if err != nil {
+		return err
+	}
+	d.PayloadEncrypted = false
+	if key == nil {
+		d.Payload = nil
+		return nil
+	}
+	plain, err := envelope.Open(key, d.Payload, deliveryAdditionalData(d.UserID, d.EventID))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt payload of delivery %s: %w", d.ID, err)
+	}
+	d.Payload = plain
+	return nil
+}
+
+// cachedDataKey returns data key of user from keys, or gets it with
+// userDataKey and adds it to keys.
+func (s *store) cachedDataKey(ctx context.Context, tenantID, userID string, keys map[string][]byte) ([]byte, error) {
+	if key, ok := keys[userID]; ok {
+		return key, nil
+	}
+	key, err := s.userDataKey(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	keys[userID] = key
+	return key, nil
+}
+
+// deliveryAdditionalData binds sealed payload to user and event.
+func deliveryAdditionalData(userID, eventID string) []byte {
+	return []byte(userID + "/webhook/" + eventID)
+}
+
+// ReencryptUsers encrypts users of all tenants which are not encrypted yet
+// and rewraps data keys of users encrypted with other than current key
+// encryption key, in batches of batchSize. Values encrypted with data keys
+// are not re-encrypted. It returns number of updated users. Old key
+// encryption keys can be removed once it is done.
+func (s *store) ReencryptUsers(ctx context.Context, batchSize int) (int, error) {
+	if s.enc == nil {
+		return 0, fmt.Errorf("encryption is not enabled")
+	}
+	current := s.enc.keys.CurrentKeyID()
+	total, afterID := 0, ""
+	for {
+		var rows []*userRow
+		err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+			if err := tx.SelectContext(ctx, &rows, s.dialect.rebind(querySelectUsersToReencrypt), afterID, current, batchSize); err != nil {
+				return fmt.Errorf("failed to select users: %w", err)
+			}
+			for _, r := range rows {
+				wasEncrypted := r.encrypted()
+				updated, err := s.reencrypt(ctx, r)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.NamedExecContext(ctx, queryUpdateUserPII, updated); err != nil {
+					return fmt.Errorf("failed to update user %s: %w", r.ID, err)
+				}
+				if wasEncrypted {
+					continue
+				}
+				if err := s.sealHistory(ctx, tx, r.TenantID, r.ID, updated.dataKey); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += len(rows)
+		if len(rows) < batchSize {
+			return total, nil
+		}
+		afterID = rows[len(rows)-1].ID
+	}
+}
+
+// sealHistory encrypts PII in audit log and payloads of webhook deliveries
+// of user, which were recorded before user was encrypted.
+func (s *store) sealHistory(ctx context.Context, tx *sqlx.Tx, tenantID, userID string, key []byte) error {
+	var entries []*AuditEntry
+	if err := tx.SelectContext(ctx, &entries, s.dialect.rebind(querySelectAllAuditEntriesForUpdate), tenantID, userID); err != nil {
+		return fmt.Errorf("failed to select audit entries of user %s: %w", userID, err)
+	}
+	for _, e := range entries {
+		if !hasPlainPII(e.Changes) {
+			continue
+		}
+		if err := sealChanges(e.Changes, userID, key); err != nil {
+			return err
+		}
+		changes, err := e.Changes.Value()
+		if err != nil {
+			return fmt.Errorf("failed to marshal changes: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(queryUpdateAuditEntryChanges), changes, e.ID); err != nil {
+			return fmt.Errorf("failed to encrypt audit entry %d: %w", e.ID, err)
+		}
+	}
+	var deliveries []*WebhookDelivery
+	if err := tx.SelectContext(ctx, &deliveries, s.dialect.rebind(querySelectPlainUserWebhookDeliveries), false, tenantID, userID); err != nil {
+		return fmt.Errorf("failed to select webhook deliveries of user %s: %w", userID, err)
+	}
+	for _, d := range deliveries {
+		sealed, err := envelope.Seal(key, d.Payload, deliveryAdditionalData(userID, d.EventID))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt payload of delivery %s: %w", d.ID, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(queryUpdateWebhookDeliveryPayload), userID, sealed, true, d.ID); err != nil {
+			return fmt.Errorf("failed to encrypt webhook delivery %s: %w", d.ID, err)
+		}
+	}
+	return nil
+}
+
+// reencrypt returns row encrypted with current key encryption key.
+func (s *store) reencrypt(ctx context.Context, r *userRow) (*userRow, error) {
+	if !r.encrypted() {
+		return s.seal(ctx, &r.User, nil)
+	}
+	key, err := s.enc.keys.Unwrap(ctx, r.PIIKeyID, r.PIIKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of user %s: %w", r.ID, err)
+	}
+	out := *r
+	out.PIIKeyID, out.PIIKey, err = s.enc.keys.Wrap(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key of user %s: %w", r.ID, err)
+	}
+	return &out, nil
+}
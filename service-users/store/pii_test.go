@@ -0,0 +1,242 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/envelope"
+	"github.com/tobiaszheller/example-go-microservice/service-users/filter"
+)
+
+func TestSealOpenUser(t *testing.T) {
+	s := newEncryptedStore(t, "k1", "k1")
+	ctx := context.Background()
+	u := &User{ID: "id-1", FirstName: "John", LastName: "Doe", Nickname: "jd", Email: "John@Test.com", Country: "US"}
+
+	row, err := s.seal(ctx, u, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if row.FirstName != "" || row.LastName != "" || row.Email != "" {
+		t.Errorf("Expected plaintext PII columns to be empty, got: %v", row.User)
+	}
+	if row.Nickname != "jd" || row.PIIKeyID != "k1" {
+		t.Errorf("Expected nickname in plaintext and data key wrapped with k1, got: %v", row)
+	}
+	if row.EmailIndex != s.enc.blindIndex("john@test.com") {
+		t.Errorf("Expected blind index of lower cased email, got: %q", row.EmailIndex)
+	}
+
+	stored := &userRow{User: row.User, piiColumns: row.piiColumns}
+	if err := s.open(ctx, stored); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(*u, stored.User); diff != "" {
+		t.Errorf("Opened user mismatch, diff: %s", diff)
+	}
+
+	// Update keeps data key of user, so its audit entries remain readable.
+	updated, err := s.seal(ctx, &User{ID: "id-1", Email: "june@test.com"}, stored)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(updated.PIIKey, row.PIIKey) || !bytes.Equal(updated.dataKey, stored.dataKey) {
+		t.Errorf("Expected data key to be kept on update")
+	}
+
+	// Sealed fields cannot be moved to other user.
+	moved := &userRow{User: row.User, piiColumns: row.piiColumns}
+	moved.ID = "id-2"
+	if err := s.open(ctx, moved); !errors.Is(err, envelope.ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt, got: %v", err)
+	}
+
+	// Users written before encryption was enabled are read as they are.
+	plain := &userRow{User: *u}
+	if err := s.open(ctx, plain); err != nil || plain.User != *u {
+		t.Errorf("Expected plaintext user to be left as it is, got: %v, err: %v", plain.User, err)
+	}
+
+	// Encrypted users cannot be read when encryption is disabled.
+	if err := (&store{}).open(ctx, &userRow{User: row.User, piiColumns: row.piiColumns}); err == nil {
+		t.Errorf("Expected error when opening encrypted user without encryption")
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	old := newEncryptedStore(t, "k1", "k1")
+	ctx := context.Background()
+	u := &User{ID: "id-1", FirstName: "John", Email: "john@test.com"}
+	row, err := old.seal(ctx, u, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rotated := newEncryptedStore(t, "k2", "k1", "k2")
+	got, err := rotated.reencrypt(ctx, &userRow{User: row.User, piiColumns: row.piiColumns})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.PIIKeyID != "k2" {
+		t.Errorf("Expected data key to be rewrapped with k2, got: %q", got.PIIKeyID)
+	}
+	if !bytes.Equal(got.EmailEnc, row.EmailEnc) {
+		t.Errorf("Expected values not to be re-encrypted")
+	}
+	opened := &userRow{User: got.User, piiColumns: got.piiColumns}
+	if err := newEncryptedStore(t, "k2", "k2").open(ctx, opened); err != nil {
+		t.Fatalf("Expected user to be readable without old key, got: %v", err)
+	}
+	if opened.FirstName != "John" || opened.Email != "john@test.com" {
+		t.Errorf("Unexpected reencrypted user: %v", opened.User)
+	}
+
+	plain, err := rotated.reencrypt(ctx, &userRow{User: *u})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if plain.PIIKeyID != "k2" || plain.Email != "" || len(plain.EmailEnc) == 0 {
+		t.Errorf("Expected plaintext user to be encrypted, got: %v", plain)
+	}
+}
+
+func TestAuditChangesEncryption(t *testing.T) {
+	key, _ := envelope.NewDataKey()
+	changes := diffUsers(&User{ID: "id-1", Email: "john@test.com", Country: "US"}, &User{ID: "id-1", Email: "june@test.com", Country: "PL"})
+	if err := sealChanges(changes, "id-1", key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !changes[0].Encrypted || changes[0].After == "june@test.com" {
+		t.Errorf("Expected email change to be encrypted, got: %v", changes[0])
+	}
+	if changes[1].Encrypted || changes[1].After != "PL" {
+		t.Errorf("Expected country change not to be encrypted, got: %v", changes[1])
+	}
+
+	purged := append(FieldChanges{}, changes...)
+	if err := openChanges(changes, "id-1", key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := FieldChanges{
+		{Field: "email", Before: "john@test.com", After: "june@test.com"},
+		{Field: "country", Before: "US", After: "PL"},
+	}
+	if diff := cmp.Diff(exp, changes); diff != "" {
+		t.Errorf("Opened changes mismatch, diff: %s", diff)
+	}
+
+	// Data key is removed along with purged user.
+	if err := openChanges(purged, "id-1", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp[0] = FieldChange{Field: "email"}
+	if diff := cmp.Diff(exp, purged); diff != "" {
+		t.Errorf("Changes of purged user mismatch, diff: %s", diff)
+	}
+}
+
+func TestActiveEmailKeys(t *testing.T) {
+	s := newEncryptedStore(t, "k1", "k1")
+	index := s.enc.blindIndex("john@test.com")
+	keys, byKey := s.activeEmailKeys([]string{"John@Test.com"})
+	if diff := cmp.Diff([]string{"john@test.com", index}, keys); diff != "" {
+		t.Errorf("Keys mismatch, diff: %s", diff)
+	}
+	if diff := cmp.Diff(map[string]string{"john@test.com": "john@test.com", index: "john@test.com"}, byKey); diff != "" {
+		t.Errorf("Emails by key mismatch, diff: %s", diff)
+	}
+
+	keys, _ = (&store{}).activeEmailKeys([]string{"John@Test.com"})
+	if diff := cmp.Diff([]string{"john@test.com"}, keys); diff != "" {
+		t.Errorf("Keys without encryption mismatch, diff: %s", diff)
+	}
+}
+
+func TestCompileEncryptedFilter(t *testing.T) {
+	s := newEncryptedStore(t, "k1", "k1")
+	testCases := []struct {
+		desc    string
+		in      string
+		exp     string
+		expArgs []interface{}
+		expErr  string
+	}{
+		{
+			desc:    "email equality",
+			in:      `email = "john@test.com" AND country = "US"`,
+			exp:     "((email = ? OR email_index = ?) AND (country = ?))",
+			expArgs: []interface{}{"john@test.com", s.enc.blindIndex("john@test.com"), "US"},
+		},
+		{
+			desc:    "email inequality",
+			in:      `email != "john@test.com"`,
+			exp:     "NOT (email = ? OR email_index = ?)",
+			expArgs: []interface{}{"john@test.com", s.enc.blindIndex("john@test.com")},
+		},
+		{
+			desc:   "email wildcard",
+			in:     `email = "*@test.com"`,
+			expErr: `field is encrypted: "email" can be only compared with = or != without wildcards`,
+		},
+		{
+			desc:   "first name",
+			in:     `first_name = "John"`,
+			expErr: `field is encrypted: "first_name" cannot be filtered`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			e, err := filter.Parse(tC.in, UserFilterFields)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, args, err := compileFilter(e, s.enc, mysqlDialect)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tC.expErr {
+				t.Errorf("Expected error %q, got: %q", tC.expErr, gotErr)
+			}
+			if got != tC.exp {
+				t.Errorf("Expected condition %s, got: %s", tC.exp, got)
+			}
+			if diff := cmp.Diff(tC.expArgs, args); diff != "" {
+				t.Errorf("Args mismatch, diff: %s", diff)
+			}
+		})
+	}
+
+	_, _, _, err := compileOrder([]filter.OrderField{{Field: "last_name"}}, "t-1", "", true)
+	if !errors.Is(err, ErrFieldEncrypted) {
+		t.Errorf("Expected ErrFieldEncrypted when ordering by encrypted field, got: %v", err)
+	}
+}
+
+// newEncryptedStore returns store with encryption using keyfile with given
+// key ids, each key is filled with byte of second character of its id.
+func newEncryptedStore(t *testing.T, current string, ids ...string) *store {
+	t.Helper()
+	keys := "{"
+	for i, id := range ids {
+		if i > 0 {
+			keys += ", "
+		}
+		keys += fmt.Sprintf("%q: %q", id, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{id[1]}, envelope.DataKeySize)))
+	}
+	keys += "}"
+	index := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0}, envelope.DataKeySize))
+	kf, err := envelope.ParseKeyfile([]byte(fmt.Sprintf(`{"current_key": %q, "keys": %s, "blind_index_key": %q}`, current, keys, index)))
+	if err != nil {
+		t.Fatalf("Failed to parse keyfile: %v", err)
+	}
+	s := &store{}
+	s.EnableEncryption(kf, kf.BlindIndexKey)
+	return s
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// NewPostgres returns Postgres store writing to primary db. Reads of users
+// are spread across replicas, if any are given. Schema has to be migrated
+// with migrations of store/migrations/postgres.
+//
+// Unlike MySQL, Postgres compares strings case sensitively, so filters of
+// ListUsers are case sensitive. Uniqueness of emails is case insensitive
+// in both databases.
+func NewPostgres(db *sql.DB, replicas ...*sql.DB) *store {
+	return newStore(postgresDialect, db, replicas)
+}
+
+// isPostgresUniqueViolation tells whether err is unique_violation.
+func isPostgresUniqueViolation(err error) bool {
+	var pe *pq.Error
+	return errors.As(err, &pe) && pe.Code == "23505"
+}
+
+// classifyPostgresErr tells whether err is transient, ok is false if err
+// was not returned by Postgres.
+func classifyPostgresErr(err error) (errorClass, bool) {
+	var pe *pq.Error
+	if !errors.As(err, &pe) {
+		return errPermanent, false
+	}
+	switch pe.Code {
+	// deadlock_detected, serialization_failure, lock_not_available
+	case "40P01", "40001", "55P03":
+		return errConflict, true
+	// too_many_connections, admin_shutdown, crash_shutdown,
+	// cannot_connect_now
+	case "53300", "57P01", "57P02", "57P03":
+		return errConnection, true
+	}
+	// connection_exception
+	if pe.Code.Class() == "08" {
+		return errConnection, true
+	}
+	return errPermanent, true
+}
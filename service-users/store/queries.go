@@ -4,20 +4,40 @@ const (
 	queryInsertUser = `
 INSERT INTO users(
 	id,
+	tenant_id,
 	first_name,
 	last_name,
 	nickname,
 	email,
 	country,
-	updated_at
+	updated_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
 ) VALUES (
 	:id,
+	:tenant_id,
 	:first_name,
 	:last_name,
 	:nickname,
 	:email,
 	:country,
-	:updated_at
+	:updated_at,
+	:created_at,
+	:created_by,
+	:updated_by,
+	:pii_key_id,
+	:pii_key,
+	:first_name_enc,
+	:last_name_enc,
+	:email_enc,
+	:email_index
 );
 `
 	queryUpdateUser = `
@@ -29,23 +49,872 @@ SET
 	nickname = :nickname,
 	email = :email,
 	country = :country,
-	updated_at = :updated_at
+	updated_at = :updated_at,
+	updated_by = :updated_by,
+	pii_key_id = :pii_key_id,
+	pii_key = :pii_key,
+	first_name_enc = :first_name_enc,
+	last_name_enc = :last_name_enc,
+	email_enc = :email_enc,
+	email_index = :email_index
 WHERE
-   id = :id;
+	id = :id
+	AND tenant_id = :tenant_id;
 `
 
 	querySelectUserById = `
 SELECT
 	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+FROM
+	users
+WHERE
+	id = ?
+	AND tenant_id = ?
+	AND (deleted_at IS NULL OR ?);
+`
+
+	querySelectUserByIdForUpdate = `
+SELECT
+	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+FROM
+	users
+WHERE
+	id = ?
+	AND tenant_id = ?
+FOR UPDATE;
+`
+
+	// querySelectUsers has to be formatted with WHERE conditions.
+	querySelectUsers = `
+SELECT
+	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+FROM
+	users
+WHERE
+	%s
+ORDER BY
+	id
+LIMIT ?;
+`
+
+	// queryListUsers has to be formatted with WHERE conditions and ORDER BY
+	// fields.
+	queryListUsers = `
+SELECT
+	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+FROM
+	users
+WHERE
+	%s
+ORDER BY
+	%s
+LIMIT ?;
+`
+
+	// querySearchUsers has to be formatted with score expression and WHERE
+	// conditions.
+	querySearchUsers = `
+SELECT
+	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index,
+	%s AS score
+FROM
+	users
+WHERE
+	%s
+ORDER BY
+	score DESC,
+	id
+LIMIT ? OFFSET ?;
+`
+
+	// queryInsertUsers has to be formatted with rows of values.
+	queryInsertUsers = `
+INSERT INTO users(
+	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+) VALUES
+	%s;
+`
+
+	// queryUpdateUsers has to be formatted with SET assignments.
+	queryUpdateUsers = `
+UPDATE
+	users
+SET
+	%s
+WHERE
+	id IN (?)
+	AND tenant_id = ?;
+`
+
+	querySelectUsersByIdsForUpdate = `
+SELECT
+	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+FROM
+	users
+WHERE
+	id IN (?)
+	AND tenant_id = ?
+FOR UPDATE;
+`
+
+	querySelectActiveEmails = `
+SELECT
+	id,
+	active_email
+FROM
+	users
+WHERE
+	tenant_id = ?
+	AND active_email IN (?);
+`
+
+	querySetUserDeletedAt = `
+UPDATE
+	users
+SET
+	deleted_at = ?
+WHERE
+	id = ?
+	AND tenant_id = ?;
+`
+
+	querySelectUsersDeletedBefore = `
+SELECT
+	id,
+	tenant_id,
+	first_name,
+	last_name,
+	nickname,
+	email,
+	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+FROM
+	users
+WHERE
+	deleted_at < ?
+ORDER BY
+	deleted_at
+LIMIT ?
+FOR UPDATE;
+`
+
+	// querySelectUsersToReencrypt selects users of all tenants which are not
+	// encrypted with given key. Erased users have nothing to encrypt.
+	querySelectUsersToReencrypt = `
+SELECT
+	id,
+	tenant_id,
 	first_name,
 	last_name,
 	nickname,
 	email,
 	country,
+	updated_at,
+	deleted_at,
+	erased_at,
+	created_at,
+	created_by,
+	updated_by,
+	pii_key_id,
+	pii_key,
+	first_name_enc,
+	last_name_enc,
+	email_enc,
+	email_index
+FROM
+	users
+WHERE
+	id > ?
+	AND pii_key_id != ?
+	AND erased_at IS NULL
+ORDER BY
+	id
+LIMIT ?
+FOR UPDATE;
+`
+
+	queryUpdateUserPII = `
+UPDATE
+	users
+SET
+	first_name = :first_name,
+	last_name = :last_name,
+	email = :email,
+	pii_key_id = :pii_key_id,
+	pii_key = :pii_key,
+	first_name_enc = :first_name_enc,
+	last_name_enc = :last_name_enc,
+	email_enc = :email_enc,
+	email_index = :email_index
+WHERE
+	id = :id;
+`
+
+	querySelectUserKey = `
+SELECT
+	pii_key_id,
+	pii_key
+FROM
+	users
+WHERE
+	id = ?
+	AND tenant_id = ?;
+`
+
+	queryDeleteUsers = `
+DELETE FROM
+	users
+WHERE
+	id IN (?);
+`
+)
+
+const (
+	queryInsertWebhookSubscription = `
+INSERT INTO webhook_subscriptions(
+	id,
+	tenant_id,
+	url,
+	event_types,
+	secret,
+	created_at
+) VALUES (
+	:id,
+	:tenant_id,
+	:url,
+	:event_types,
+	:secret,
+	:created_at
+);
+`
+
+	querySelectWebhookSubscriptionById = `
+SELECT
+	id,
+	tenant_id,
+	url,
+	event_types,
+	secret,
+	created_at
+FROM
+	webhook_subscriptions
+WHERE
+	id = ?
+	AND tenant_id = ?;
+`
+
+	querySelectWebhookSubscriptions = `
+SELECT
+	id,
+	tenant_id,
+	url,
+	event_types,
+	secret,
+	created_at
+FROM
+	webhook_subscriptions
+WHERE
+	tenant_id = ?
+	AND id > ?
+ORDER BY
+	id
+LIMIT ?;
+`
+
+	querySelectWebhookSubscriptionsByEventType = `
+SELECT
+	id,
+	tenant_id,
+	url,
+	event_types,
+	secret,
+	created_at
+FROM
+	webhook_subscriptions
+WHERE
+	tenant_id = ?
+	AND (event_types = '' OR FIND_IN_SET(?, event_types) > 0);
+`
+
+	queryDeleteWebhookSubscription = `
+DELETE FROM
+	webhook_subscriptions
+WHERE
+	id = ?
+	AND tenant_id = ?;
+`
+
+	queryInsertWebhookDelivery = `
+INSERT INTO webhook_deliveries(
+	id,
+	tenant_id,
+	subscription_id,
+	event_id,
+	event_type,
+	user_id,
+	payload,
+	payload_encrypted,
+	status,
+	attempt_count,
+	next_attempt_at,
+	created_at,
+	updated_at
+) VALUES (
+	:id,
+	:tenant_id,
+	:subscription_id,
+	:event_id,
+	:event_type,
+	:user_id,
+	:payload,
+	:payload_encrypted,
+	:status,
+	:attempt_count,
+	:next_attempt_at,
+	:created_at,
+	:updated_at
+);
+`
+
+	queryUpdateWebhookDelivery = `
+UPDATE
+	webhook_deliveries
+SET
+	status = :status,
+	attempt_count = :attempt_count,
+	next_attempt_at = :next_attempt_at,
+	updated_at = :updated_at
+WHERE
+	id = :id;
+`
+
+	querySelectWebhookDeliveryById = `
+SELECT
+	id,
+	tenant_id,
+	subscription_id,
+	event_id,
+	event_type,
+	user_id,
+	payload,
+	payload_encrypted,
+	status,
+	attempt_count,
+	next_attempt_at,
+	created_at,
+	updated_at
+FROM
+	webhook_deliveries
+WHERE
+	id = ?
+	AND tenant_id = ?;
+`
+
+	querySelectWebhookDeliveries = `
+SELECT
+	id,
+	tenant_id,
+	subscription_id,
+	event_id,
+	event_type,
+	user_id,
+	payload,
+	payload_encrypted,
+	status,
+	attempt_count,
+	next_attempt_at,
+	created_at,
+	updated_at
+FROM
+	webhook_deliveries
+WHERE
+	tenant_id = ?
+	AND subscription_id = ?
+	AND (? = '' OR status = ?)
+	AND id > ?
+ORDER BY
+	id
+LIMIT ?;
+`
+
+	querySelectDueWebhookDeliveries = `
+SELECT
+	id,
+	tenant_id,
+	subscription_id,
+	event_id,
+	event_type,
+	user_id,
+	payload,
+	payload_encrypted,
+	status,
+	attempt_count,
+	next_attempt_at,
+	created_at,
 	updated_at
 FROM
+	webhook_deliveries
+WHERE
+	status = ?
+	AND next_attempt_at <= ?
+ORDER BY
+	next_attempt_at
+LIMIT ?
+FOR UPDATE;
+`
+
+	queryLeaseWebhookDeliveries = `
+UPDATE
+	webhook_deliveries
+SET
+	next_attempt_at = ?
+WHERE
+	id IN (?);
+`
+
+	queryInsertWebhookDeliveryAttempt = `
+INSERT INTO webhook_delivery_attempts(
+	delivery_id,
+	number,
+	attempted_at,
+	response_status,
+	error,
+	duration_ms
+) VALUES (
+	:delivery_id,
+	:number,
+	:attempted_at,
+	:response_status,
+	:error,
+	:duration_ms
+);
+`
+
+	querySelectWebhookDeliveryAttempts = `
+SELECT
+	a.delivery_id,
+	a.number,
+	a.attempted_at,
+	a.response_status,
+	a.error,
+	a.duration_ms
+FROM
+	webhook_delivery_attempts a
+	JOIN webhook_deliveries d ON d.id = a.delivery_id
+WHERE
+	a.delivery_id = ?
+	AND d.tenant_id = ?
+ORDER BY
+	a.number;
+`
+)
+
+const (
+	queryInsertAuditEntry = `
+INSERT INTO users_audit_log(
+	tenant_id,
+	user_id,
+	action,
+	actor,
+	request_id,
+	changes,
+	created_at
+) VALUES (
+	:tenant_id,
+	:user_id,
+	:action,
+	:actor,
+	:request_id,
+	:changes,
+	:created_at
+);
+`
+
+	querySelectAuditEntries = `
+SELECT
+	id,
+	user_id,
+	action,
+	actor,
+	request_id,
+	changes,
+	created_at
+FROM
+	users_audit_log
+WHERE
+	tenant_id = ?
+	AND user_id = ?
+	AND (? = 0 OR id < ?)
+ORDER BY
+	id DESC
+LIMIT ?;
+`
+)
+
+const (
+	queryInsertUserEvent = `
+INSERT INTO users_events(
+	id,
+	tenant_id,
+	user_id,
+	type,
+	created_at
+) VALUES (
+	:id,
+	:tenant_id,
+	:user_id,
+	:type,
+	:created_at
+);
+`
+
+	querySelectUserEvents = `
+SELECT
+	id,
+	tenant_id,
+	user_id,
+	type,
+	created_at
+FROM
+	users_events
+WHERE
+	tenant_id = ?
+	AND user_id = ?
+ORDER BY
+	created_at,
+	id;
+`
+
+	queryInsertErasure = `
+INSERT INTO users_erasures(
+	id,
+	tenant_id,
+	user_id,
+	reason,
+	actor,
+	request_id,
+	erased_at
+) VALUES (
+	:id,
+	:tenant_id,
+	:user_id,
+	:reason,
+	:actor,
+	:request_id,
+	:erased_at
+);
+`
+
+	querySelectErasures = `
+SELECT
+	id,
+	tenant_id,
+	user_id,
+	reason,
+	actor,
+	request_id,
+	erased_at
+FROM
+	users_erasures
+WHERE
+	tenant_id = ?
+	AND user_id = ?
+ORDER BY
+	erased_at;
+`
+
+	queryEraseUser = `
+UPDATE
 	users
+SET
+	first_name = '',
+	last_name = '',
+	nickname = '',
+	email = '',
+	country = '',
+	pii_key_id = '',
+	pii_key = NULL,
+	first_name_enc = NULL,
+	last_name_enc = NULL,
+	email_enc = NULL,
+	email_index = '',
+	deleted_at = COALESCE(deleted_at, ?),
+	erased_at = ?,
+	updated_at = ?,
+	updated_by = ?
+WHERE
+	id = ?
+	AND tenant_id = ?;
+`
+
+	querySelectAllAuditEntriesForUpdate = `
+SELECT
+	id,
+	tenant_id,
+	user_id,
+	action,
+	actor,
+	request_id,
+	changes,
+	created_at
+FROM
+	users_audit_log
+WHERE
+	tenant_id = ?
+	AND user_id = ?
+ORDER BY
+	id
+FOR UPDATE;
+`
+
+	queryUpdateAuditEntryChanges = `
+UPDATE
+	users_audit_log
+SET
+	changes = ?
 WHERE
 	id = ?;
 `
+
+	// querySelectPlainUserWebhookDeliveries selects deliveries of events
+	// about user, payloads of which are not encrypted yet.
+	querySelectPlainUserWebhookDeliveries = `
+SELECT
+	id,
+	event_id,
+	payload
+FROM
+	webhook_deliveries
+WHERE
+	payload_encrypted = ?
+	AND event_id IN (
+		SELECT
+			id
+		FROM
+			users_events
+		WHERE
+			tenant_id = ?
+			AND user_id = ?
+	);
+`
+
+	queryUpdateWebhookDeliveryPayload = `
+UPDATE
+	webhook_deliveries
+SET
+	user_id = ?,
+	payload = ?,
+	payload_encrypted = ?
+WHERE
+	id = ?;
+`
+
+	// queryDeleteUserWebhookDeliveries deletes deliveries of events about
+	// user, as their payloads contain its personal data.
+	queryDeleteUserWebhookDeliveries = `
+DELETE
+	d
+FROM
+	webhook_deliveries d
+	JOIN users_events e ON e.id = d.event_id
+WHERE
+	e.tenant_id = ?
+	AND e.user_id = ?;
+`
+)
+
+// Queries of Postgres dialect which differ from MySQL ones.
+const (
+	queryPostgresSelectWebhookSubscriptionsByEventType = `
+SELECT
+	id,
+	tenant_id,
+	url,
+	event_types,
+	secret,
+	created_at
+FROM
+	webhook_subscriptions
+WHERE
+	tenant_id = ?
+	AND (event_types = '' OR ? = ANY(string_to_array(event_types, ',')));
+`
+
+	queryPostgresDeleteUserWebhookDeliveries = `
+DELETE FROM
+	webhook_deliveries d
+	USING users_events e
+WHERE
+	e.id = d.event_id
+	AND e.tenant_id = ?
+	AND e.user_id = ?;
+`
+)
+
+// Queries of SQLite dialect which differ from MySQL ones.
+const (
+	querySQLiteSelectWebhookSubscriptionsByEventType = `
+SELECT
+	id,
+	tenant_id,
+	url,
+	event_types,
+	secret,
+	created_at
+FROM
+	webhook_subscriptions
+WHERE
+	tenant_id = ?
+	AND (event_types = '' OR instr(',' || event_types || ',', ',' || ? || ',') > 0);
+`
+
+	querySQLiteDeleteUserWebhookDeliveries = `
+DELETE FROM
+	webhook_deliveries
+WHERE
+	event_id IN (
+		SELECT
+			id
+		FROM
+			users_events
+		WHERE
+			tenant_id = ?
+			AND user_id = ?
+	);
+`
 )
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	targetPrimary = "primary"
+	targetReplica = "replica"
+)
+
+var queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "users_store_read_queries_total",
+	Help: "Total number of store read queries by method and database they were routed to.",
+}, []string{"method", "target"})
+
+// replica is read only copy of primary database.
+type replica struct {
+	db *sqlx.DB
+	// healthy is 1 when replica can serve reads.
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	if atomic.SwapInt32(&r.healthy, v) != v {
+		log.WithField("healthy", healthy).Warn("DB replica health changed")
+	}
+}
+
+// replicaSet balances reads between healthy replicas in round-robin manner.
+type replicaSet struct {
+	replicas []*replica
+	next     uint32
+}
+
+func newReplicaSet(driverName string, dbs []*sql.DB) *replicaSet {
+	rs := &replicaSet{}
+	for _, db := range dbs {
+		rs.replicas = append(rs.replicas, &replica{db: sqlx.NewDb(db, driverName), healthy: 1})
+	}
+	return rs
+}
+
+// pick returns next healthy replica or nil if there is none.
+func (rs *replicaSet) pick() *replica {
+	n := uint32(len(rs.replicas))
+	if n == 0 {
+		return nil
+	}
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if r := rs.replicas[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+// checkHealth pings all replicas and updates their health.
+func (rs *replicaSet) checkHealth(ctx context.Context, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			r.setHealthy(r.db.PingContext(ctx) == nil)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// RunReplicaHealthChecks pings replicas every interval until ctx is done.
+// Unhealthy replicas don't serve reads until they respond again.
+func (s *store) RunReplicaHealthChecks(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.replicas.checkHealth(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// read runs fn on replica, unless reads must go to primary. If replica fails,
+// it is marked as unhealthy and fn is retried on primary.
+func (s *store) read(ctx context.Context, method string, o readOptions, fn func(*sqlx.DB) error) error {
+	if !o.primary && !sessionWrote(ctx) {
+		if r := s.replicas.pick(); r != nil {
+			queriesTotal.WithLabelValues(method, targetReplica).Inc()
+			err := fn(r.db)
+			if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+				return err
+			}
+			log.WithError(err).Warn("DB replica read failed, retrying on primary")
+			r.setHealthy(false)
+		}
+	}
+	queriesTotal.WithLabelValues(method, targetPrimary).Inc()
+	return fn(s.db)
+}
+
+type sessionKey struct{}
+
+// session tracks whether request wrote anything.
+type session struct {
+	wrote int32
+}
+
+// NewSessionContext returns context in which all reads after successful write
+// are sent to primary, so caller can read its own writes despite replication
+// lag. Typically it is created once per incoming request.
+func NewSessionContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+func markSessionWrote(ctx context.Context) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		atomic.StoreInt32(&s.wrote, 1)
+	}
+}
+
+func sessionWrote(ctx context.Context) bool {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	return ok && atomic.LoadInt32(&s.wrote) == 1
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestStoreRead(t *testing.T) {
+	errFailed := errors.New("failed")
+	testCases := []struct {
+		desc       string
+		ctx        func() context.Context
+		opts       []ReadOption
+		replicaErr error
+		expDBs     []string
+		expErr     error
+		expHealthy bool
+	}{
+		{
+			desc:       "reads from replica",
+			ctx:        context.Background,
+			expDBs:     []string{"replica"},
+			expHealthy: true,
+		},
+		{
+			desc:       "forced primary read",
+			ctx:        context.Background,
+			opts:       []ReadOption{FromPrimary()},
+			expDBs:     []string{"primary"},
+			expHealthy: true,
+		},
+		{
+			desc: "read after write in the same session",
+			ctx: func() context.Context {
+				ctx := NewSessionContext(context.Background())
+				markSessionWrote(ctx)
+				return ctx
+			},
+			expDBs:     []string{"primary"},
+			expHealthy: true,
+		},
+		{
+			desc:       "session without writes",
+			ctx:        func() context.Context { return NewSessionContext(context.Background()) },
+			expDBs:     []string{"replica"},
+			expHealthy: true,
+		},
+		{
+			desc:       "not found is not replica failure",
+			ctx:        context.Background,
+			replicaErr: sql.ErrNoRows,
+			expDBs:     []string{"replica"},
+			expErr:     sql.ErrNoRows,
+			expHealthy: true,
+		},
+		{
+			desc:       "failed replica falls back to primary",
+			ctx:        context.Background,
+			replicaErr: errFailed,
+			expDBs:     []string{"replica", "primary"},
+			expHealthy: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			primary, replica := mustOpenDB(t), mustOpenDB(t)
+			s := New(primary, replica)
+			var got []string
+			err := s.read(tc.ctx(), "GetUser", newReadOptions(tc.opts), func(db *sqlx.DB) error {
+				if db.DB == primary {
+					got = append(got, "primary")
+					return nil
+				}
+				got = append(got, "replica")
+				return tc.replicaErr
+			})
+			if !errors.Is(err, tc.expErr) {
+				t.Errorf("Expected error %v, got: %v", tc.expErr, err)
+			}
+			if len(got) != len(tc.expDBs) {
+				t.Fatalf("Expected reads from %v, got: %v", tc.expDBs, got)
+			}
+			for i := range got {
+				if got[i] != tc.expDBs[i] {
+					t.Fatalf("Expected reads from %v, got: %v", tc.expDBs, got)
+				}
+			}
+			if healthy := s.replicas.replicas[0].isHealthy(); healthy != tc.expHealthy {
+				t.Errorf("Expected replica healthy %t, got: %t", tc.expHealthy, healthy)
+			}
+		})
+	}
+}
+
+func TestReplicaSetPick(t *testing.T) {
+	rs := newReplicaSet("mysql", []*sql.DB{mustOpenDB(t), mustOpenDB(t), mustOpenDB(t)})
+	counts := map[*replica]int{}
+	for i := 0; i < 6; i++ {
+		counts[rs.pick()]++
+	}
+	for _, r := range rs.replicas {
+		if counts[r] != 2 {
+			t.Errorf("Expected reads to be balanced, got: %v", counts)
+		}
+	}
+
+	rs.replicas[0].setHealthy(false)
+	rs.replicas[2].setHealthy(false)
+	for i := 0; i < 3; i++ {
+		if got := rs.pick(); got != rs.replicas[1] {
+			t.Errorf("Expected only healthy replica to be picked")
+		}
+	}
+
+	rs.replicas[1].setHealthy(false)
+	if got := rs.pick(); got != nil {
+		t.Errorf("Expected no replica when all are unhealthy, got: %v", got)
+	}
+	if got := newReplicaSet("mysql", nil).pick(); got != nil {
+		t.Errorf("Expected no replica when none are configured, got: %v", got)
+	}
+}
+
+// mustOpenDB returns handle which never connects to anything.
+func mustOpenDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("mysql", "user:password@tcp(127.0.0.1:1)/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
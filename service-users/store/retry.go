@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ErrConflict is returned when transaction failed due to deadlock or
+	// lock wait timeout, also after retries. Request can be retried.
+	ErrConflict = errors.New("transaction conflict")
+	// ErrUnavailable is returned when connection to database failed.
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// RetryPolicy of transactions failed due to transient errors.
+type RetryPolicy struct {
+	// MaxAttempts is number of attempts including the first one.
+	MaxAttempts int
+	// InitialBackoff is doubled after every attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is retry policy of store returned by New.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 20 * time.Millisecond,
+	MaxBackoff:     500 * time.Millisecond,
+}
+
+// SetRetryPolicy sets retry policy of transactions.
+func (s *store) SetRetryPolicy(p RetryPolicy) {
+	s.retry = p
+}
+
+var txRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "users_store_tx_retries_total",
+	Help: "Total number of retried transactions by reason.",
+}, []string{"reason"})
+
+// errorClass tells whether and why error is transient.
+type errorClass int
+
+const (
+	errPermanent errorClass = iota
+	errConflict
+	errConnection
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errConflict:
+		return "conflict"
+	case errConnection:
+		return "connection"
+	}
+	return "permanent"
+}
+
+// classifyErr tells whether err is transient, i.e. operation may succeed if
+// retried.
+func classifyErr(err error) errorClass {
+	if class, ok := classifyPostgresErr(err); ok {
+		return class
+	}
+	if class, ok := classifySQLiteErr(err); ok {
+		return class
+	}
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		switch me.Number {
+		// ER_LOCK_DEADLOCK, ER_LOCK_WAIT_TIMEOUT
+		case 1213, 1205:
+			return errConflict
+		// ER_CON_COUNT_ERROR, ER_SERVER_SHUTDOWN, CR_SERVER_GONE_ERROR,
+		// CR_SERVER_LOST
+		case 1040, 1053, 2006, 2013:
+			return errConnection
+		}
+		return errPermanent
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errConnection
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return errConnection
+	}
+	return errPermanent
+}
+
+// transientError is transient error which was not retried or failed again.
+// It matches sentinel of its class with errors.Is.
+type transientError struct {
+	sentinel error
+	err      error
+}
+
+func (e *transientError) Error() string {
+	return fmt.Sprintf("%v: %v", e.sentinel, e.err)
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func (e *transientError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+// wrapTransient returns err matching ErrConflict or ErrUnavailable if it is
+// transient, otherwise err as it is.
+func wrapTransient(err error) error {
+	switch classifyErr(err) {
+	case errConflict:
+		return &transientError{sentinel: ErrConflict, err: err}
+	case errConnection:
+		return &transientError{sentinel: ErrUnavailable, err: err}
+	}
+	return err
+}
+
+// inTx runs fn in transaction. Transactions failed due to transient errors
+// are retried according to retry policy, as long as deadline of ctx allows,
+// so fn must be safe to run again. Transaction which lost connection on
+// commit is not retried, as it could have been committed.
+func (s *store) inTx(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	backoff := s.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		committing, err := s.tryTx(ctx, fn)
+		if err == nil {
+			markSessionWrote(ctx)
+			return nil
+		}
+		class := classifyErr(err)
+		if class == errPermanent {
+			return err
+		}
+		if attempt >= s.retry.MaxAttempts || (committing && class == errConnection) || !sleep(ctx, backoff) {
+			return wrapTransient(err)
+		}
+		txRetriesTotal.WithLabelValues(class.String()).Inc()
+		if backoff *= 2; backoff > s.retry.MaxBackoff {
+			backoff = s.retry.MaxBackoff
+		}
+	}
+}
+
+// tryTx runs fn in transaction and reports whether error occurred on
+// commit.
+func (s *store) tryTx(ctx context.Context, fn func(*sqlx.Tx) error) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return true, fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return false, nil
+}
+
+// sleep waits for backoff with jitter. It returns false without waiting if
+// ctx would be done before.
+func sleep(ctx context.Context, backoff time.Duration) bool {
+	if backoff > 0 {
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+		return false
+	}
+	t := time.NewTimer(backoff)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestClassifyErr(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		in          error
+		exp         errorClass
+		expSentinel error
+	}{
+		{
+			desc:        "deadlock",
+			in:          fmt.Errorf("failed to update user: %w", &mysql.MySQLError{Number: 1213}),
+			exp:         errConflict,
+			expSentinel: ErrConflict,
+		},
+		{
+			desc:        "lock wait timeout",
+			in:          &mysql.MySQLError{Number: 1205},
+			exp:         errConflict,
+			expSentinel: ErrConflict,
+		},
+		{
+			desc:        "bad connection",
+			in:          fmt.Errorf("failed to begin tx: %w", driver.ErrBadConn),
+			exp:         errConnection,
+			expSentinel: ErrUnavailable,
+		},
+		{
+			desc:        "invalid connection",
+			in:          mysql.ErrInvalidConn,
+			exp:         errConnection,
+			expSentinel: ErrUnavailable,
+		},
+		{
+			desc: "duplicate entry",
+			in:   &mysql.MySQLError{Number: 1062},
+			exp:  errPermanent,
+		},
+		{
+			desc:        "postgres deadlock",
+			in:          fmt.Errorf("failed to update user: %w", &pq.Error{Code: "40P01"}),
+			exp:         errConflict,
+			expSentinel: ErrConflict,
+		},
+		{
+			desc:        "postgres connection failure",
+			in:          &pq.Error{Code: "08006"},
+			exp:         errConnection,
+			expSentinel: ErrUnavailable,
+		},
+		{
+			desc: "postgres unique violation",
+			in:   &pq.Error{Code: "23505"},
+			exp:  errPermanent,
+		},
+		{
+			desc: "not found",
+			in:   ErrUserNotFound,
+			exp:  errPermanent,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if got := classifyErr(tC.in); got != tC.exp {
+				t.Errorf("Expected class %v, got: %v", tC.exp, got)
+			}
+			wrapped := wrapTransient(tC.in)
+			if tC.expSentinel == nil {
+				if wrapped != tC.in {
+					t.Errorf("Expected permanent error to be returned as it is, got: %v", wrapped)
+				}
+				return
+			}
+			if !errors.Is(wrapped, tC.expSentinel) {
+				t.Errorf("Expected error matching %v, got: %v", tC.expSentinel, wrapped)
+			}
+			if !errors.Is(wrapped, tC.in) {
+				t.Errorf("Expected error wrapping original one, got: %v", wrapped)
+			}
+		})
+	}
+}
+
+func TestSleepRespectsDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if sleep(ctx, time.Hour) {
+		t.Errorf("Expected sleep beyond deadline to be skipped")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected sleep to return without waiting")
+	}
+	if !sleep(context.Background(), time.Millisecond) {
+		t.Errorf("Expected short sleep to succeed")
+	}
+}
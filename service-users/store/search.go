@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// ngramTokenSize must match ngram_token_size of MySQL server, which
+	// defaults to 2.
+	ngramTokenSize = 2
+	// exactMatchWeight boosts score of users matching all words of fuzzy
+	// search, so they are ranked before users matching only with typos.
+	exactMatchWeight = 10
+
+	matchSearchColumns = "MATCH(first_name, last_name, nickname, email)"
+	// tsvectorSearchColumns must match expression of users_search index of
+	// Postgres, otherwise index is not used.
+	tsvectorSearchColumns = "to_tsvector('simple', first_name || ' ' || last_name || ' ' || nickname || ' ' || email)"
+	likeSearchColumns     = "(first_name || ' ' || last_name || ' ' || nickname || ' ' || email)"
+)
+
+// SearchQuery represents parameters of SearchUsers.
+type SearchQuery struct {
+	// Text is searched in first name, last name, nickname and email. Every
+	// word must match, unless search is fuzzy. Encrypted fields cannot be
+	// indexed, so with encryption enabled Text must be exact email, other
+	// queries fail with ErrFieldEncrypted.
+	Text string
+	// Fuzzy makes words match also with typos.
+	Fuzzy bool
+	// Countries, if not empty only users from given countries are searched.
+	Countries []string
+}
+
+// SearchResult is user found by SearchUsers along with its relevance.
+type SearchResult struct {
+	User
+	Score float64 `db:"score"`
+}
+
+// SearchUsers returns up to limit users matching query, starting at offset,
+// ordered by relevance. It uses users_search full-text index.
+func (s *store) SearchUsers(ctx context.Context, q SearchQuery, offset, limit int, opts ...ReadOption) ([]*SearchResult, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o := newReadOptions(opts)
+	score, args, match, matchArgs := s.dialect.textSearch(q)
+	if s.enc != nil && match != "" {
+		if score, args, match, matchArgs, err = encryptedTextSearch(q, s.enc); err != nil {
+			return nil, err
+		}
+	}
+	if match == "" {
+		return nil, nil
+	}
+	where, whereArgs := []string{"tenant_id = ?", match}, append([]interface{}{tenantID}, matchArgs...)
+	if len(q.Countries) > 0 {
+		where = append(where, "country IN (?)")
+		whereArgs = append(whereArgs, q.Countries)
+	}
+	if !o.showDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	args = append(args, whereArgs...)
+	args = append(args, limit, offset)
+	query, args, err := sqlx.In(fmt.Sprintf(querySearchUsers, score, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build search query: %w", err)
+	}
+	var rows []*struct {
+		userRow
+		Score float64 `db:"score"`
+	}
+	err = s.read(ctx, "SearchUsers", o, func(db *sqlx.DB) error {
+		return db.SelectContext(ctx, &rows, s.dialect.rebind(query), args...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", wrapTransient(err))
+	}
+	out := make([]*SearchResult, 0, len(rows))
+	for _, r := range rows {
+		if err := s.open(ctx, &r.userRow); err != nil {
+			return nil, err
+		}
+		out = append(out, &SearchResult{User: r.User, Score: r.Score})
+	}
+	return out, nil
+}
+
+// encryptedTextSearch matches users by exact email, the only searchable
+// encrypted field, using its blind index. Users which are not encrypted yet
+// still have plaintext email. Other queries would miss encrypted fields of
+// most users, so they fail instead of returning partial results.
+func encryptedTextSearch(q SearchQuery, enc *encryption) (string, []interface{}, string, []interface{}, error) {
+	words := strings.Fields(q.Text)
+	if len(words) != 1 || !strings.Contains(words[0], "@") {
+		return "", nil, "", nil, fmt.Errorf("%w: only exact email can be searched", ErrFieldEncrypted)
+	}
+	if q.Fuzzy {
+		return "", nil, "", nil, fmt.Errorf("%w: email cannot be searched with fuzzy", ErrFieldEncrypted)
+	}
+	return "1", nil, "(email = ? OR email_index = ?)", []interface{}{words[0], enc.blindIndex(words[0])}, nil
+}
+
+// mysqlTextSearch matches users by users_search FULLTEXT index in boolean
+// mode, or in natural language mode if search is fuzzy.
+func mysqlTextSearch(q SearchQuery) (string, []interface{}, string, []interface{}) {
+	boolean := booleanQuery(q.Text)
+	if boolean == "" {
+		return "", nil, "", nil
+	}
+	exact := matchSearchColumns + " AGAINST (? IN BOOLEAN MODE)"
+	if !q.Fuzzy {
+		return exact, []interface{}{boolean}, exact, []interface{}{boolean}
+	}
+	// In natural language mode n-grams of text are alternatives, so users
+	// sharing only some of them are found as well.
+	text := strings.ReplaceAll(q.Text, `"`, " ")
+	fuzzy := matchSearchColumns + " AGAINST (?)"
+	score := fmt.Sprintf("%s * %d + %s", exact, exactMatchWeight, fuzzy)
+	return score, []interface{}{boolean, text}, fuzzy, []interface{}{text}
+}
+
+// postgresTextSearch matches users by users_search GIN index. Postgres has
+// no n-gram parser, so words match only by prefix of words of indexed
+// fields. Fuzzy search requires any of words instead of all of them.
+func postgresTextSearch(q SearchQuery) (string, []interface{}, string, []interface{}) {
+	terms := prefixTerms(q.Text)
+	if len(terms) == 0 {
+		return "", nil, "", nil
+	}
+	all := strings.Join(terms, " & ")
+	rank := "ts_rank(" + tsvectorSearchColumns + ", to_tsquery('simple', ?))"
+	match := tsvectorSearchColumns + " @@ to_tsquery('simple', ?)"
+	if !q.Fuzzy {
+		return rank, []interface{}{all}, match, []interface{}{all}
+	}
+	some := strings.Join(terms, " | ")
+	score := fmt.Sprintf("%s * %d + %s", rank, exactMatchWeight, rank)
+	return score, []interface{}{all, some}, match, []interface{}{some}
+}
+
+// sqliteTextSearch matches words anywhere in searched fields with LIKE,
+// without any index, so it is meant only for small databases used in
+// development and tests. Fuzzy search matches any of n-grams of words, the
+// same as natural language mode of MySQL, and score is number of matched
+// n-grams.
+func sqliteTextSearch(q SearchQuery) (string, []interface{}, string, []interface{}) {
+	words := strings.Fields(q.Text)
+	if len(words) == 0 {
+		return "", nil, "", nil
+	}
+	exact, exactArgs := likeConds(words)
+	all := "(" + strings.Join(exact, " AND ") + ")"
+	if !q.Fuzzy {
+		return all, exactArgs, all, exactArgs
+	}
+	var grams []string
+	for _, w := range words {
+		grams = append(grams, ngrams(w)...)
+	}
+	fuzzy, fuzzyArgs := likeConds(grams)
+	score := fmt.Sprintf("%s * %d + (%s)", all, exactMatchWeight, strings.Join(fuzzy, ") + ("))
+	return score, append(exactArgs, fuzzyArgs...), "(" + strings.Join(fuzzy, " OR ") + ")", fuzzyArgs
+}
+
+// likeConds returns conditions matching terms anywhere in searched fields,
+// along with their args.
+func likeConds(terms []string) ([]string, []interface{}) {
+	conds := make([]string, 0, len(terms))
+	args := make([]interface{}, 0, len(terms))
+	for _, t := range terms {
+		conds = append(conds, likeSearchColumns+" LIKE ?"+sqliteLikeEscape)
+		args = append(args, "%"+likePattern(t)+"%")
+	}
+	return conds, args
+}
+
+// ngrams returns n-grams of word of ngramTokenSize, or word itself if it is
+// shorter.
+func ngrams(word string) []string {
+	runes := []rune(word)
+	if len(runes) <= ngramTokenSize {
+		return []string{word}
+	}
+	out := make([]string, 0, len(runes)-ngramTokenSize+1)
+	for i := 0; i+ngramTokenSize <= len(runes); i++ {
+		out = append(out, string(runes[i:i+ngramTokenSize]))
+	}
+	return out
+}
+
+// prefixTerms returns tsquery terms matching words of text by prefix.
+// Operators of tsquery are removed from words, other characters are left,
+// so e.g. email is parsed as single lexeme, the same as in indexed fields.
+func prefixTerms(text string) []string {
+	var terms []string
+	for _, w := range strings.Fields(tsqueryOperators.Replace(strings.ToLower(text))) {
+		if strings.IndexFunc(w, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		terms = append(terms, w+":*")
+	}
+	return terms
+}
+
+var tsqueryOperators = strings.NewReplacer(
+	"&", " ", "|", " ", "!", " ", "(", " ", ")", " ", ":", " ", "*", " ",
+	"<", " ", ">", " ", "'", " ", `"`, " ", `\`, " ",
+)
+
+// booleanQuery returns full-text query in boolean mode requiring every word
+// of text. Words are quoted, so operators inside them are not interpreted
+// and, with ngram parser, they match anywhere in indexed fields. Words
+// shorter than n-gram cannot be quoted and match only by prefix.
+func booleanQuery(text string) string {
+	var terms []string
+	for _, w := range strings.Fields(text) {
+		w = strings.ReplaceAll(w, `"`, "")
+		if utf8.RuneCountInString(w) >= ngramTokenSize {
+			terms = append(terms, `+"`+w+`"`)
+			continue
+		}
+		r, _ := utf8.DecodeRuneInString(w)
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			terms = append(terms, "+"+w+"*")
+		}
+	}
+	return strings.Join(terms, " ")
+}
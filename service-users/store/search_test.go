@@ -0,0 +1,63 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBooleanQuery(t *testing.T) {
+	testCases := []struct {
+		text string
+		exp  string
+	}{
+		{text: "john cash", exp: `+"john" +"cash"`},
+		{text: "  johnny@test.com ", exp: `+"johnny@test.com"`},
+		{text: `john "cash`, exp: `+"john" +"cash"`},
+		{text: "john c", exp: `+"john" +c*`},
+		{text: "john - +", exp: `+"john"`},
+		{text: `""`, exp: ""},
+		{text: "", exp: ""},
+	}
+	for _, tC := range testCases {
+		if got := booleanQuery(tC.text); got != tC.exp {
+			t.Errorf("Expected query of %q to be %s, got: %s", tC.text, tC.exp, got)
+		}
+	}
+}
+
+func TestPrefixTerms(t *testing.T) {
+	testCases := []struct {
+		text string
+		exp  []string
+	}{
+		{text: "John Cash", exp: []string{"john:*", "cash:*"}},
+		{text: "  johnny@test.com ", exp: []string{"johnny@test.com:*"}},
+		{text: "john&cash", exp: []string{"john:*", "cash:*"}},
+		{text: "john - !(c", exp: []string{"john:*", "c:*"}},
+		{text: `'':*`, exp: nil},
+		{text: "", exp: nil},
+	}
+	for _, tC := range testCases {
+		if diff := cmp.Diff(tC.exp, prefixTerms(tC.text)); diff != "" {
+			t.Errorf("Unexpected terms of %q (-want +got):\n%s", tC.text, diff)
+		}
+	}
+}
+
+func TestNgrams(t *testing.T) {
+	testCases := []struct {
+		word string
+		exp  []string
+	}{
+		{word: "jonny", exp: []string{"jo", "on", "nn", "ny"}},
+		{word: "żół", exp: []string{"żó", "ół"}},
+		{word: "jo", exp: []string{"jo"}},
+		{word: "j", exp: []string{"j"}},
+	}
+	for _, tC := range testCases {
+		if diff := cmp.Diff(tC.exp, ngrams(tC.word)); diff != "" {
+			t.Errorf("Unexpected n-grams of %q (-want +got):\n%s", tC.word, diff)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"modernc.org/sqlite"
+)
+
+// NewSQLite returns SQLite store, meant for local development and tests.
+// Reads of users are spread across replicas, if any are given, the same as
+// in other stores. Schema has to be migrated with migrations of
+// store/migrations/sqlite. Deletes cascade only if foreign keys are enabled
+// by DSN, e.g. "users.db?_pragma=foreign_keys(1)".
+//
+// SQLite locks whole database on write, so db should be limited to single
+// connection, which is required anyway for in-memory database. Search is
+// not backed by any index.
+func NewSQLite(db *sql.DB, replicas ...*sql.DB) *store {
+	return newStore(sqliteDialect, db, replicas)
+}
+
+// Result codes of SQLite, extended ones carry primary code in lower byte.
+const (
+	sqliteBusy                 = 5
+	sqliteLocked               = 6
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// isSQLiteUniqueViolation tells whether err is violation of unique index or
+// primary key.
+func isSQLiteUniqueViolation(err error) bool {
+	var se *sqlite.Error
+	return errors.As(err, &se) && (se.Code() == sqliteConstraintUnique || se.Code() == sqliteConstraintPrimaryKey)
+}
+
+// classifySQLiteErr tells whether err is transient, ok is false if err was
+// not returned by SQLite.
+func classifySQLiteErr(err error) (errorClass, bool) {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return errPermanent, false
+	}
+	switch se.Code() & 0xff {
+	case sqliteBusy, sqliteLocked:
+		return errConflict, true
+	}
+	return errPermanent, true
+}
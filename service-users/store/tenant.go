@@ -0,0 +1,23 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+// ErrNoTenant is returned by tenant scoped methods called without tenant in
+// context.
+var ErrNoTenant = errors.New("missing tenant in context")
+
+// tenantFromContext returns tenant which scopes all queries of request.
+// There is no fallback to default tenant, so call without tenant, e.g. from
+// background job, fails instead of reaching users of other tenants.
+func tenantFromContext(ctx context.Context) (string, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	return id, nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTenantScopedMethodsRequireTenant(t *testing.T) {
+	// Store without db panics if any query is run.
+	s := &store{}
+	ctx := context.Background()
+	calls := map[string]func() error{
+		"CreateUser": func() error {
+			_, err := s.CreateUser(ctx, &User{})
+			return err
+		},
+		"GetUser": func() error {
+			_, err := s.GetUser(ctx, "id-1")
+			return err
+		},
+		"ListUsers": func() error {
+			_, err := s.ListUsers(ctx, ListUsersFilter{}, "", 10)
+			return err
+		},
+		"DeleteUser": func() error {
+			_, err := s.DeleteUser(ctx, "id-1")
+			return err
+		},
+		"BatchGetUsers": func() error {
+			_, err := s.BatchGetUsers(ctx, []string{"id-1"})
+			return err
+		},
+		"SearchUsers": func() error {
+			_, err := s.SearchUsers(ctx, SearchQuery{Text: "john"}, 0, 10)
+			return err
+		},
+		"ListUserHistory": func() error {
+			_, err := s.ListUserHistory(ctx, "id-1", 0, 10)
+			return err
+		},
+		"GetUserData": func() error {
+			_, err := s.GetUserData(ctx, "id-1")
+			return err
+		},
+		"EraseUser": func() error {
+			_, err := s.EraseUser(ctx, "id-1", "DSR-1")
+			return err
+		},
+		"ListWebhookSubscriptions": func() error {
+			_, err := s.ListWebhookSubscriptions(ctx, "", 10)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, ErrNoTenant) {
+				t.Errorf("Expected ErrNoTenant, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrWebhookSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrWebhookDeliveryNotFound     = errors.New("webhook delivery not found")
+)
+
+// Statuses of webhook delivery.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// StringList is list of strings stored as comma separated text column.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+func (l *StringList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+	*l = nil
+	if s == "" {
+		return nil
+	}
+	*l = strings.Split(s, ",")
+	return nil
+}
+
+// WebhookSubscription represents webhook subscription on store side.
+type WebhookSubscription struct {
+	ID         string     `db:"id"`
+	TenantID   string     `db:"tenant_id"`
+	URL        string     `db:"url"`
+	EventTypes StringList `db:"event_types"`
+	Secret     string     `db:"secret"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+// WebhookDelivery represents delivery of single event to single subscription.
+type WebhookDelivery struct {
+	ID             string `db:"id"`
+	TenantID       string `db:"tenant_id"`
+	SubscriptionID string `db:"subscription_id"`
+	EventID        string `db:"event_id"`
+	EventType      string `db:"event_type"`
+	// UserID is id of user which event is about, if any.
+	UserID  string `db:"user_id"`
+	Payload []byte `db:"payload"`
+	// PayloadEncrypted is set if Payload is sealed with data key of user.
+	// Claimed deliveries have payload decrypted.
+	PayloadEncrypted bool         `db:"payload_encrypted"`
+	Status           string       `db:"status"`
+	AttemptCount     int          `db:"attempt_count"`
+	NextAttemptAt    sql.NullTime `db:"next_attempt_at"`
+	CreatedAt        time.Time    `db:"created_at"`
+	UpdatedAt        time.Time    `db:"updated_at"`
+}
+
+// WebhookDeliveryAttempt represents single attempt of webhook delivery.
+type WebhookDeliveryAttempt struct {
+	DeliveryID     string    `db:"delivery_id"`
+	Number         int       `db:"number"`
+	AttemptedAt    time.Time `db:"attempted_at"`
+	ResponseStatus int       `db:"response_status"`
+	Error          string    `db:"error"`
+	DurationMs     int64     `db:"duration_ms"`
+}
+
+func (s *store) CreateWebhookSubscription(ctx context.Context, in *WebhookSubscription) (*WebhookSubscription, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	uuid, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	in.ID = uuid.String()
+	in.TenantID = tenantID
+	in.CreatedAt = time.Now().UTC()
+	if _, err := s.db.NamedExecContext(ctx, queryInsertWebhookSubscription, in); err != nil {
+		return nil, fmt.Errorf("failed to insert webhook subscription: %w", err)
+	}
+	return in, nil
+}
+
+func (s *store) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out WebhookSubscription
+	if err := s.db.GetContext(ctx, &out, s.dialect.rebind(querySelectWebhookSubscriptionById), id, tenantID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookSubscriptionNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return &out, nil
+}
+
+func (s *store) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind(queryDeleteWebhookSubscription), id, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cannot check affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrWebhookSubscriptionNotFound
+	}
+	return nil
+}
+
+// ListWebhookSubscriptions returns up to limit subscriptions ordered by id,
+// starting after given id.
+func (s *store) ListWebhookSubscriptions(ctx context.Context, afterID string, limit int) ([]*WebhookSubscription, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*WebhookSubscription
+	if err := s.db.SelectContext(ctx, &out, s.dialect.rebind(querySelectWebhookSubscriptions), tenantID, afterID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	return out, nil
+}
+
+// ListWebhookSubscriptionsByEventType returns all subscriptions of tenant
+// interested in given event type.
+func (s *store) ListWebhookSubscriptionsByEventType(ctx context.Context, eventType string) ([]*WebhookSubscription, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*WebhookSubscription
+	if err := s.db.SelectContext(ctx, &out, s.dialect.rebind(s.dialect.querySelectWebhookSubscriptionsByEventType), tenantID, eventType); err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	return out, nil
+}
+
+// CreateWebhookDeliveries inserts all deliveries in single transaction.
+func (s *store) CreateWebhookDeliveries(ctx context.Context, in []*WebhookDelivery) error {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(in) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	// Payloads are sealed before transaction, as data keys are read
+	// outside of it.
+	keys := map[string][]byte{}
+	for _, d := range in {
+		d.TenantID = tenantID
+		if err := s.sealPayload(ctx, d, keys); err != nil {
+			return err
+		}
+	}
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		for _, d := range in {
+			uuid, err := uuid.NewRandom()
+			if err != nil {
+				return fmt.Errorf("failed to generate uuid: %w", err)
+			}
+			d.ID = uuid.String()
+			d.TenantID = tenantID
+			d.CreatedAt = now
+			d.UpdatedAt = now
+			if _, err := tx.NamedExecContext(ctx, queryInsertWebhookDelivery, d); err != nil {
+				return fmt.Errorf("failed to insert webhook delivery: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) GetWebhookDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out WebhookDelivery
+	if err := s.db.GetContext(ctx, &out, s.dialect.rebind(querySelectWebhookDeliveryById), id, tenantID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	return &out, nil
+}
+
+// ListWebhookDeliveries returns up to limit deliveries of given subscription
+// ordered by id, starting after given id. Empty status matches all deliveries.
+func (s *store) ListWebhookDeliveries(ctx context.Context, subscriptionID, status, afterID string, limit int) ([]*WebhookDelivery, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*WebhookDelivery
+	if err := s.db.SelectContext(ctx, &out, s.dialect.rebind(querySelectWebhookDeliveries), tenantID, subscriptionID, status, status, afterID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return out, nil
+}
+
+func (s *store) ListWebhookDeliveryAttempts(ctx context.Context, deliveryID string) ([]*WebhookDeliveryAttempt, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*WebhookDeliveryAttempt
+	if err := s.db.SelectContext(ctx, &out, s.dialect.rebind(querySelectWebhookDeliveryAttempts), deliveryID, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to list webhook delivery attempts: %w", err)
+	}
+	return out, nil
+}
+
+// ClaimDueWebhookDeliveries returns up to limit pending deliveries which are due
+// at given time. Claimed deliveries have next attempt postponed until leaseUntil,
+// so they are not picked by other dispatchers in the meantime. Deliveries of
+// all tenants are claimed. Payloads are decrypted, payload of user which was
+// purged since is nil.
+func (s *store) ClaimDueWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*WebhookDelivery, error) {
+	var out []*WebhookDelivery
+	err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &out, s.dialect.rebind(querySelectDueWebhookDeliveries), WebhookDeliveryPending, now, limit); err != nil {
+			return fmt.Errorf("failed to select due webhook deliveries: %w", err)
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		ids := make([]string, 0, len(out))
+		for _, d := range out {
+			ids = append(ids, d.ID)
+		}
+		query, args, err := sqlx.In(queryLeaseWebhookDeliveries, leaseUntil, ids)
+		if err != nil {
+			return fmt.Errorf("failed to build lease query: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), args...); err != nil {
+			return fmt.Errorf("failed to lease webhook deliveries: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string][]byte{}
+	for _, d := range out {
+		if err := s.openPayload(ctx, d, keys); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// RecordWebhookDeliveryAttempt stores attempt and updates delivery state
+// (status, attempt count and next attempt) in single transaction.
+func (s *store) RecordWebhookDeliveryAttempt(ctx context.Context, delivery *WebhookDelivery, attempt *WebhookDeliveryAttempt) error {
+	delivery.UpdatedAt = time.Now().UTC()
+	attempt.DeliveryID = delivery.ID
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.NamedExecContext(ctx, queryInsertWebhookDeliveryAttempt, attempt); err != nil {
+			return fmt.Errorf("failed to insert webhook delivery attempt: %w", err)
+		}
+		res, err := tx.NamedExecContext(ctx, queryUpdateWebhookDelivery, delivery)
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("cannot check affected rows: %w", err)
+		}
+		if affected == 0 {
+			return ErrWebhookDeliveryNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Version and Commit of service are set at build time, e.g.
+// go build -ldflags "-X <module>/telemetry.Version=v1.2.0 -X <module>/telemetry.Commit=$(git rev-parse HEAD)".
+// Without them version of main module is reported, which is "(devel)" for
+// local builds.
+var (
+	Version string
+	Commit  string
+)
+
+// Module is dependency of service.
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+	// Replace is module replacing this one, if any.
+	Replace *Module `json:"replace,omitempty"`
+}
+
+// BuildInfo describes build of running service.
+type BuildInfo struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	GoVersion string    `json:"go_version"`
+	Path      string    `json:"path"`
+	Deps      []*Module `json:"deps"`
+}
+
+// ReadBuildInfo returns build info of running service. Module info is
+// missing if binary was built without module support.
+func ReadBuildInfo() *BuildInfo {
+	out := &BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return out
+	}
+	out.Path = info.Main.Path
+	if out.Version == "" {
+		out.Version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		out.Deps = append(out.Deps, newModule(dep))
+	}
+	return out
+}
+
+func newModule(m *debug.Module) *Module {
+	out := &Module{Path: m.Path, Version: m.Version, Sum: m.Sum}
+	if m.Replace != nil {
+		out.Replace = newModule(m.Replace)
+	}
+	return out
+}
+
+var buildInfoGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "users_build_info",
+	Help: "Build of running service, always 1.",
+}, []string{"version", "commit", "go_version"})
+
+func init() {
+	info := ReadBuildInfo()
+	buildInfoGauge.WithLabelValues(info.Version, info.Commit, info.GoVersion).Set(1)
+}
+
+// buildInfoHandler serves BuildInfo as JSON.
+func buildInfoHandler(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(ReadBuildInfo()); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,85 @@
+package telemetry
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DebugHandler returns handler of pprof endpoints under /debug/pprof/ and
+// runtime stats under /debug/runtime. Profiles expose internals of service
+// and profiling slows it down, so they should be served only on demand. If
+// token is not empty, callers must pass it as bearer token in Authorization
+// header.
+func DebugHandler(token string) http.Handler {
+	s := http.NewServeMux()
+	s.HandleFunc("/debug/pprof/", pprof.Index)
+	s.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.HandleFunc("/debug/runtime", runtimeHandler)
+	if token == "" {
+		return s
+	}
+	return RequireToken(token, s)
+}
+
+// RequireToken returns handler which passes to h only requests with token
+// as bearer token in Authorization header.
+func RequireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		got := strings.TrimPrefix(header, "Bearer ")
+		if got == header || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(rw, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+var startTime = time.Now()
+
+// runtimeStats are stats of Go runtime served by runtimeHandler.
+type runtimeStats struct {
+	GoVersion    string `json:"go_version"`
+	Uptime       string `json:"uptime"`
+	NumCPU       int    `json:"num_cpu"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	NumGoroutine int    `json:"num_goroutine"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	GCPauseTotal string `json:"gc_pause_total"`
+	GCPauseLast  string `json:"gc_pause_last"`
+}
+
+func runtimeHandler(rw http.ResponseWriter, _ *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := runtimeStats{
+		GoVersion:    runtime.Version(),
+		Uptime:       time.Since(startTime).Round(time.Second).String(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumGoroutine: runtime.NumGoroutine(),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapObjects:  mem.HeapObjects,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		GCPauseTotal: time.Duration(mem.PauseTotalNs).String(),
+	}
+	if mem.NumGC > 0 {
+		stats.GCPauseLast = time.Duration(mem.PauseNs[(mem.NumGC+255)%256]).String()
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(stats); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
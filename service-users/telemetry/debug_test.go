@@ -0,0 +1,81 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		token     string
+		header    string
+		path      string
+		expStatus int
+	}{
+		{
+			desc:      "no token required",
+			path:      "/debug/pprof/",
+			expStatus: http.StatusOK,
+		},
+		{
+			desc:      "missing token",
+			token:     "secret",
+			path:      "/debug/pprof/",
+			expStatus: http.StatusUnauthorized,
+		},
+		{
+			desc:      "invalid token",
+			token:     "secret",
+			header:    "Bearer other",
+			path:      "/debug/runtime",
+			expStatus: http.StatusUnauthorized,
+		},
+		{
+			desc:      "token without bearer scheme",
+			token:     "secret",
+			header:    "secret",
+			path:      "/debug/runtime",
+			expStatus: http.StatusUnauthorized,
+		},
+		{
+			desc:      "valid token",
+			token:     "secret",
+			header:    "Bearer secret",
+			path:      "/debug/runtime",
+			expStatus: http.StatusOK,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tC.path, nil)
+			if tC.header != "" {
+				req.Header.Set("Authorization", tC.header)
+			}
+			rec := httptest.NewRecorder()
+			DebugHandler(tC.token).ServeHTTP(rec, req)
+			if rec.Code != tC.expStatus {
+				t.Errorf("Expected status %d, got: %d", tC.expStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestBuildInfoHandler(t *testing.T) {
+	Version, Commit = "v1.2.0", "abc123"
+	defer func() { Version, Commit = "", "" }()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/buildinfo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got: %d", rec.Code)
+	}
+	var got BuildInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode build info: %v", err)
+	}
+	if got.Version != "v1.2.0" || got.Commit != "abc123" || got.GoVersion == "" {
+		t.Errorf("Unexpected build info: %+v", got)
+	}
+}
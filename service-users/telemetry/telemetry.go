@@ -7,8 +7,13 @@ import (
 )
 
 // Serve basic telemetry info on given address.
-// Right now it serves health checks and prometheus metrics.
+// Right now it serves health checks, prometheus metrics and build info.
 func Serve(addr string) error {
+	return http.ListenAndServe(addr, Handler())
+}
+
+// Handler returns handler of endpoints served by Serve.
+func Handler() http.Handler {
 	s := http.NewServeMux()
 	s.HandleFunc("/healthz", func(rw http.ResponseWriter, _ *http.Request) {
 		rw.WriteHeader(http.StatusOK)
@@ -17,7 +22,6 @@ func Serve(addr string) error {
 		rw.WriteHeader(http.StatusOK)
 	})
 	s.Handle("/metrics", promhttp.Handler())
-
-	// TODO: in future pprof info can be added here.
-	return http.ListenAndServe(addr, s)
+	s.HandleFunc("/buildinfo", buildInfoHandler)
+	return s
 }
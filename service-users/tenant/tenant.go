@@ -0,0 +1,89 @@
+// Package tenant passes tenant of request via context and bounds tenant
+// label values of metrics.
+package tenant
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Default is tenant of callers which do not belong to any other tenant,
+// e.g. when authentication is disabled.
+const Default = "default"
+
+// OtherLabel is label value of all tenants not listed in Labels.
+const OtherLabel = "other"
+
+type ctxKey struct{}
+
+// NewContext returns context carrying given tenant id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns tenant id of request.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKey{}).(string)
+	return id, ok && id != ""
+}
+
+var (
+	labelsMu sync.RWMutex
+	labels   = map[string]bool{Default: true}
+)
+
+// SetLabels sets tenants which have their own value of tenant label in
+// metrics. Number of tenants is unbounded, so all others share OtherLabel.
+func SetLabels(tenants []string) {
+	l := map[string]bool{Default: true}
+	for _, t := range tenants {
+		l[t] = true
+	}
+	labelsMu.Lock()
+	labels = l
+	labelsMu.Unlock()
+}
+
+// Label returns value of tenant label of metrics for given tenant.
+func Label(id string) string {
+	labelsMu.RLock()
+	defer labelsMu.RUnlock()
+	if labels[id] {
+		return id
+	}
+	return OtherLabel
+}
+
+var requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "users_tenant_requests_total",
+	Help: "Total number of gRPC requests by tenant and code. Tenants not set with SetLabels are counted as other.",
+}, []string{"tenant", "code"})
+
+// UnaryServerInterceptor counts requests by tenant. It must be chained after
+// interceptor setting tenant, i.e. authentication.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		countRequest(ctx, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, stream)
+		countRequest(stream.Context(), err)
+		return err
+	}
+}
+
+func countRequest(ctx context.Context, err error) {
+	id, _ := FromContext(ctx)
+	requestsTotal.WithLabelValues(Label(id), status.Code(err).String()).Inc()
+}
@@ -0,0 +1,33 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLabel(t *testing.T) {
+	SetLabels([]string{"acme"})
+	defer SetLabels(nil)
+	for id, exp := range map[string]string{
+		Default:  Default,
+		"acme":   "acme",
+		"globex": OtherLabel,
+		"":       OtherLabel,
+	} {
+		if got := Label(id); got != exp {
+			t.Errorf("Expected label of %q to be %q, got: %q", id, exp, got)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Errorf("Expected no tenant in empty context")
+	}
+	if _, ok := FromContext(NewContext(context.Background(), "")); ok {
+		t.Errorf("Expected empty tenant not to be returned")
+	}
+	if got, _ := FromContext(NewContext(context.Background(), "acme")); got != "acme" {
+		t.Errorf("Expected tenant acme, got: %q", got)
+	}
+}
@@ -0,0 +1,194 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+	"github.com/tobiaszheller/example-go-microservice/service-users/tenant"
+)
+
+type deliveriesStorer interface {
+	GetWebhookSubscription(context.Context, string) (*store.WebhookSubscription, error)
+	ClaimDueWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]*store.WebhookDelivery, error)
+	RecordWebhookDeliveryAttempt(context.Context, *store.WebhookDelivery, *store.WebhookDeliveryAttempt) error
+}
+
+// errPayloadUnavailable is error of delivery, payload of which can't be
+// decrypted anymore, as user it is about was purged.
+var errPayloadUnavailable = errors.New("payload is not available, user was purged")
+
+// DispatcherConfig configures delivery of webhooks.
+type DispatcherConfig struct {
+	// PollInterval is how often due deliveries are checked.
+	PollInterval time.Duration
+	// BatchSize is max number of deliveries claimed at once.
+	BatchSize int
+	// Timeout of single HTTP request.
+	Timeout time.Duration
+	// MaxAttempts after which delivery is marked as failed.
+	MaxAttempts int
+	// InitialBackoff is delay before second attempt, every next delay is doubled
+	// up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Dispatcher sends scheduled webhook deliveries.
+type Dispatcher struct {
+	cfg    DispatcherConfig
+	storer deliveriesStorer
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewDispatcher returns dispatcher which refuses to connect to addresses in
+// internal network, see CheckTarget.
+func NewDispatcher(cfg DispatcherConfig, storer deliveriesStorer) *Dispatcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: dialControl}
+	return &Dispatcher{
+		cfg:    cfg,
+		storer: storer,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		now: time.Now,
+	}
+}
+
+// Run dispatches due deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.DispatchDue(ctx); err != nil {
+			log.WithError(err).Error("Failed to dispatch webhooks")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue sends single batch of due deliveries.
+func (d *Dispatcher) DispatchDue(ctx context.Context) error {
+	now := d.now().UTC()
+	// Deliveries are leased for longer than all requests can take, so
+	// no other dispatcher picks them before attempts are recorded.
+	lease := now.Add(time.Duration(d.cfg.BatchSize+1) * d.cfg.Timeout)
+	deliveries, err := d.storer.ClaimDueWebhookDeliveries(ctx, now, lease, d.cfg.BatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+	// Failure of single delivery must not hold back the rest of batch, it
+	// is retried by any dispatcher once its lease expires.
+	for _, delivery := range deliveries {
+		if err := d.deliver(ctx, delivery); err != nil {
+			log.WithError(err).WithField("delivery_id", delivery.ID).Error("Failed to deliver webhook")
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *store.WebhookDelivery) error {
+	attempt := &store.WebhookDeliveryAttempt{
+		Number:      delivery.AttemptCount + 1,
+		AttemptedAt: d.now().UTC(),
+	}
+	// Subscription could be removed in the meantime, in such case there is
+	// no one to deliver to and delivery fails without retries. The same
+	// applies to payload encrypted with data key of user which was purged.
+	giveUp := false
+	ctx = tenant.NewContext(ctx, delivery.TenantID)
+	sub, err := d.storer.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	switch {
+	case len(delivery.Payload) == 0:
+		attempt.Error = errPayloadUnavailable.Error()
+		giveUp = true
+	case errors.Is(err, store.ErrWebhookSubscriptionNotFound):
+		attempt.Error = err.Error()
+		giveUp = true
+	case err != nil:
+		return fmt.Errorf("failed to get subscription: %w", err)
+	default:
+		attempt.ResponseStatus, err = d.send(ctx, sub, delivery, attempt.AttemptedAt)
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+	}
+	attempt.DurationMs = d.now().UTC().Sub(attempt.AttemptedAt).Milliseconds()
+
+	delivery.AttemptCount++
+	switch {
+	case attempt.Error == "":
+		delivery.Status = store.WebhookDeliverySucceeded
+		delivery.NextAttemptAt = sql.NullTime{}
+	case giveUp || delivery.AttemptCount >= d.cfg.MaxAttempts:
+		delivery.Status = store.WebhookDeliveryFailed
+		delivery.NextAttemptAt = sql.NullTime{}
+	default:
+		delivery.NextAttemptAt = sql.NullTime{
+			Time:  attempt.AttemptedAt.Add(d.backoff(delivery.AttemptCount)),
+			Valid: true,
+		}
+	}
+	log.WithFields(log.Fields{
+		"delivery_id": delivery.ID,
+		"attempt":     attempt.Number,
+		"status":      delivery.Status,
+		"error":       attempt.Error,
+	}).Info("Webhook delivery attempted")
+	if err := d.storer.RecordWebhookDeliveryAttempt(ctx, delivery, attempt); err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+	return nil
+}
+
+// send posts delivery payload and returns response status code.
+func (d *Dispatcher) send(ctx context.Context, sub *store.WebhookSubscription, delivery *store.WebhookDelivery, at time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderEventType, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(at.Unix()))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, at, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns delay after given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
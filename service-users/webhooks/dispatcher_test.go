@@ -0,0 +1,203 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func TestDispatchDue(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	cfg := DispatcherConfig{
+		BatchSize:      10,
+		Timeout:        time.Second,
+		MaxAttempts:    3,
+		InitialBackoff: time.Minute,
+		MaxBackoff:     time.Hour,
+	}
+	testCases := []struct {
+		desc         string
+		respStatus   int
+		attemptCount int
+		noSub        bool
+		noPayload    bool
+		expDelivery  store.WebhookDelivery
+		expAttempt   store.WebhookDeliveryAttempt
+	}{
+		{
+			desc:       "receiver accepted delivery",
+			respStatus: http.StatusNoContent,
+			expDelivery: store.WebhookDelivery{
+				Status:       store.WebhookDeliverySucceeded,
+				AttemptCount: 1,
+			},
+			expAttempt: store.WebhookDeliveryAttempt{Number: 1, ResponseStatus: http.StatusNoContent},
+		},
+		{
+			desc:         "receiver failed, retry with backoff",
+			respStatus:   http.StatusInternalServerError,
+			attemptCount: 1,
+			expDelivery: store.WebhookDelivery{
+				Status:        store.WebhookDeliveryPending,
+				AttemptCount:  2,
+				NextAttemptAt: sql.NullTime{Time: now.Add(2 * time.Minute), Valid: true},
+			},
+			expAttempt: store.WebhookDeliveryAttempt{Number: 2, ResponseStatus: http.StatusInternalServerError, Error: "unexpected response status: 500"},
+		},
+		{
+			desc:         "receiver failed, no more attempts",
+			respStatus:   http.StatusBadGateway,
+			attemptCount: 2,
+			expDelivery: store.WebhookDelivery{
+				Status:       store.WebhookDeliveryFailed,
+				AttemptCount: 3,
+			},
+			expAttempt: store.WebhookDeliveryAttempt{Number: 3, ResponseStatus: http.StatusBadGateway, Error: "unexpected response status: 502"},
+		},
+		{
+			desc:  "subscription removed",
+			noSub: true,
+			expDelivery: store.WebhookDelivery{
+				Status:       store.WebhookDeliveryFailed,
+				AttemptCount: 1,
+			},
+			expAttempt: store.WebhookDeliveryAttempt{Number: 1, Error: "webhook subscription not found"},
+		},
+		{
+			desc:       "user purged, payload not available",
+			respStatus: http.StatusNoContent,
+			noPayload:  true,
+			expDelivery: store.WebhookDelivery{
+				Status:       store.WebhookDeliveryFailed,
+				AttemptCount: 1,
+			},
+			expAttempt: store.WebhookDeliveryAttempt{Number: 1, Error: "payload is not available, user was purged"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var gotErr error
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				gotErr = Verify("secret-secret-secret", r.Header, body, time.Minute, now)
+				rw.WriteHeader(tC.respStatus)
+			}))
+			defer srv.Close()
+			ms := &mockStore{
+				delivery: &store.WebhookDelivery{
+					ID:             "delivery-1",
+					SubscriptionID: "sub-1",
+					Payload:        []byte(`{}`),
+					Status:         store.WebhookDeliveryPending,
+					AttemptCount:   tC.attemptCount,
+				},
+			}
+			if tC.noPayload {
+				ms.delivery.Payload = nil
+			}
+			if !tC.noSub {
+				ms.sub = &store.WebhookSubscription{ID: "sub-1", URL: srv.URL, Secret: "secret-secret-secret"}
+			}
+			d := NewDispatcher(cfg, ms)
+			d.now = func() time.Time { return now }
+			// Test receiver listens on loopback, which is refused by default.
+			d.client = srv.Client()
+
+			if err := d.DispatchDue(context.Background()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if gotErr != nil {
+				t.Errorf("Receiver failed to verify signature: %v", gotErr)
+			}
+			exp := tC.expDelivery
+			exp.ID, exp.SubscriptionID, exp.Payload = "delivery-1", "sub-1", ms.delivery.Payload
+			if diff := cmp.Diff(exp, *ms.recordedDelivery); diff != "" {
+				t.Errorf("Delivery mismatch, diff: %s", diff)
+			}
+			expAttempt := tC.expAttempt
+			expAttempt.AttemptedAt = now
+			if diff := cmp.Diff(expAttempt, *ms.recordedAttempt); diff != "" {
+				t.Errorf("Attempt mismatch, diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestDispatchDueContinuesAfterError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	bs := &batchStore{
+		sub: &store.WebhookSubscription{ID: "sub-1", URL: srv.URL, Secret: "secret-secret-secret"},
+		deliveries: []*store.WebhookDelivery{
+			{ID: "delivery-1", SubscriptionID: "broken", Payload: []byte(`{}`), Status: store.WebhookDeliveryPending},
+			{ID: "delivery-2", SubscriptionID: "sub-1", Payload: []byte(`{}`), Status: store.WebhookDeliveryPending},
+		},
+	}
+	d := NewDispatcher(DispatcherConfig{BatchSize: 10, Timeout: time.Second, MaxAttempts: 3}, bs)
+	d.client = srv.Client()
+
+	if err := d.DispatchDue(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"delivery-2"}, bs.recorded); diff != "" {
+		t.Errorf("Recorded deliveries mismatch, diff: %s", diff)
+	}
+}
+
+// batchStore fails to get subscription "broken" and records ids of
+// delivered deliveries.
+type batchStore struct {
+	sub        *store.WebhookSubscription
+	deliveries []*store.WebhookDelivery
+	recorded   []string
+}
+
+func (b *batchStore) GetWebhookSubscription(_ context.Context, id string) (*store.WebhookSubscription, error) {
+	if id == "broken" {
+		return nil, errors.New("connection refused")
+	}
+	return b.sub, nil
+}
+
+func (b *batchStore) ClaimDueWebhookDeliveries(context.Context, time.Time, time.Time, int) ([]*store.WebhookDelivery, error) {
+	return b.deliveries, nil
+}
+
+func (b *batchStore) RecordWebhookDeliveryAttempt(_ context.Context, d *store.WebhookDelivery, _ *store.WebhookDeliveryAttempt) error {
+	b.recorded = append(b.recorded, d.ID)
+	return nil
+}
+
+type mockStore struct {
+	sub              *store.WebhookSubscription
+	delivery         *store.WebhookDelivery
+	recordedDelivery *store.WebhookDelivery
+	recordedAttempt  *store.WebhookDeliveryAttempt
+}
+
+func (m *mockStore) GetWebhookSubscription(context.Context, string) (*store.WebhookSubscription, error) {
+	if m.sub == nil {
+		return nil, store.ErrWebhookSubscriptionNotFound
+	}
+	return m.sub, nil
+}
+
+func (m *mockStore) ClaimDueWebhookDeliveries(context.Context, time.Time, time.Time, int) ([]*store.WebhookDelivery, error) {
+	return []*store.WebhookDelivery{m.delivery}, nil
+}
+
+func (m *mockStore) RecordWebhookDeliveryAttempt(_ context.Context, d *store.WebhookDelivery, a *store.WebhookDeliveryAttempt) error {
+	m.recordedDelivery, m.recordedAttempt = d, a
+	return nil
+}
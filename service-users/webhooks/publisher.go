@@ -0,0 +1,146 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/tobiaszheller/example-go-microservice/service-users/proto"
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+// EventTypes lists types of events to which webhooks can subscribe.
+var EventTypes = []string{
+	EventType(&pb.UserCreated{}),
+	EventType(&pb.UserUpdated{}),
+	EventType(&pb.UserDeleted{}),
+	EventType(&pb.UserRestored{}),
+	EventType(&pb.UserPurged{}),
+	EventType(&pb.UserErased{}),
+}
+
+type eventsPublisher interface {
+	Publish(context.Context, proto.Message) error
+}
+
+type subscriptionsStorer interface {
+	ListWebhookSubscriptionsByEventType(context.Context, string) ([]*store.WebhookSubscription, error)
+	CreateWebhookDeliveries(context.Context, []*store.WebhookDelivery) error
+	CreateUserEvent(context.Context, *store.UserEvent) error
+}
+
+// Event is body of every webhook delivery.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type publisher struct {
+	next   eventsPublisher
+	storer subscriptionsStorer
+	now    func() time.Time
+}
+
+// NewPublisher returns events publisher which passes events to next publisher,
+// records them in log of user events and then schedules webhook deliveries
+// to all subscriptions interested in them. Deliveries are sent
+// asynchronously by Dispatcher.
+func NewPublisher(next eventsPublisher, storer subscriptionsStorer) *publisher {
+	return &publisher{
+		next:   next,
+		storer: storer,
+		now:    time.Now,
+	}
+}
+
+func (p *publisher) Publish(ctx context.Context, in proto.Message) error {
+	if err := p.next.Publish(ctx, in); err != nil {
+		return err
+	}
+	eventType := EventType(in)
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	now := p.now().UTC()
+	userID := eventUserID(in)
+	if userID != "" {
+		err := p.storer.CreateUserEvent(ctx, &store.UserEvent{
+			ID:        id.String(),
+			UserID:    userID,
+			Type:      eventType,
+			CreatedAt: now,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record user event: %w", err)
+		}
+	}
+	subs, err := p.storer.ListWebhookSubscriptionsByEventType(ctx, eventType)
+	if err != nil {
+		return fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	data, err := protojson.Marshal(proto.MessageV2(in))
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	payload, err := json.Marshal(Event{
+		ID:        id.String(),
+		Type:      eventType,
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+	deliveries := make([]*store.WebhookDelivery, 0, len(subs))
+	for _, sub := range subs {
+		deliveries = append(deliveries, &store.WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        id.String(),
+			EventType:      eventType,
+			UserID:         userID,
+			Payload:        payload,
+			Status:         store.WebhookDeliveryPending,
+			NextAttemptAt:  sql.NullTime{Time: now, Valid: true},
+		})
+	}
+	if err := p.storer.CreateWebhookDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("failed to schedule webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// eventUserID returns id of user which event is about.
+func eventUserID(in proto.Message) string {
+	switch ev := in.(type) {
+	case *pb.UserCreated:
+		return ev.GetUser().GetId()
+	case *pb.UserUpdated:
+		return ev.GetUser().GetId()
+	case *pb.UserDeleted:
+		return ev.GetUser().GetId()
+	case *pb.UserRestored:
+		return ev.GetUser().GetId()
+	case *pb.UserPurged:
+		return ev.GetId()
+	case *pb.UserErased:
+		return ev.GetId()
+	}
+	return ""
+}
+
+// EventType returns type of event as used in subscriptions, e.g. "UserCreated".
+func EventType(in proto.Message) string {
+	return string(proto.MessageV2(in).ProtoReflect().Descriptor().Name())
+}
@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set on every webhook delivery.
+const (
+	HeaderEventID   = "X-Webhook-Event-Id"
+	HeaderEventType = "X-Webhook-Event-Type"
+	HeaderDelivery  = "X-Webhook-Delivery-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	signaturePrefix = "v1="
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrTimestampExpired = errors.New("webhook timestamp outside of tolerance")
+)
+
+// Sign returns signature of body sent at given timestamp.
+// Signature is HMAC-SHA256 of "<unix timestamp>.<body>" keyed with
+// subscription secret, encoded as "v1=<hex>".
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature headers of received webhook request.
+// It is meant to be used by receivers written in Go. Requests with timestamp
+// older or newer than tolerance are rejected to prevent replays.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	unix, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	timestamp := time.Unix(unix, 0)
+	if d := now.Sub(timestamp); d > tolerance || d < -tolerance {
+		return ErrTimestampExpired
+	}
+	got := header.Get(HeaderSignature)
+	if !strings.HasPrefix(got, signaturePrefix) {
+		return fmt.Errorf("%w: unsupported version", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(got), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"event-1"}`)
+	header := func(ts time.Time, sig string) http.Header {
+		h := http.Header{}
+		h.Set(HeaderTimestamp, strconv.FormatInt(ts.Unix(), 10))
+		h.Set(HeaderSignature, sig)
+		return h
+	}
+	testCases := []struct {
+		desc   string
+		header http.Header
+		body   []byte
+		expErr error
+	}{
+		{
+			desc:   "valid signature",
+			header: header(now, Sign("secret", now, body)),
+			body:   body,
+		},
+		{
+			desc:   "body was modified",
+			header: header(now, Sign("secret", now, body)),
+			body:   []byte(`{"id":"event-2"}`),
+			expErr: ErrInvalidSignature,
+		},
+		{
+			desc:   "signed with other secret",
+			header: header(now, Sign("other", now, body)),
+			body:   body,
+			expErr: ErrInvalidSignature,
+		},
+		{
+			desc:   "timestamp outside of tolerance",
+			header: header(now.Add(-time.Hour), Sign("secret", now.Add(-time.Hour), body)),
+			body:   body,
+			expErr: ErrTimestampExpired,
+		},
+		{
+			desc:   "missing timestamp",
+			header: http.Header{HeaderSignature: []string{Sign("secret", now, body)}},
+			body:   body,
+			expErr: ErrInvalidSignature,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := Verify("secret", tC.header, tC.body, 5*time.Minute, now)
+			if !errors.Is(err, tC.expErr) {
+				t.Errorf("Expected err %v, got %v", tC.expErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// privateNets are ranges of private networks (RFC 1918, RFC 4193) and
+// shared address space of carrier-grade NAT (RFC 6598).
+var privateNets = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var out []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// CheckTarget returns error if webhooks must not be delivered to u, as it
+// points into internal network: to loopback, private or link-local address,
+// e.g. metadata service of cloud provider, or to localhost. Host names are
+// resolved only when delivery is sent, so they are checked again then.
+func CheckTarget(u *url.URL) error {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return checkIP(ip)
+	}
+	return nil
+}
+
+// checkIP returns error if ip is not public.
+func checkIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("address %s is not allowed", ip)
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("address %s is not allowed", ip)
+		}
+	}
+	return nil
+}
+
+// dialControl refuses connections to addresses which are not public. It
+// runs after host name is resolved, so it can't be bypassed by DNS
+// records pointing into internal network.
+func dialControl(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	return checkIP(ip)
+}
@@ -0,0 +1,74 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tobiaszheller/example-go-microservice/service-users/store"
+)
+
+func TestCheckTarget(t *testing.T) {
+	testCases := []struct {
+		url    string
+		expErr string
+	}{
+		{url: "https://example.com/hook"},
+		{url: "https://93.184.216.34/hook"},
+		{url: "http://localhost:8080/hook", expErr: "host localhost is not allowed"},
+		{url: "http://api.LOCALHOST./hook", expErr: "host api.localhost is not allowed"},
+		{url: "http://127.0.0.1/hook", expErr: "address 127.0.0.1 is not allowed"},
+		{url: "http://[::1]/hook", expErr: "address ::1 is not allowed"},
+		{url: "http://0.0.0.0/hook", expErr: "address 0.0.0.0 is not allowed"},
+		{url: "http://169.254.169.254/latest/meta-data", expErr: "address 169.254.169.254 is not allowed"},
+		{url: "http://10.1.2.3/hook", expErr: "address 10.1.2.3 is not allowed"},
+		{url: "http://172.20.0.1/hook", expErr: "address 172.20.0.1 is not allowed"},
+		{url: "http://192.168.1.1/hook", expErr: "address 192.168.1.1 is not allowed"},
+		{url: "http://[fd00::1]/hook", expErr: "address fd00::1 is not allowed"},
+		{url: "http://[::ffff:10.0.0.1]/hook", expErr: "address 10.0.0.1 is not allowed"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.url, func(t *testing.T) {
+			u, err := url.Parse(tC.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = CheckTarget(u)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tC.expErr {
+				t.Errorf("Expected error %q, got: %q", tC.expErr, gotErr)
+			}
+		})
+	}
+}
+
+func TestDispatcherRefusesInternalAddress(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+	// Dispatcher checks address it dials, whatever host name of subscription
+	// resolved to, so loopback receiver stands for DNS rebinding.
+	ms := &mockStore{
+		sub:      &store.WebhookSubscription{ID: "sub-1", URL: srv.URL, Secret: "secret-secret-secret"},
+		delivery: &store.WebhookDelivery{ID: "delivery-1", SubscriptionID: "sub-1", Payload: []byte(`{}`)},
+	}
+	d := NewDispatcher(DispatcherConfig{BatchSize: 1, Timeout: time.Second, MaxAttempts: 3}, ms)
+	if err := d.DispatchDue(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if called {
+		t.Error("Expected receiver in internal network not to be called")
+	}
+	if !strings.Contains(ms.recordedAttempt.Error, "address 127.0.0.1 is not allowed") {
+		t.Errorf("Expected attempt to fail on refused address, got: %q", ms.recordedAttempt.Error)
+	}
+}